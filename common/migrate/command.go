@@ -0,0 +1,95 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// ErrUnknownCommand is returned by Run for an unsupported subcommand
+	ErrUnknownCommand = errors.New("unknown migrate command")
+	// ErrDrifted is returned by the status command when the schema does not match the binary
+	ErrDrifted = errors.New("database schema has drifted from this binary")
+)
+
+// Run executes a migrate subcommand and writes a human-readable report to out
+func Run(ctx context.Context, m *Migrator, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied   %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return nil
+
+	case "down":
+		migration, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Fprintln(out, "no applied migrations")
+			return nil
+		}
+		fmt.Fprintf(out, "rolled back %d_%s\n", migration.Version, migration.Name)
+		return nil
+
+	case "redo":
+		migration, err := m.Redo(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Fprintln(out, "no applied migrations")
+			return nil
+		}
+		fmt.Fprintf(out, "redone    %d_%s\n", migration.Version, migration.Name)
+		return nil
+
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		PrintStatus(out, status)
+		if !status.UpToDate() {
+			return fmt.Errorf("%w: %d pending, %d unknown", ErrDrifted, len(status.Pending), len(status.Unknown))
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%w %q, expected up, down, status or redo", ErrUnknownCommand, command)
+	}
+}
+
+// PrintStatus writes applied, pending and unknown migrations as a table
+func PrintStatus(out io.Writer, status *Status) {
+	unknown := make(map[int64]bool, len(status.Unknown))
+	for _, a := range status.Unknown {
+		unknown[a.Version] = true
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, a := range status.Applied {
+		state := "applied"
+		if unknown[a.Version] {
+			state = "unknown"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", a.Version, a.Name, state, a.AppliedAt.Format(time.RFC3339))
+	}
+	for _, p := range status.Pending {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.Version, p.Name, "pending", "-")
+	}
+	w.Flush()
+}
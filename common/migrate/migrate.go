@@ -0,0 +1,423 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrSchemaNewer is returned when the database has migrations applied that
+	// this binary does not know about, i.e. it was migrated by a newer release.
+	ErrSchemaNewer = errors.New("database schema is newer than this binary")
+	// ErrPendingMigrations is returned when the binary expects migrations that are not applied yet
+	ErrPendingMigrations = errors.New("database has pending migrations")
+	// ErrNoDownMigration is returned when rolling back a migration without a down script
+	ErrNoDownMigration = errors.New("migration has no down script")
+)
+
+const trackingTable = "schema_migrations"
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// AppliedMigration is a row of the tracking table
+type AppliedMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// Status describes how the database schema relates to the migrations known to the binary
+type Status struct {
+	Applied []AppliedMigration
+	Pending []Migration
+	// Unknown are applied versions that this binary has no migration for
+	Unknown []AppliedMigration
+}
+
+// UpToDate reports whether every known migration is applied and nothing else is
+func (s *Status) UpToDate() bool {
+	return len(s.Pending) == 0 && len(s.Unknown) == 0
+}
+
+// Load reads migrations from fsys. Files must be named
+// <version>_<name>.up.sql and optionally <version>_<name>.down.sql.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies and rolls back versioned migrations. Every operation that
+// changes the schema holds a Postgres advisory lock, so replicas starting at
+// the same time apply migrations one after another instead of racing.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	lockKey    int64
+	logger     *slog.Logger
+}
+
+// New creates a migrator. lockName identifies the schema owner (usually the
+// service name) and is hashed into the advisory lock key.
+func New(db *sql.DB, migrations []Migration, lockName string, logger *slog.Logger) *Migrator {
+	h := fnv.New64a()
+	h.Write([]byte("migrate:" + lockName))
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		lockKey:    int64(h.Sum64()),
+		logger:     logger,
+	}
+}
+
+// Migrations returns the migrations known to this binary in ascending order
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies all pending migrations in order
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkNotNewer(status); err != nil {
+			return err
+		}
+
+		for _, migration := range status.Pending {
+			if err := m.apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the most recently applied migration. It returns nil when
+// nothing is applied.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkNotNewer(status); err != nil {
+			return err
+		}
+		if len(status.Applied) == 0 {
+			return nil
+		}
+
+		last := status.Applied[len(status.Applied)-1]
+		migration, ok := m.find(last.Version)
+		if !ok {
+			return ErrSchemaNewer
+		}
+		if err := m.revert(ctx, conn, migration); err != nil {
+			return err
+		}
+		rolledBack = &migration
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again
+func (m *Migrator) Redo(ctx context.Context) (*Migration, error) {
+	var redone *Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkNotNewer(status); err != nil {
+			return err
+		}
+		if len(status.Applied) == 0 {
+			return nil
+		}
+
+		migration, _ := m.find(status.Applied[len(status.Applied)-1].Version)
+		if err := m.revert(ctx, conn, migration); err != nil {
+			return err
+		}
+		if err := m.apply(ctx, conn, migration); err != nil {
+			return err
+		}
+		redone = &migration
+		return nil
+	})
+	return redone, err
+}
+
+// Status compares the tracking table with the migrations known to this binary
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return m.status(ctx, conn)
+}
+
+// Check returns ErrSchemaNewer if the database was migrated by a newer binary.
+// Pending migrations are not an error here; callers decide whether to apply them.
+func (m *Migrator) Check(ctx context.Context) (*Status, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return status, checkNotNewer(status)
+}
+
+// Verify returns an error unless the schema matches this binary exactly.
+// It is used at startup when migrations are applied by a separate job.
+func (m *Migrator) Verify(ctx context.Context) error {
+	status, err := m.Check(ctx)
+	if err != nil {
+		return err
+	}
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("%w: %d not applied, first is %d_%s", ErrPendingMigrations,
+			len(status.Pending), status.Pending[0].Version, status.Pending[0].Name)
+	}
+	return nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	// Advisory locks belong to a session, so lock and migrate on one connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	m.logger.InfoContext(ctx, "migration lock acquired", "wait", time.Since(start).String())
+
+	defer func() {
+		// Use a fresh context so the lock is released even if ctx was cancelled
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockKey); err != nil {
+			m.logger.ErrorContext(ctx, "failed to release migration lock", "error", err)
+		}
+	}()
+
+	if err := ensureTrackingTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) (*Status, error) {
+	// Reading status must not create the tracking table, otherwise a status
+	// check could race with a replica that is migrating under the lock
+	var exists bool
+	if err := conn.QueryRowContext(ctx,
+		"SELECT to_regclass($1) IS NOT NULL", trackingTable).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up %s table: %w", trackingTable, err)
+	}
+	if !exists {
+		return &Status{Pending: m.migrations}, nil
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT version, name, applied_at FROM "+trackingTable+" ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	status := &Status{}
+	appliedVersions := make(map[int64]bool)
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		status.Applied = append(status.Applied, a)
+		appliedVersions[a.Version] = true
+		if _, ok := m.find(a.Version); !ok {
+			status.Unknown = append(status.Unknown, a)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, migration := range m.migrations {
+		if !appliedVersions[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	return status, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	start := time.Now()
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO "+trackingTable+" (version, name) VALUES ($1, $2)",
+			migration.Version, migration.Name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	m.logger.InfoContext(ctx, "migration applied",
+		"version", migration.Version,
+		"name", migration.Name,
+		"duration", time.Since(start).String(),
+	)
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+	}
+
+	start := time.Now()
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM "+trackingTable+" WHERE version = $1", migration.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("rollback of %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	m.logger.InfoContext(ctx, "migration rolled back",
+		"version", migration.Version,
+		"name", migration.Name,
+		"duration", time.Since(start).String(),
+	)
+	return nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func checkNotNewer(status *Status) error {
+	if len(status.Unknown) > 0 {
+		last := status.Unknown[len(status.Unknown)-1]
+		return fmt.Errorf("%w: unknown migration %d_%s is applied", ErrSchemaNewer, last.Version, last.Name)
+	}
+	return nil
+}
+
+func ensureTrackingTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+trackingTable+` (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", trackingTable, err)
+	}
+	return nil
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
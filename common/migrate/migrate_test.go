@@ -0,0 +1,59 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"grud/common/migrate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("SortsByVersionAndPairsScripts", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0002_add_index.up.sql":      {Data: []byte("CREATE INDEX;")},
+			"0001_create_table.up.sql":   {Data: []byte("CREATE TABLE;")},
+			"0001_create_table.down.sql": {Data: []byte("DROP TABLE;")},
+			"README.md":                  {Data: []byte("ignored")},
+		}
+
+		ms, err := migrate.Load(fsys)
+		require.NoError(t, err)
+		require.Len(t, ms, 2)
+
+		assert.Equal(t, int64(1), ms[0].Version)
+		assert.Equal(t, "create_table", ms[0].Name)
+		assert.Equal(t, "CREATE TABLE;", ms[0].Up)
+		assert.Equal(t, "DROP TABLE;", ms[0].Down)
+
+		assert.Equal(t, int64(2), ms[1].Version)
+		assert.Empty(t, ms[1].Down)
+	})
+
+	t.Run("RejectsMissingUpScript", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_table.down.sql": {Data: []byte("DROP TABLE;")},
+		}
+
+		_, err := migrate.Load(fsys)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsDuplicateVersion", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_table.up.sql": {Data: []byte("CREATE TABLE;")},
+			"0001_other_change.up.sql": {Data: []byte("ALTER TABLE;")},
+		}
+
+		_, err := migrate.Load(fsys)
+		assert.Error(t, err)
+	})
+}
+
+func TestStatusUpToDate(t *testing.T) {
+	assert.True(t, (&migrate.Status{}).UpToDate())
+	assert.False(t, (&migrate.Status{Pending: []migrate.Migration{{Version: 1}}}).UpToDate())
+	assert.False(t, (&migrate.Status{Unknown: []migrate.AppliedMigration{{Version: 9}}}).UpToDate())
+}
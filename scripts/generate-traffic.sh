@@ -118,6 +118,9 @@ if [ -z "$STUDENT_ID" ]; then
   echo -e "${YELLOW}⚠ Could not extract student ID, skipping student view calls${NC}"
 fi
 
+# Cookie-authenticated POSTs have to repeat the CSRF token in a header
+CSRF_TOKEN=$(echo "$REGISTER_RESPONSE$LOGIN_RESPONSE" | grep -o '"csrfToken":"[^"]*"' | head -1 | cut -d'"' -f4)
+
 # Send messages
 echo -e "\n${BLUE}📨 Sending $MESSAGE_COUNT messages...${NC}"
 FAILED=0
@@ -130,6 +133,7 @@ START_TIME=$(date +%s)
 for i in $(seq 1 $MESSAGE_COUNT); do
   RESPONSE=$(curl -s -b "$COOKIE_FILE" -X POST "$BASE_URL/api/messages" \
     -H "Content-Type: application/json" \
+    -H "X-CSRF-Token: $CSRF_TOKEN" \
     -d "{\"message\":\"Load test message #$i - $(date +%H:%M:%S)\"}")
 
   # Check if response is successful (not an error)
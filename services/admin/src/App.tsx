@@ -3,6 +3,10 @@ import { AuthProvider, useAuth } from './context/AuthContext';
 import Login from './pages/Login';
 import Students from './pages/Students';
 import Messages from './pages/Messages';
+import ResetPassword from './pages/ResetPassword';
+import AcceptInvitation from './pages/AcceptInvitation';
+import LoginRedirect from './pages/LoginRedirect';
+import VerifyEmail from './pages/VerifyEmail';
 
 function ProtectedRoute({ children }: { children: React.ReactNode }) {
   const { isAuthenticated } = useAuth();
@@ -27,6 +31,10 @@ function App() {
               </PublicRoute>
             }
           />
+          {/* Opened from emailed links, signed in or not */}
+          <Route path="/reset-password" element={<ResetPassword />} />
+          <Route path="/verify-email" element={<VerifyEmail />} />
+          <Route path="/accept-invitation" element={<AcceptInvitation />} />
           <Route
             path="/students"
             element={
@@ -43,7 +51,7 @@ function App() {
               </ProtectedRoute>
             }
           />
-          <Route path="/" element={<Navigate to="/login" />} />
+          <Route path="/" element={<LoginRedirect />} />
         </Routes>
       </BrowserRouter>
     </AuthProvider>
@@ -1,5 +1,5 @@
 import axios from 'axios';
-import type { LoginRequest, AuthResponse, Student, Message, SendMessageRequest } from '../types';
+import type { LoginRequest, LoginMFARequest, AuthResponse, MFAChallenge, ResetPasswordRequest, AcceptInvitationRequest, StudentPage, StudentListParams, Message, SendMessageRequest } from '../types';
 
 const API_BASE_URL = import.meta.env.VITE_API_URL || '';
 
@@ -11,20 +11,76 @@ const apiClient = axios.create({
   withCredentials: true, // This sends HttpOnly cookies automatically
 });
 
+// The backend issues a CSRF token with the auth cookie and refuses
+// cookie-authenticated POST/PUT/PATCH/DELETE requests without it
+const CSRF_TOKEN_KEY = 'csrfToken';
+
+const storeCsrfToken = (data: AuthResponse | MFAChallenge) => {
+  if ('csrfToken' in data && data.csrfToken) {
+    localStorage.setItem(CSRF_TOKEN_KEY, data.csrfToken);
+  }
+};
+
+apiClient.interceptors.request.use((config) => {
+  const csrfToken = localStorage.getItem(CSRF_TOKEN_KEY);
+  if (csrfToken) {
+    config.headers.set('X-CSRF-Token', csrfToken);
+  }
+  return config;
+});
+
 export const authApi = {
-  login: async (credentials: LoginRequest): Promise<AuthResponse> => {
-    const response = await apiClient.post<AuthResponse>('/auth/login', credentials);
+  login: async (credentials: LoginRequest): Promise<AuthResponse | MFAChallenge> => {
+    const response = await apiClient.post<AuthResponse | MFAChallenge>('/auth/login', credentials);
+    storeCsrfToken(response.data);
+    return response.data;
+  },
+
+  loginMfa: async (request: LoginMFARequest): Promise<AuthResponse> => {
+    const response = await apiClient.post<AuthResponse>('/auth/login/mfa', request);
+    storeCsrfToken(response.data);
+    return response.data;
+  },
+
+  refresh: async (refreshToken: string): Promise<AuthResponse> => {
+    const response = await apiClient.post<AuthResponse>('/auth/refresh', { refreshToken });
+    storeCsrfToken(response.data);
     return response.data;
   },
 
   logout: async (refreshToken: string): Promise<void> => {
     await apiClient.post('/auth/logout', { refreshToken });
+    localStorage.removeItem(CSRF_TOKEN_KEY);
+  },
+
+  // Emails a reset link; the answer is the same whether the account exists
+  forgotPassword: async (email: string): Promise<void> => {
+    await apiClient.post('/auth/password/forgot', { email });
+  },
+
+  resetPassword: async (request: ResetPasswordRequest): Promise<void> => {
+    await apiClient.post('/auth/password/reset', request);
+  },
+
+  verifyEmail: async (token: string): Promise<void> => {
+    await apiClient.post('/auth/verify', { token });
+  },
+
+  resendVerification: async (email: string): Promise<void> => {
+    await apiClient.post('/auth/verify/resend', { email });
+  },
+
+  // Sets the first password and logs the invited student in
+  acceptInvitation: async (request: AcceptInvitationRequest): Promise<AuthResponse> => {
+    const response = await apiClient.post<AuthResponse>('/auth/invitation/accept', request);
+    storeCsrfToken(response.data);
+    return response.data;
   },
 };
 
 export const studentApi = {
-  getAllStudents: async (): Promise<Student[]> => {
-    const response = await apiClient.get<Student[]>('/api/students');
+  listStudents: async (params: StudentListParams = {}): Promise<StudentPage> => {
+    const response = await apiClient.get<StudentPage>('/api/students', { params });
     return response.data;
   },
 };
@@ -0,0 +1,102 @@
+import { useState } from 'react';
+import { Link as RouterLink, useNavigate, useSearchParams } from 'react-router-dom';
+import {
+  Container,
+  Paper,
+  TextField,
+  Button,
+  Typography,
+  Box,
+  Alert,
+  Link,
+} from '@mui/material';
+import { authApi } from '../api/client';
+import { useAuth } from '../context/AuthContext';
+
+// Opened from the link in the invitation email. The invited student chooses
+// their first password and is logged in.
+export default function AcceptInvitation() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [password, setPassword] = useState('');
+  const [confirmation, setConfirmation] = useState('');
+  const [error, setError] = useState<string>(token ? '' : 'The link has no invitation token.');
+  const [loading, setLoading] = useState(false);
+  const { login } = useAuth();
+  const navigate = useNavigate();
+
+  const onSubmit = async (event: React.FormEvent) => {
+    event.preventDefault();
+    if (password !== confirmation) {
+      setError('Passwords do not match');
+      return;
+    }
+    setLoading(true);
+    setError('');
+
+    try {
+      const response = await authApi.acceptInvitation({ token, password });
+      login(response.accessToken, response.refreshToken, response.student);
+      navigate('/messages');
+    } catch (err: any) {
+      setError(err.response?.data?.error || err.response?.data || 'Accepting the invitation failed.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Box sx={{ marginTop: 8, display: 'flex', flexDirection: 'column', alignItems: 'center' }}>
+        <Paper elevation={3} sx={{ padding: 4, display: 'flex', flexDirection: 'column', width: '100%' }}>
+          <Typography variant="h4" component="h1" gutterBottom align="center">
+            Accept Invitation
+          </Typography>
+
+          {error && (
+            <Alert severity="error" sx={{ mb: 2 }}>
+              {error}
+            </Alert>
+          )}
+
+          {token && (
+            <Box component="form" onSubmit={onSubmit} sx={{ mt: 1 }}>
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Password"
+                type="password"
+                autoComplete="new-password"
+                autoFocus
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+              />
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Repeat password"
+                type="password"
+                autoComplete="new-password"
+                value={confirmation}
+                onChange={(e) => setConfirmation(e.target.value)}
+              />
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading || !password}
+              >
+                {loading ? 'Saving...' : 'Set password and log in'}
+              </Button>
+            </Box>
+          )}
+
+          <Link component={RouterLink} to="/login" align="center">
+            Go to login
+          </Link>
+        </Paper>
+      </Box>
+    </Container>
+  );
+}
@@ -1,5 +1,5 @@
 import { useForm } from 'react-hook-form';
-import { useNavigate } from 'react-router-dom';
+import { Link as RouterLink, useLocation, useNavigate } from 'react-router-dom';
 import {
   Container,
   Paper,
@@ -8,27 +8,48 @@ import {
   Typography,
   Box,
   Alert,
+  Link,
 } from '@mui/material';
 import { useState } from 'react';
 import { authApi } from '../api/client';
 import { useAuth } from '../context/AuthContext';
-import type { LoginRequest } from '../types';
+import type { AuthResponse, LoginRequest } from '../types';
+
+// A login with the identity provider lands here with an MFA challenge or an
+// error, see LoginRedirect
+interface LoginLocationState {
+  mfaToken?: string;
+  error?: string;
+}
 
 export default function Login() {
   const { register, handleSubmit, formState: { errors } } = useForm<LoginRequest>();
-  const [error, setError] = useState<string>('');
+  const location = useLocation();
+  const redirected = (location.state ?? {}) as LoginLocationState;
+  const [error, setError] = useState<string>(redirected.error || '');
   const [loading, setLoading] = useState(false);
+  // Set after the password step when the account has 2FA enabled
+  const [mfaToken, setMfaToken] = useState<string>(redirected.mfaToken || '');
+  const [mfaCode, setMfaCode] = useState<string>('');
   const { login } = useAuth();
   const navigate = useNavigate();
 
+  const finish = (response: AuthResponse) => {
+    login(response.accessToken, response.refreshToken, response.student);
+    navigate('/messages');
+  };
+
   const onSubmit = async (data: LoginRequest) => {
     setLoading(true);
     setError('');
 
     try {
       const response = await authApi.login(data);
-      login(response.accessToken, response.refreshToken, response.student);
-      navigate('/messages');
+      if ('mfaRequired' in response) {
+        setMfaToken(response.mfaToken);
+        return;
+      }
+      finish(response);
     } catch (err: any) {
       setError(err.response?.data?.error || 'Login failed. Please try again.');
     } finally {
@@ -36,6 +57,29 @@ export default function Login() {
     }
   };
 
+  const onSubmitMfa = async (event: React.FormEvent) => {
+    event.preventDefault();
+    setLoading(true);
+    setError('');
+
+    // Six digits are an authenticator code, anything else a recovery code
+    const code = mfaCode.trim();
+    try {
+      const response = await authApi.loginMfa(
+        /^\d{6}$/.test(code) ? { mfaToken, code } : { mfaToken, recoveryCode: code },
+      );
+      finish(response);
+    } catch (err: any) {
+      if (err.response?.status === 401 && String(err.response?.data).includes('MFA token')) {
+        // The challenge expired, start over with the password
+        setMfaToken('');
+      }
+      setError(err.response?.data?.error || err.response?.data || 'Login failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
   return (
     <Container maxWidth="sm">
       <Box
@@ -65,48 +109,77 @@ export default function Login() {
             </Alert>
           )}
 
-          <Box component="form" onSubmit={handleSubmit(onSubmit)} sx={{ mt: 1 }}>
-            <TextField
-              margin="normal"
-              fullWidth
-              label="Email"
-              type="email"
-              autoComplete="email"
-              autoFocus
-              error={!!errors.email}
-              helperText={errors.email?.message}
-              {...register('email', {
-                required: 'Email is required',
-                pattern: {
-                  value: /^[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}$/i,
-                  message: 'Invalid email address',
-                },
-              })}
-            />
+          {mfaToken ? (
+            <Box component="form" onSubmit={onSubmitMfa} sx={{ mt: 1 }}>
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Authentication code"
+                helperText="Code from your authenticator app, or a recovery code"
+                autoComplete="one-time-code"
+                autoFocus
+                value={mfaCode}
+                onChange={(e) => setMfaCode(e.target.value)}
+              />
+
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading || !mfaCode.trim()}
+              >
+                {loading ? 'Verifying...' : 'Verify'}
+              </Button>
+            </Box>
+          ) : (
+            <Box component="form" onSubmit={handleSubmit(onSubmit)} sx={{ mt: 1 }}>
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Email"
+                type="email"
+                autoComplete="email"
+                autoFocus
+                error={!!errors.email}
+                helperText={errors.email?.message}
+                {...register('email', {
+                  required: 'Email is required',
+                  pattern: {
+                    value: /^[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}$/i,
+                    message: 'Invalid email address',
+                  },
+                })}
+              />
 
-            <TextField
-              margin="normal"
-              fullWidth
-              label="Password"
-              type="password"
-              autoComplete="current-password"
-              error={!!errors.password}
-              helperText={errors.password?.message}
-              {...register('password', {
-                required: 'Password is required',
-              })}
-            />
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Password"
+                type="password"
+                autoComplete="current-password"
+                error={!!errors.password}
+                helperText={errors.password?.message}
+                {...register('password', {
+                  required: 'Password is required',
+                })}
+              />
 
-            <Button
-              type="submit"
-              fullWidth
-              variant="contained"
-              sx={{ mt: 3, mb: 2 }}
-              disabled={loading}
-            >
-              {loading ? 'Logging in...' : 'Login'}
-            </Button>
-          </Box>
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading}
+              >
+                {loading ? 'Logging in...' : 'Login'}
+              </Button>
+
+              <Link component={RouterLink} to="/reset-password" align="center" display="block">
+                Forgot password?
+              </Link>
+            </Box>
+          )}
         </Paper>
       </Box>
     </Container>
@@ -0,0 +1,55 @@
+import { useEffect, useRef } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { Box, CircularProgress } from '@mui/material';
+import { authApi } from '../api/client';
+import { useAuth } from '../context/AuthContext';
+
+// The app root. A login with the identity provider comes back here with what
+// a password login returns in the URL fragment: a refresh token, or an MFA
+// challenge for accounts with 2FA.
+export default function LoginRedirect() {
+  const { login } = useAuth();
+  const navigate = useNavigate();
+  // The refresh token is single-use, so it must not be sent twice when the
+  // effect runs again
+  const handled = useRef(false);
+
+  useEffect(() => {
+    if (handled.current) return;
+    handled.current = true;
+
+    const fragment = new URLSearchParams(window.location.hash.slice(1));
+    // Keep the tokens out of the browser history
+    if (window.location.hash) {
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+
+    if (fragment.get('mfaRequired') === 'true' && fragment.get('mfaToken')) {
+      navigate('/login', { replace: true, state: { mfaToken: fragment.get('mfaToken') } });
+      return;
+    }
+
+    const refreshToken = fragment.get('refreshToken');
+    if (!refreshToken) {
+      navigate('/login', { replace: true });
+      return;
+    }
+
+    // Trading the token right away leaves the one from the fragment spent
+    // and fetches the student and a CSRF token
+    authApi.refresh(refreshToken)
+      .then((response) => {
+        login(response.accessToken, response.refreshToken, response.student);
+        navigate('/messages', { replace: true });
+      })
+      .catch(() => {
+        navigate('/login', { replace: true, state: { error: 'Login with the identity provider failed. Please try again.' } });
+      });
+  }, [login, navigate]);
+
+  return (
+    <Box sx={{ display: 'flex', justifyContent: 'center', mt: 8 }}>
+      <CircularProgress />
+    </Box>
+  );
+}
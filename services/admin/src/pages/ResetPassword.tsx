@@ -0,0 +1,141 @@
+import { useState } from 'react';
+import { Link as RouterLink, useSearchParams } from 'react-router-dom';
+import {
+  Container,
+  Paper,
+  TextField,
+  Button,
+  Typography,
+  Box,
+  Alert,
+  Link,
+} from '@mui/material';
+import { authApi } from '../api/client';
+
+// Opened from the link in the password reset email, which carries the token
+// in the query. Without a token it asks for the email to send the link to.
+export default function ResetPassword() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirmation, setConfirmation] = useState('');
+  const [error, setError] = useState<string>('');
+  const [success, setSuccess] = useState<string>('');
+  const [loading, setLoading] = useState(false);
+
+  const onRequestLink = async (event: React.FormEvent) => {
+    event.preventDefault();
+    setLoading(true);
+    setError('');
+
+    try {
+      await authApi.forgotPassword(email.trim());
+      setSuccess('If an account exists for this email, a reset link is on its way.');
+    } catch (err: any) {
+      setError(err.response?.data?.error || err.response?.data || 'Request failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const onReset = async (event: React.FormEvent) => {
+    event.preventDefault();
+    if (password !== confirmation) {
+      setError('Passwords do not match');
+      return;
+    }
+    setLoading(true);
+    setError('');
+
+    try {
+      await authApi.resetPassword({ token, newPassword: password });
+      setSuccess('Your password has been changed. You can log in with it now.');
+    } catch (err: any) {
+      setError(err.response?.data?.error || err.response?.data || 'Reset failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Box sx={{ marginTop: 8, display: 'flex', flexDirection: 'column', alignItems: 'center' }}>
+        <Paper elevation={3} sx={{ padding: 4, display: 'flex', flexDirection: 'column', width: '100%' }}>
+          <Typography variant="h4" component="h1" gutterBottom align="center">
+            Reset Password
+          </Typography>
+
+          {error && (
+            <Alert severity="error" sx={{ mb: 2 }}>
+              {error}
+            </Alert>
+          )}
+
+          {success ? (
+            <Alert severity="success" sx={{ mb: 2 }}>
+              {success}
+            </Alert>
+          ) : token ? (
+            <Box component="form" onSubmit={onReset} sx={{ mt: 1 }}>
+              <TextField
+                margin="normal"
+                fullWidth
+                label="New password"
+                type="password"
+                autoComplete="new-password"
+                autoFocus
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+              />
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Repeat new password"
+                type="password"
+                autoComplete="new-password"
+                value={confirmation}
+                onChange={(e) => setConfirmation(e.target.value)}
+              />
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading || !password}
+              >
+                {loading ? 'Saving...' : 'Set password'}
+              </Button>
+            </Box>
+          ) : (
+            <Box component="form" onSubmit={onRequestLink} sx={{ mt: 1 }}>
+              <TextField
+                margin="normal"
+                fullWidth
+                label="Email"
+                type="email"
+                autoComplete="email"
+                autoFocus
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+              />
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading || !email.trim()}
+              >
+                {loading ? 'Sending...' : 'Send reset link'}
+              </Button>
+            </Box>
+          )}
+
+          <Link component={RouterLink} to="/login" align="center">
+            Back to login
+          </Link>
+        </Paper>
+      </Box>
+    </Container>
+  );
+}
@@ -19,9 +19,14 @@ import { studentApi } from '../api/client';
 import { useAuth } from '../context/AuthContext';
 import type { Student } from '../types';
 
+const PAGE_SIZE = 50;
+
 export default function Students() {
   const [students, setStudents] = useState<Student[]>([]);
+  const [nextCursor, setNextCursor] = useState<string | undefined>();
+  const [totalCount, setTotalCount] = useState(0);
   const [loading, setLoading] = useState(true);
+  const [loadingMore, setLoadingMore] = useState(false);
   const [error, setError] = useState<string>('');
   const { logout, student } = useAuth();
   const navigate = useNavigate();
@@ -35,8 +40,10 @@ export default function Students() {
     setError('');
 
     try {
-      const data = await studentApi.getAllStudents();
-      setStudents(data);
+      const page = await studentApi.listStudents({ limit: PAGE_SIZE });
+      setStudents(page.items);
+      setNextCursor(page.nextCursor);
+      setTotalCount(page.totalCount);
     } catch (err: any) {
       setError(err.response?.data?.error || 'Failed to fetch students');
     } finally {
@@ -44,6 +51,23 @@ export default function Students() {
     }
   };
 
+  const fetchMore = async () => {
+    if (!nextCursor) return;
+    setLoadingMore(true);
+    setError('');
+
+    try {
+      const page = await studentApi.listStudents({ limit: PAGE_SIZE, cursor: nextCursor });
+      setStudents((prev) => [...prev, ...page.items]);
+      setNextCursor(page.nextCursor);
+      setTotalCount(page.totalCount);
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Failed to fetch students');
+    } finally {
+      setLoadingMore(false);
+    }
+  };
+
   const handleLogout = () => {
     logout();
     navigate('/login');
@@ -119,6 +143,19 @@ export default function Students() {
             </Table>
           </TableContainer>
         )}
+
+        {!loading && (
+          <Box sx={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', mt: 2 }}>
+            <Typography variant="body2" color="text.secondary">
+              Showing {students.length} of {totalCount}
+            </Typography>
+            {nextCursor && (
+              <Button variant="outlined" onClick={fetchMore} disabled={loadingMore}>
+                {loadingMore ? 'Loading...' : 'Load more'}
+              </Button>
+            )}
+          </Box>
+        )}
       </Box>
     </Container>
   );
@@ -0,0 +1,112 @@
+import { useEffect, useRef, useState } from 'react';
+import { Link as RouterLink, useSearchParams } from 'react-router-dom';
+import {
+  Container,
+  Paper,
+  TextField,
+  Button,
+  Typography,
+  Box,
+  Alert,
+  CircularProgress,
+  Link,
+} from '@mui/material';
+import { authApi } from '../api/client';
+
+// Opened from the link in the verification email. The token is confirmed
+// right away; a used or expired link can be replaced with a new email.
+export default function VerifyEmail() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [verifying, setVerifying] = useState(!!token);
+  const [verified, setVerified] = useState(false);
+  const [error, setError] = useState<string>(token ? '' : 'The link has no verification token.');
+  const [email, setEmail] = useState('');
+  const [resent, setResent] = useState(false);
+  const [loading, setLoading] = useState(false);
+  // The token is single-use, so it must not be sent twice when the effect
+  // runs again
+  const submitted = useRef(false);
+
+  useEffect(() => {
+    if (!token || submitted.current) return;
+    submitted.current = true;
+
+    authApi.verifyEmail(token)
+      .then(() => setVerified(true))
+      .catch((err: any) => {
+        setError(err.response?.data?.error || err.response?.data || 'Verification failed.');
+      })
+      .finally(() => setVerifying(false));
+  }, [token]);
+
+  const onResend = async (event: React.FormEvent) => {
+    event.preventDefault();
+    setLoading(true);
+
+    try {
+      await authApi.resendVerification(email.trim());
+      setResent(true);
+    } catch (err: any) {
+      setError(err.response?.data?.error || err.response?.data || 'Request failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Box sx={{ marginTop: 8, display: 'flex', flexDirection: 'column', alignItems: 'center' }}>
+        <Paper elevation={3} sx={{ padding: 4, display: 'flex', flexDirection: 'column', width: '100%' }}>
+          <Typography variant="h4" component="h1" gutterBottom align="center">
+            Email Verification
+          </Typography>
+
+          {verifying ? (
+            <Box sx={{ display: 'flex', justifyContent: 'center', my: 2 }}>
+              <CircularProgress />
+            </Box>
+          ) : verified ? (
+            <Alert severity="success" sx={{ mb: 2 }}>
+              Your email address is verified.
+            </Alert>
+          ) : resent ? (
+            <Alert severity="success" sx={{ mb: 2 }}>
+              If the address belongs to an unverified account, a new link is on its way.
+            </Alert>
+          ) : (
+            <>
+              <Alert severity="error" sx={{ mb: 2 }}>
+                {error}
+              </Alert>
+              <Box component="form" onSubmit={onResend}>
+                <TextField
+                  margin="normal"
+                  fullWidth
+                  label="Email"
+                  type="email"
+                  autoComplete="email"
+                  value={email}
+                  onChange={(e) => setEmail(e.target.value)}
+                />
+                <Button
+                  type="submit"
+                  fullWidth
+                  variant="contained"
+                  sx={{ mt: 3, mb: 2 }}
+                  disabled={loading || !email.trim()}
+                >
+                  {loading ? 'Sending...' : 'Send a new link'}
+                </Button>
+              </Box>
+            </>
+          )}
+
+          <Link component={RouterLink} to="/login" align="center">
+            Go to login
+          </Link>
+        </Paper>
+      </Box>
+    </Container>
+  );
+}
@@ -1,3 +1,5 @@
+export type Role = 'student' | 'staff' | 'admin';
+
 export interface Student {
   id: number;
   firstName: string;
@@ -5,6 +7,28 @@ export interface Student {
   email: string;
   major: string;
   year: number;
+  role: Role;
+  verifiedAt?: string;
+  version: number;
+  deletedAt?: string;
+}
+
+export interface StudentPage {
+  items: Student[];
+  nextCursor?: string;
+  totalCount: number;
+}
+
+export interface StudentListParams {
+  limit?: number;
+  cursor?: string;
+  major?: string;
+  year_min?: number;
+  year_max?: number;
+  email_prefix?: string;
+  name?: string;
+  sort?: string;
+  include_deleted?: boolean;
 }
 
 export interface LoginRequest {
@@ -16,6 +40,32 @@ export interface AuthResponse {
   accessToken: string;
   refreshToken: string;
   student: Student;
+  mfaEnrollmentRequired?: boolean;
+  // Sent back in X-CSRF-Token with cookie-authenticated writes
+  csrfToken?: string;
+}
+
+// Returned by /auth/login instead of tokens when the account has 2FA enabled
+export interface MFAChallenge {
+  mfaRequired: true;
+  mfaToken: string;
+  expiresAt: string;
+}
+
+export interface LoginMFARequest {
+  mfaToken: string;
+  code?: string;
+  recoveryCode?: string;
+}
+
+export interface ResetPasswordRequest {
+  token: string;
+  newPassword: string;
+}
+
+export interface AcceptInvitationRequest {
+  token: string;
+  password: string;
 }
 
 export interface Message {
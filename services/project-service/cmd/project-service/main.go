@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	systemLog "log"
 	"os"
 	"os/signal"
@@ -13,7 +14,46 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const usage = `Usage: project-service [command]
+
+Commands:
+  serve                           Start the gRPC server and NATS consumer (default)
+  migrate up|down|status|redo     Manage database migrations
+  seed                            Apply migrations and insert sample data (local/kind only)
+`
+
 func main() {
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "migrate":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := app.Migrate(context.Background(), os.Args[2], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			os.Exit(1)
+		}
+	case "seed":
+		if err := app.Seed(context.Background()); err != nil {
+			fmt.Fprintln(os.Stderr, "seed:", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 	// Initialize application with gRPC on port 50052
 	application := app.New()
 
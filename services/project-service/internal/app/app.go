@@ -83,8 +83,12 @@ func New() *App {
 
 	database := db.New(cfg.Database)
 	app.database = database
-	if err := db.RunMigrations(ctx, database, (*project.Project)(nil), (*message.Message)(nil)); err != nil {
-		systemLog.Fatal("failed to run migrations:", err)
+	if cfg.Database.AutoMigrate {
+		if err := db.RunMigrations(ctx, database); err != nil {
+			systemLog.Fatal("failed to run migrations:", err)
+		}
+	} else if err := db.VerifyMigrations(ctx, database); err != nil {
+		systemLog.Fatal("database schema check failed:", err)
 	}
 
 	// Register database for metrics collection
@@ -107,7 +111,7 @@ func New() *App {
 
 	messageRepo := message.NewRepository(database, app.metrics)
 	messageService := message.NewService(messageRepo)
-	natsConsumer, err := messaging.NewConsumer(cfg.NATS.URL, cfg.NATS.Subject, messageRepo, log, app.serviceMetrics)
+	natsConsumer, err := messaging.NewConsumer(cfg.NATS.URL, cfg.NATS.Subject, cfg.NATS.PurgeSubject, messageRepo, log, app.serviceMetrics)
 	if err != nil {
 		systemLog.Fatal("failed to create NATS consumer:", err)
 	}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"project-service/internal/config"
+	"project-service/internal/db"
+
+	"grud/common/logger"
+	"grud/common/migrate"
+)
+
+// ErrSeedNotAllowed is returned when seeding is attempted outside local environments
+var ErrSeedNotAllowed = errors.New("seeding is only allowed in local and kind environments")
+
+// Migrate runs a "migrate" subcommand (up, down, status, redo) against the configured database
+func Migrate(ctx context.Context, command string, out io.Writer) error {
+	cfg, log, err := bootstrap()
+	if err != nil {
+		return err
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	migrator, err := db.NewMigrator(database, log)
+	if err != nil {
+		return err
+	}
+	return migrate.Run(ctx, migrator, command, out)
+}
+
+// Seed applies pending migrations and inserts sample data for local development
+func Seed(ctx context.Context) error {
+	cfg, _, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	if cfg.Env != "local" && cfg.Env != "kind" {
+		return fmt.Errorf("%w (env %q)", ErrSeedNotAllowed, cfg.Env)
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	if err := db.RunMigrations(ctx, database); err != nil {
+		return err
+	}
+	return db.Seed(ctx, database)
+}
+
+// bootstrap sets up logging and loads config for one-off commands
+func bootstrap() (*config.Config, *slog.Logger, error) {
+	log := logger.NewWithServiceContext(ServiceName, Version)
+	slog.SetDefault(log)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return cfg, log, nil
+}
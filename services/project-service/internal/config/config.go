@@ -25,6 +25,9 @@ type DatabaseConfig struct {
 	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
 	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime_seconds"`
 	ConnMaxIdleTime int    `mapstructure:"conn_max_idle_time_seconds"`
+	// AutoMigrate applies pending migrations on startup. Disable it when
+	// migrations run as a separate "migrate up" job before deployment.
+	AutoMigrate bool `mapstructure:"auto_migrate"`
 }
 
 type GrpcConfig struct {
@@ -34,6 +37,9 @@ type GrpcConfig struct {
 type NATSConfig struct {
 	URL     string `mapstructure:"url"`
 	Subject string `mapstructure:"subject"`
+	// PurgeSubject carries the students purged by student-service, whose
+	// messages are deleted; empty uses the built-in default
+	PurgeSubject string `mapstructure:"purge_subject"`
 }
 
 func Load() (*Config, error) {
@@ -56,6 +62,8 @@ func Load() (*Config, error) {
 		fmt.Printf("No config file found (will use ENV variables): %v\n", err)
 	}
 
+	viper.SetDefault("database.auto_migrate", true)
+
 	// Enable environment variable overrides (these take precedence over config file)
 	viper.AutomaticEnv()
 
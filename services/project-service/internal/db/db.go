@@ -9,12 +9,18 @@ import (
 	"time"
 
 	"project-service/internal/config"
+	"project-service/internal/db/migrations"
+
+	"grud/common/migrate"
 
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+// migrationLockName scopes the migration advisory lock to this service's schema
+const migrationLockName = "project-service"
+
 func New(cfg config.DatabaseConfig) *bun.DB {
 	sslMode := cfg.SSLMode
 	if sslMode == "" {
@@ -83,43 +89,37 @@ func configurePool(db *bun.DB, cfg config.DatabaseConfig) {
 	)
 }
 
-func RunMigrations(ctx context.Context, db *bun.DB, models ...interface{}) error {
-	for _, model := range models {
-		_, err := db.NewCreateTable().
-			Model(model).
-			IfNotExists().
-			Exec(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to create table for model: %w", err)
-		}
+// NewMigrator returns a migrator for the SQL migrations embedded in this service
+func NewMigrator(db *bun.DB, logger *slog.Logger) (*migrate.Migrator, error) {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
 	}
+	return migrate.New(db.DB, ms, migrationLockName, logger), nil
+}
 
-	// Create trigger function for updated_at if it doesn't exist
-	_, err := db.ExecContext(ctx, `
-		CREATE OR REPLACE FUNCTION update_updated_at_column()
-		RETURNS TRIGGER AS $$
-		BEGIN
-			NEW.updated_at = CURRENT_TIMESTAMP;
-			RETURN NEW;
-		END;
-		$$ language 'plpgsql';
-	`)
+// RunMigrations applies all pending migrations. It refuses to run against a
+// schema that was migrated by a newer release of the service.
+func RunMigrations(ctx context.Context, db *bun.DB) error {
+	migrator, err := NewMigrator(db, slog.Default())
 	if err != nil {
-		return fmt.Errorf("failed to create trigger function: %w", err)
+		return err
 	}
 
-	// Create trigger for projects table
-	_, err = db.ExecContext(ctx, `
-		DROP TRIGGER IF EXISTS update_projects_updated_at ON projects;
-		CREATE TRIGGER update_projects_updated_at
-			BEFORE UPDATE ON projects
-			FOR EACH ROW
-			EXECUTE FUNCTION update_updated_at_column();
-	`)
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create trigger: %w", err)
+		return err
 	}
 
-	slog.Info("database migrations completed successfully")
+	slog.Info("database migrations completed successfully", "applied", len(applied))
 	return nil
 }
+
+// VerifyMigrations checks that the schema matches this binary without changing it
+func VerifyMigrations(ctx context.Context, db *bun.DB) error {
+	migrator, err := NewMigrator(db, slog.Default())
+	if err != nil {
+		return err
+	}
+	return migrator.Verify(ctx)
+}
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_projects_updated_at ON projects;
+DROP FUNCTION IF EXISTS update_updated_at_column();
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"project-service/internal/project"
+
+	"github.com/uptrace/bun"
+)
+
+var seedProjects = []string{
+	"Distributed Systems Lab",
+	"Machine Learning Seminar",
+	"Compiler Construction",
+	"Cloud Native Platform",
+}
+
+// Seed inserts sample projects that do not exist yet, so it is safe to run repeatedly.
+func Seed(ctx context.Context, db *bun.DB) error {
+	var existing []string
+	if err := db.NewSelect().
+		Model((*project.Project)(nil)).
+		Column("name").
+		Where("name IN (?)", bun.In(seedProjects)).
+		Scan(ctx, &existing); err != nil {
+		return fmt.Errorf("failed to read existing projects: %w", err)
+	}
+
+	found := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		found[name] = true
+	}
+
+	var projects []project.Project
+	for _, name := range seedProjects {
+		if !found[name] {
+			projects = append(projects, project.Project{Name: name})
+		}
+	}
+
+	if len(projects) > 0 {
+		if _, err := db.NewInsert().Model(&projects).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to seed projects: %w", err)
+		}
+	}
+
+	slog.Info("database seeded", "projects", len(projects))
+	return nil
+}
@@ -19,3 +19,9 @@ type MessageEvent struct {
 	Email   string `json:"email"`
 	Message string `json:"message"`
 }
+
+// StudentPurgedEvent is published by student-service when it purges a
+// student. The messages sent to the student's email are deleted.
+type StudentPurgedEvent struct {
+	Email string `json:"email"`
+}
@@ -12,6 +12,8 @@ import (
 type Repository interface {
 	Create(ctx context.Context, message *Message) error
 	GetByEmail(ctx context.Context, email string) ([]*Message, error)
+	// DeleteByEmail removes the messages sent to email and returns their number
+	DeleteByEmail(ctx context.Context, email string) (int, error)
 }
 
 type repository struct {
@@ -57,3 +59,19 @@ func (r *repository) GetByEmail(ctx context.Context, email string) ([]*Message,
 
 	return messages, err
 }
+
+func (r *repository) DeleteByEmail(ctx context.Context, email string) (int, error) {
+	start := time.Now()
+	result, err := r.db.NewDelete().
+		Model((*Message)(nil)).
+		Where("email = ?", email).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "messages", time.Since(start), err)
+
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -13,27 +13,40 @@ import (
 	"go.opentelemetry.io/otel/propagation"
 )
 
+// DefaultPurgeSubject is the subject student-service announces purged
+// students on
+const DefaultPurgeSubject = "student.messages.purge"
+
 type Consumer struct {
-	conn       *nats.Conn
-	sub        *nats.Subscription
-	subject    string
-	repository message.Repository
-	logger     *slog.Logger
-	metrics    *metrics.Metrics
+	conn         *nats.Conn
+	sub          *nats.Subscription
+	purgeSub     *nats.Subscription
+	subject      string
+	purgeSubject string
+	repository   message.Repository
+	logger       *slog.Logger
+	metrics      *metrics.Metrics
 }
 
-func NewConsumer(url string, subject string, repository message.Repository, logger *slog.Logger, metrics *metrics.Metrics) (*Consumer, error) {
+// NewConsumer creates a consumer storing the messages published on subject
+// and deleting the messages of the students announced on purgeSubject,
+// DefaultPurgeSubject if empty
+func NewConsumer(url string, subject string, purgeSubject string, repository message.Repository, logger *slog.Logger, metrics *metrics.Metrics) (*Consumer, error) {
 	nc, err := nats.Connect(url)
 	if err != nil {
 		return nil, err
 	}
+	if purgeSubject == "" {
+		purgeSubject = DefaultPurgeSubject
+	}
 
 	return &Consumer{
-		conn:       nc,
-		subject:    subject,
-		repository: repository,
-		logger:     logger,
-		metrics:    metrics,
+		conn:         nc,
+		subject:      subject,
+		purgeSubject: purgeSubject,
+		repository:   repository,
+		logger:       logger,
+		metrics:      metrics,
 	}, nil
 }
 
@@ -75,16 +88,43 @@ func (c *Consumer) Start(ctx context.Context) error {
 	}
 
 	c.sub = sub
-	c.logger.Info("NATS consumer started", "subject", c.subject)
+
+	purgeSub, err := c.conn.Subscribe(c.purgeSubject, c.handlePurge)
+	if err != nil {
+		return err
+	}
+	c.purgeSub = purgeSub
+	c.logger.Info("NATS consumer started", "subject", c.subject, "purge_subject", c.purgeSubject)
 
 	<-ctx.Done()
 	return ctx.Err()
 }
 
+// handlePurge deletes the messages of a student purged by student-service
+func (c *Consumer) handlePurge(msg *nats.Msg) {
+	msgCtx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(msg.Header))
+
+	var event message.StudentPurgedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil || event.Email == "" {
+		c.logger.ErrorContext(msgCtx, "invalid student purge event", "error", err)
+		return
+	}
+
+	deleted, err := c.repository.DeleteByEmail(msgCtx, event.Email)
+	if err != nil {
+		c.logger.ErrorContext(msgCtx, "failed to delete messages of purged student", "error", err)
+		return
+	}
+	c.logger.InfoContext(msgCtx, "deleted messages of purged student", "email", event.Email, "count", deleted)
+}
+
 func (c *Consumer) Close() error {
 	if c.sub != nil {
 		c.sub.Unsubscribe()
 	}
+	if c.purgeSub != nil {
+		c.purgeSub.Unsubscribe()
+	}
 	c.conn.Close()
 	return nil
 }
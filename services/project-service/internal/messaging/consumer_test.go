@@ -32,11 +32,12 @@ func TestNATSConsumerIntegration(t *testing.T) {
 	natsURL := natsContainer.URL
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 	subject := "test.messages"
+	purgeSubject := "test.messages.purge"
 	mockServiceMetrics := projectmetrics.NewMock()
 	mockRepoMetrics := commonmetrics.NewMock()
 	repo := message.NewRepository(pgContainer.DB, mockRepoMetrics)
 
-	consumer, _ := messaging.NewConsumer(natsURL, subject, repo, logger, mockServiceMetrics)
+	consumer, _ := messaging.NewConsumer(natsURL, subject, purgeSubject, repo, logger, mockServiceMetrics)
 	startConsumer(consumer)
 	defer func() { _ = consumer.Close() }()
 	time.Sleep(100 * time.Millisecond)
@@ -97,6 +98,32 @@ func TestNATSConsumerIntegration(t *testing.T) {
 		assert.Len(t, messages, 5)
 	})
 
+	t.Run("Consumer_DeletesMessagesOfPurgedStudent", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "messages")
+
+		ctx := context.Background()
+		for _, email := range []string{"purged@example.com", "purged@example.com", "kept@example.com"} {
+			require.NoError(t, repo.Create(ctx, &message.Message{Email: email, Message: "hello"}))
+		}
+
+		nc, err := nats.Connect(natsURL)
+		require.NoError(t, err)
+		defer nc.Close()
+
+		data, err := json.Marshal(message.StudentPurgedEvent{Email: "purged@example.com"})
+		require.NoError(t, err)
+		require.NoError(t, nc.Publish(purgeSubject, data))
+
+		time.Sleep(200 * time.Millisecond)
+
+		messages, err := repo.GetByEmail(ctx, "purged@example.com")
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+		messages, err = repo.GetByEmail(ctx, "kept@example.com")
+		require.NoError(t, err)
+		assert.Len(t, messages, 1)
+	})
+
 	t.Run("Consumer_InvalidJSON", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "messages")
 
@@ -0,0 +1,24 @@
+package project
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// formatETag turns a project version into the opaque etag handed to gRPC clients
+func formatETag(version int) string {
+	return strconv.Itoa(version)
+}
+
+// parseETag returns the version encoded in an etag; an empty etag yields 0,
+// meaning the caller does not require any particular version
+func parseETag(etag string) (int, error) {
+	if etag == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(etag)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: malformed etag %q", ErrInvalidInput, etag)
+	}
+	return version, nil
+}
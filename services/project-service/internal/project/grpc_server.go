@@ -2,6 +2,7 @@ package project
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 
 	pb "grud/api/gen/project/v1"
@@ -44,6 +45,7 @@ func (s *GrpcServer) GetAllProjects(ctx context.Context, req *pb.GetAllProjectsR
 			Name:      proj.Name,
 			CreatedAt: timestamppb.New(proj.CreatedAt),
 			UpdatedAt: timestamppb.New(proj.UpdatedAt),
+			Etag:      formatETag(proj.Version),
 		}
 	}
 
@@ -77,6 +79,7 @@ func (s *GrpcServer) GetProject(ctx context.Context, req *pb.GetProjectRequest)
 			Name:      project.Name,
 			CreatedAt: timestamppb.New(project.CreatedAt),
 			UpdatedAt: timestamppb.New(project.UpdatedAt),
+			Etag:      formatETag(project.Version),
 		},
 	}, nil
 }
@@ -102,6 +105,7 @@ func (s *GrpcServer) CreateProject(ctx context.Context, req *pb.CreateProjectReq
 			Name:      project.Name,
 			CreatedAt: timestamppb.New(project.CreatedAt),
 			UpdatedAt: timestamppb.New(project.UpdatedAt),
+			Etag:      formatETag(project.Version),
 		},
 	}, nil
 }
@@ -111,16 +115,22 @@ func (s *GrpcServer) UpdateProject(ctx context.Context, req *pb.UpdateProjectReq
 		return nil, status.Error(codes.InvalidArgument, "id must be greater than 0")
 	}
 
+	version, err := parseETag(req.Etag)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	s.logger.InfoContext(ctx, "gRPC: updating project", "id", req.Id, "name", req.Name)
 
 	project := &Project{
-		ID:   int(req.Id),
-		Name: req.Name,
+		ID:      int(req.Id),
+		Name:    req.Name,
+		Version: version,
 	}
 
 	if err := s.service.UpdateProject(ctx, project); err != nil {
 		s.logger.ErrorContext(ctx, "gRPC: failed to update project", "error", err, "id", req.Id)
-		return nil, err
+		return nil, versionError(err)
 	}
 
 	// Fetch updated project to get all fields including timestamps
@@ -136,6 +146,7 @@ func (s *GrpcServer) UpdateProject(ctx context.Context, req *pb.UpdateProjectReq
 			Name:      updatedProject.Name,
 			CreatedAt: timestamppb.New(updatedProject.CreatedAt),
 			UpdatedAt: timestamppb.New(updatedProject.UpdatedAt),
+			Etag:      formatETag(updatedProject.Version),
 		},
 	}, nil
 }
@@ -145,12 +156,26 @@ func (s *GrpcServer) DeleteProject(ctx context.Context, req *pb.DeleteProjectReq
 		return nil, status.Error(codes.InvalidArgument, "id must be greater than 0")
 	}
 
+	version, err := parseETag(req.Etag)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	s.logger.InfoContext(ctx, "gRPC: deleting project", "id", req.Id)
 
-	if err := s.service.DeleteProject(ctx, int(req.Id)); err != nil {
+	if err := s.service.DeleteProject(ctx, int(req.Id), version); err != nil {
 		s.logger.ErrorContext(ctx, "gRPC: failed to delete project", "error", err, "id", req.Id)
-		return nil, err
+		return nil, versionError(err)
 	}
 
 	return &pb.DeleteProjectResponse{}, nil
 }
+
+// versionError reports a stale etag as FAILED_PRECONDITION so clients know to
+// re-read the project before retrying
+func versionError(err error) error {
+	if errors.Is(err, ErrVersionMismatch) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
@@ -14,6 +14,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestProjectGrpcServer_Shared(t *testing.T) {
@@ -157,4 +159,38 @@ func TestProjectGrpcServer_Shared(t *testing.T) {
 		assert.Equal(t, 0, count)
 	})
 
+	t.Run("UpdateProject_StaleEtag", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "projects")
+
+		ctx := context.Background()
+		p := &project.Project{Name: "Contested"}
+		_, err := pgContainer.DB.NewInsert().Model(p).Exec(ctx)
+		require.NoError(t, err)
+
+		got, err := grpcServer.GetProject(ctx, &pb.GetProjectRequest{Id: int32(p.ID)})
+		require.NoError(t, err)
+		etag := got.Project.Etag
+		require.NotEmpty(t, etag)
+
+		resp, err := grpcServer.UpdateProject(ctx, &pb.UpdateProjectRequest{Id: int32(p.ID), Name: "First", Etag: etag})
+		require.NoError(t, err)
+		assert.NotEqual(t, etag, resp.Project.Etag)
+
+		_, err = grpcServer.UpdateProject(ctx, &pb.UpdateProjectRequest{Id: int32(p.ID), Name: "Second", Etag: etag})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		_, err = grpcServer.DeleteProject(ctx, &pb.DeleteProjectRequest{Id: int32(p.ID), Etag: etag})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		_, err = grpcServer.UpdateProject(ctx, &pb.UpdateProjectRequest{Id: int32(p.ID), Name: "Third", Etag: "not-an-etag"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		stored, err := grpcServer.GetProject(ctx, &pb.GetProjectRequest{Id: int32(p.ID)})
+		require.NoError(t, err)
+		assert.Equal(t, "First", stored.Project.Name)
+
+		_, err = grpcServer.DeleteProject(ctx, &pb.DeleteProjectRequest{Id: int32(p.ID), Etag: resp.Project.Etag})
+		require.NoError(t, err)
+	})
+
 }
@@ -13,4 +13,6 @@ type Project struct {
 	Name      string    `bun:"name,notnull" json:"name" validate:"required"`
 	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp" json:"createdAt"`
 	UpdatedAt time.Time `bun:"updated_at,notnull,default:current_timestamp" json:"updatedAt"`
+	// Version is incremented on every update and exposed to clients as the etag
+	Version int `bun:"version,notnull,default:1" json:"version"`
 }
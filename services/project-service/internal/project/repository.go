@@ -15,7 +15,7 @@ type Repository interface {
 	GetAll(ctx context.Context) ([]Project, error)
 	GetByID(ctx context.Context, id int) (*Project, error)
 	Update(ctx context.Context, project *Project) error
-	Delete(ctx context.Context, id int) error
+	Delete(ctx context.Context, id, version int) error
 }
 
 type repository struct {
@@ -73,13 +73,22 @@ func (r *repository) GetByID(ctx context.Context, id int) (*Project, error) {
 	return project, nil
 }
 
+// Update writes the project name. When project.Version is set the row is
+// only updated if it still has that version, otherwise ErrVersionMismatch is
+// returned. On success project.Version holds the new version.
 func (r *repository) Update(ctx context.Context, project *Project) error {
-	start := time.Now()
-	result, err := r.db.NewUpdate().
+	q := r.db.NewUpdate().
 		Model(project).
 		Column("name").
+		Set("version = p.version + 1").
 		WherePK().
-		Exec(ctx)
+		Returning("version")
+	if project.Version > 0 {
+		q = q.Where("p.version = ?", project.Version)
+	}
+
+	start := time.Now()
+	result, err := q.Exec(ctx)
 	r.metrics.Database.RecordQuery(ctx, "update", "projects", time.Since(start), err)
 
 	if err != nil {
@@ -90,19 +99,58 @@ func (r *repository) Update(ctx context.Context, project *Project) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrProjectNotFound
+		return r.missingRowError(ctx, project.ID, project.Version)
 	}
 	return nil
 }
 
-func (r *repository) Delete(ctx context.Context, id int) error {
+// Delete removes a project. A non-zero version makes the delete conditional;
+// deleting a project that no longer exists is not an error.
+func (r *repository) Delete(ctx context.Context, id, version int) error {
+	q := r.db.NewDelete().Model(&Project{ID: id}).WherePK()
+	if version > 0 {
+		q = q.Where("p.version = ?", version)
+	}
+
 	start := time.Now()
-	_, err := r.db.NewDelete().Model(&Project{ID: id}).WherePK().Exec(ctx)
+	result, err := q.Exec(ctx)
 	r.metrics.Database.RecordQuery(ctx, "delete", "projects", time.Since(start), err)
 
 	if err != nil {
 		return err
 	}
+	if version == 0 {
+		return nil
+	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		if err := r.missingRowError(ctx, id, version); err != ErrProjectNotFound {
+			return err
+		}
+	}
 	return nil
 }
+
+// missingRowError tells apart a project that does not exist from one whose
+// version moved on after a conditional write matched no rows
+func (r *repository) missingRowError(ctx context.Context, id, version int) error {
+	if version == 0 {
+		return ErrProjectNotFound
+	}
+
+	start := time.Now()
+	exists, err := r.db.NewSelect().Model((*Project)(nil)).Where("id = ?", id).Exists(ctx)
+	r.metrics.Database.RecordQuery(ctx, "select", "projects", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrProjectNotFound
+	}
+	return ErrVersionMismatch
+}
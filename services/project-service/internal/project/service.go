@@ -8,6 +8,7 @@ import (
 var (
 	ErrProjectNotFound = errors.New("project not found")
 	ErrInvalidInput    = errors.New("invalid input")
+	ErrVersionMismatch = errors.New("project was modified concurrently")
 )
 
 type Service interface {
@@ -15,7 +16,7 @@ type Service interface {
 	GetAllProjects(ctx context.Context) ([]Project, error)
 	GetProjectByID(ctx context.Context, id int) (*Project, error)
 	UpdateProject(ctx context.Context, project *Project) error
-	DeleteProject(ctx context.Context, id int) error
+	DeleteProject(ctx context.Context, id, version int) error
 }
 
 type service struct {
@@ -44,6 +45,6 @@ func (s *service) UpdateProject(ctx context.Context, project *Project) error {
 	return s.repo.Update(ctx, project)
 }
 
-func (s *service) DeleteProject(ctx context.Context, id int) error {
-	return s.repo.Delete(ctx, id)
+func (s *service) DeleteProject(ctx context.Context, id, version int) error {
+	return s.repo.Delete(ctx, id, version)
 }
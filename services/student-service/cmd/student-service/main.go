@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -11,7 +12,78 @@ import (
 	"student-service/internal/app"
 )
 
+const usage = `Usage: student-service [command]
+
+Commands:
+  serve                           Start the HTTP server (default)
+  migrate up|down|status|redo     Manage database migrations
+  seed                            Apply migrations and insert sample data (local/kind only)
+  set-role <email> <role>         Assign a role (student, staff, admin) to a student
+  reset-mfa <email>               Remove the two-factor authentication of a student
+  generate-jwt-key <dir> [kid] [EdDSA|RS256]
+                                  Write a new access token signing key to <dir>/<kid>.pem
+`
+
 func main() {
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "migrate":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := app.Migrate(context.Background(), os.Args[2], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			os.Exit(1)
+		}
+	case "seed":
+		if err := app.Seed(context.Background()); err != nil {
+			fmt.Fprintln(os.Stderr, "seed:", err)
+			os.Exit(1)
+		}
+	case "set-role":
+		if len(os.Args) != 4 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := app.SetRole(context.Background(), os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintln(os.Stderr, "set-role:", err)
+			os.Exit(1)
+		}
+	case "reset-mfa":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := app.ResetMFA(context.Background(), os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, "reset-mfa:", err)
+			os.Exit(1)
+		}
+	case "generate-jwt-key":
+		if len(os.Args) < 3 || len(os.Args) > 5 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		args := append(os.Args[2:], "", "")
+		if err := app.GenerateJWTKey(args[0], args[1], args[2], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "generate-jwt-key:", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 	application := app.New()
 
 	// Start periodic logging in background
@@ -24,6 +96,16 @@ func main() {
 	defer healthCancel()
 	go application.StartHealthChecks(healthCtx)
 
+	// Start purging of soft-deleted students and stale login counters in background
+	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
+	defer cleanupCancel()
+	go application.StartCleanup(cleanupCtx)
+
+	// Start following access token revocations in background
+	revocationCtx, revocationCancel := context.WithCancel(context.Background())
+	defer revocationCancel()
+	go application.StartRevocationSync(revocationCtx)
+
 	go func() {
 		if err := application.Run(); err != nil {
 			log.Fatal("Failed to start server:", err)
@@ -12,11 +12,14 @@ import (
 	"student-service/internal/config"
 	"student-service/internal/db"
 	"student-service/internal/health"
+	"student-service/internal/mail"
 	"student-service/internal/message"
 	"student-service/internal/messaging"
 	localmetrics "student-service/internal/metrics"
 	"student-service/internal/middleware"
+	"student-service/internal/password"
 	"student-service/internal/projectclient"
+	"student-service/internal/rbac"
 	"student-service/internal/student"
 
 	"grud/common/logger"
@@ -40,6 +43,8 @@ type App struct {
 	database       *bun.DB
 	natsProducer   *messaging.Producer
 	grpcClient     *projectclient.GrpcClient
+	studentService student.Service
+	authService    *auth.Service
 }
 
 func New() *App {
@@ -66,7 +71,20 @@ func New() *App {
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Recovery())
+	// The client IP throttles logins and is stored with sessions, so only
+	// the configured proxies may set it through X-Forwarded-For
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		systemLog.Fatal("invalid trusted proxies:", err)
+	}
+	// http.ErrAbortHandler breaks a response that is already streaming and
+	// has to reach net/http for that
+	router.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.Use(middleware.RequestID())
 
 	app := &App{
 		config:    cfg,
@@ -88,8 +106,12 @@ func New() *App {
 
 	database := db.New(cfg.Database)
 	app.database = database
-	if err := db.RunMigrations(ctx, database, (*student.Student)(nil), (*auth.RefreshToken)(nil)); err != nil {
-		systemLog.Fatal("failed to run migrations:", err)
+	if cfg.Database.AutoMigrate {
+		if err := db.RunMigrations(ctx, database); err != nil {
+			systemLog.Fatal("failed to run migrations:", err)
+		}
+	} else if err := db.VerifyMigrations(ctx, database); err != nil {
+		systemLog.Fatal("database schema check failed:", err)
 	}
 
 	// Register database for metrics collection
@@ -126,16 +148,129 @@ func New() *App {
 	healthHandler := health.NewHandler()
 	healthHandler.RegisterRoutes(app.router)
 
+	// NATS producer setup
+	natsProducer, err := messaging.NewProducer(cfg.NATS.URL, cfg.NATS.Subject, log)
+	if err != nil {
+		log.Warn("failed to initialize NATS producer", "error", err)
+		natsProducer = nil
+	} else {
+		log.Info("NATS producer initialized successfully")
+	}
+	app.natsProducer = natsProducer
+
 	// Auth setup
-	studentRepo := student.NewRepository(database, app.metrics)
 	authRepo := auth.NewRepository(database, app.metrics)
-	authService := auth.NewService(authRepo, studentRepo)
+	purgeHooks := []student.PurgeHook{authRepo.PurgeStudents}
+	if natsProducer != nil {
+		purgeHooks = append(purgeHooks, message.PurgeHook(natsProducer, cfg.NATS.PurgeSubject))
+	} else {
+		log.Warn("purged students will keep their messages in project-service without NATS")
+	}
+	studentRepo := student.NewAuditedRepository(student.NewRepository(database, app.metrics, purgeHooks...), rbac.GetPrincipal)
+	mailer, err := mail.New(mail.Config{
+		Driver:   cfg.Mail.Driver,
+		From:     cfg.Mail.From,
+		Host:     cfg.Mail.Host,
+		Port:     cfg.Mail.Port,
+		Username: cfg.Mail.Username,
+		Password: cfg.Mail.Password,
+		Dir:      cfg.Mail.Dir,
+	})
+	if err != nil {
+		systemLog.Fatal("failed to initialize mailer:", err)
+	}
+	unverifiedLogin, err := auth.ParseUnverifiedPolicy(cfg.Auth.UnverifiedLogin)
+	if err != nil {
+		systemLog.Fatal("invalid auth config:", err)
+	}
+	tokenSources, err := auth.ParseTokenSources(cfg.Auth.TokenSources)
+	if err != nil {
+		systemLog.Fatal("invalid auth config:", err)
+	}
+	var mfaRoles []rbac.Role
+	for _, name := range cfg.Auth.MFA.RequiredRoles {
+		role, err := rbac.ParseRole(name)
+		if err != nil {
+			systemLog.Fatal("invalid auth config:", err)
+		}
+		mfaRoles = append(mfaRoles, role)
+	}
+	if cfg.Auth.OIDC.Issuer != "" && (cfg.Auth.OIDC.ClientID == "" || cfg.Auth.OIDC.RedirectURL == "") {
+		systemLog.Fatal("invalid auth config: oidc needs client_id and redirect_url")
+	}
+	hasher, err := password.NewArgon2id(password.Params{
+		Memory:      cfg.Auth.Password.Argon2.MemoryKiB,
+		Iterations:  cfg.Auth.Password.Argon2.Iterations,
+		Parallelism: cfg.Auth.Password.Argon2.Parallelism,
+	})
+	if err != nil {
+		systemLog.Fatal("invalid auth config:", err)
+	}
+	passwordPolicy := password.Policy{
+		MinLength:  cfg.Auth.Password.MinLength,
+		MaxLength:  cfg.Auth.Password.MaxLength,
+		MinClasses: cfg.Auth.Password.MinCharacterClasses,
+	}
+	if cfg.Auth.Password.DenyListFile != "" {
+		if passwordPolicy.DenyList, err = password.LoadDenyList(cfg.Auth.Password.DenyListFile); err != nil {
+			systemLog.Fatal("invalid auth config:", err)
+		}
+	}
+	keys, err := auth.LoadKeySet(auth.KeyConfig{
+		Dir:          cfg.Auth.JWT.KeysDir,
+		SigningKeyID: cfg.Auth.JWT.SigningKeyID,
+		AcceptHS256:  cfg.Auth.JWT.AcceptHS256,
+	})
+	if err != nil {
+		systemLog.Fatal("failed to load JWT keys:", err)
+	}
+	// Without NATS other instances learn about revocations on resync only
+	var revocationBus auth.RevocationBus
+	if natsProducer != nil {
+		revocationBus = natsProducer
+	}
+	authService := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+		AppURL:               cfg.Auth.AppURL,
+		PasswordResetTTL:     time.Duration(cfg.Auth.PasswordResetTTLMinutes) * time.Minute,
+		EmailVerificationTTL: time.Duration(cfg.Auth.EmailVerificationTTLHours) * time.Hour,
+		InvitationTTL:        time.Duration(cfg.Auth.InvitationTTLHours) * time.Hour,
+		UnverifiedLogin:      unverifiedLogin,
+		TokenSources:         tokenSources,
+		Throttle: auth.ThrottleConfig{
+			AccountAttempts: cfg.Auth.LoginThrottle.AccountAttempts,
+			IPAttempts:      cfg.Auth.LoginThrottle.IPAttempts,
+			BaseDelay:       time.Duration(cfg.Auth.LoginThrottle.BaseDelaySeconds) * time.Second,
+			MaxLockout:      time.Duration(cfg.Auth.LoginThrottle.MaxLockoutMinutes) * time.Minute,
+			Window:          time.Duration(cfg.Auth.LoginThrottle.WindowMinutes) * time.Minute,
+		},
+		MFA: auth.MFAConfig{
+			Issuer:        cfg.Auth.MFA.Issuer,
+			RequiredRoles: mfaRoles,
+			ChallengeTTL:  time.Duration(cfg.Auth.MFA.ChallengeTTLMinutes) * time.Minute,
+		},
+		OIDC: auth.OIDCConfig{
+			Issuer:      cfg.Auth.OIDC.Issuer,
+			ClientID:    cfg.Auth.OIDC.ClientID,
+			RedirectURL: cfg.Auth.OIDC.RedirectURL,
+			Scopes:      cfg.Auth.OIDC.Scopes,
+			Provision:   cfg.Auth.OIDC.Provision,
+		},
+		PasswordHasher: hasher,
+		PasswordPolicy: passwordPolicy,
+		Revocation: auth.RevocationConfig{
+			Bus:            revocationBus,
+			Subject:        cfg.Auth.Revocation.Subject,
+			ResyncInterval: time.Duration(cfg.Auth.Revocation.ResyncIntervalSeconds) * time.Second,
+		},
+	}, log)
+	app.authService = authService
 	authHandler := auth.NewHandler(authService, log)
 	authHandler.RegisterRoutes(app.router)
 
 	// Student endpoints (auth required)
 	studentService := student.NewService(studentRepo)
-	studentHandler := student.NewHandler(studentService, log, app.serviceMetrics)
+	app.studentService = studentService
+	studentHandler := student.NewHandler(studentService, authService, log, app.serviceMetrics)
 
 	// Project client endpoints (auth required)
 	grpcClient, err := projectclient.NewGrpcClient(cfg.ProjectService.GrpcAddress)
@@ -149,19 +284,9 @@ func New() *App {
 
 	projectHandler := projectclient.NewHandler(grpcClient, log, app.serviceMetrics)
 
-	// NATS producer setup
-	natsProducer, err := messaging.NewProducer(cfg.NATS.URL, cfg.NATS.Subject, log)
-	if err != nil {
-		log.Warn("failed to initialize NATS producer", "error", err)
-		natsProducer = nil
-	} else {
-		log.Info("NATS producer initialized successfully")
-	}
-	app.natsProducer = natsProducer
-
 	// Create protected routes group for /api endpoints
 	apiGroup := app.router.Group("/api")
-	apiGroup.Use(auth.AuthMiddleware(log))
+	apiGroup.Use(auth.AuthMiddleware(keys, authService, authService, log, tokenSources...))
 	studentHandler.RegisterRoutes(apiGroup)
 	projectHandler.RegisterRoutes(apiGroup)
 
@@ -246,6 +371,66 @@ func (a *App) StartPeriodicLogging(ctx context.Context) {
 	}
 }
 
+// StartCleanup periodically removes students whose soft delete is older
+// than the configured retention, unless the purge is disabled, expired
+// refresh tokens and stale failed login counters
+func (a *App) StartCleanup(ctx context.Context) {
+	cfg := a.config.Students
+	purgeStudents := cfg.DeletedRetentionDays > 0
+	if !purgeStudents {
+		a.logger.Info("student purge disabled")
+	}
+
+	interval := time.Duration(cfg.PurgeIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retention := time.Duration(cfg.DeletedRetentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	a.logger.Info("starting cleanup", "interval", interval.String(), "retention_days", cfg.DeletedRetentionDays)
+
+	for {
+		if purgeStudents {
+			purged, err := a.studentService.PurgeDeletedStudents(ctx, retention)
+			if err != nil {
+				a.logger.Error("failed to purge deleted students", "error", err)
+			} else if purged > 0 {
+				a.logger.Info("purged deleted students", "count", purged)
+			}
+		}
+
+		expired, err := a.authService.PruneRefreshTokens(ctx)
+		if err != nil {
+			a.logger.Error("failed to prune refresh tokens", "error", err)
+		} else if expired > 0 {
+			a.logger.Info("pruned expired refresh tokens", "count", expired)
+		}
+
+		pruned, err := a.authService.PruneLoginAttempts(ctx)
+		if err != nil {
+			a.logger.Error("failed to prune login attempts", "error", err)
+		} else if pruned > 0 {
+			a.logger.Info("pruned stale login attempts", "count", pruned)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			a.logger.Info("stopping cleanup")
+			return
+		}
+	}
+}
+
+// StartRevocationSync keeps the revoked access tokens known to this
+// instance up to date with the other instances
+func (a *App) StartRevocationSync(ctx context.Context) {
+	a.authService.SyncRevocations(ctx)
+}
+
 // StartHealthChecks periodically checks dependencies and reports status
 func (a *App) StartHealthChecks(ctx context.Context) {
 	if a.metrics == nil {
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"student-service/internal/auth"
+	"student-service/internal/config"
+	"student-service/internal/db"
+	"student-service/internal/rbac"
+	"student-service/internal/student"
+
+	"grud/common/logger"
+	"grud/common/metrics"
+	"grud/common/migrate"
+)
+
+// ErrSeedNotAllowed is returned when seeding is attempted outside local environments
+var ErrSeedNotAllowed = errors.New("seeding is only allowed in local and kind environments")
+
+// Migrate runs a "migrate" subcommand (up, down, status, redo) against the configured database
+func Migrate(ctx context.Context, command string, out io.Writer) error {
+	cfg, log, err := bootstrap()
+	if err != nil {
+		return err
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	migrator, err := db.NewMigrator(database, log)
+	if err != nil {
+		return err
+	}
+	return migrate.Run(ctx, migrator, command, out)
+}
+
+// Seed applies pending migrations and inserts sample data for local development
+func Seed(ctx context.Context) error {
+	cfg, _, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	if cfg.Env != "local" && cfg.Env != "kind" {
+		return fmt.Errorf("%w (env %q)", ErrSeedNotAllowed, cfg.Env)
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	if err := db.RunMigrations(ctx, database); err != nil {
+		return err
+	}
+	return db.Seed(ctx, database)
+}
+
+// SetRole assigns a role to the student with the given email. It is how the
+// first admin is created, after which admins manage roles over the API.
+func SetRole(ctx context.Context, email, roleName string) error {
+	role, err := rbac.ParseRole(roleName)
+	if err != nil {
+		return err
+	}
+
+	cfg, _, err := bootstrap()
+	if err != nil {
+		return err
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	if err := db.VerifyMigrations(ctx, database); err != nil {
+		return err
+	}
+
+	// One-off commands export no telemetry
+	repo := student.NewAuditedRepository(student.NewRepository(database, metrics.NewMock()), nil)
+	stud, err := repo.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("student %q: %w", email, err)
+	}
+	_, err = student.NewService(repo).SetStudentRole(ctx, stud.ID, role)
+	return err
+}
+
+// ResetMFA removes the second factor of the student with the given email,
+// for someone who lost both the authenticator and the recovery codes
+func ResetMFA(ctx context.Context, email string) error {
+	cfg, log, err := bootstrap()
+	if err != nil {
+		return err
+	}
+
+	database := db.New(cfg.Database)
+	defer database.Close()
+
+	if err := db.VerifyMigrations(ctx, database); err != nil {
+		return err
+	}
+
+	// One-off commands export no telemetry
+	repo := student.NewRepository(database, metrics.NewMock())
+	stud, err := repo.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("student %q: %w", email, err)
+	}
+	authRepo := auth.NewRepository(database, metrics.NewMock())
+	return auth.NewService(authRepo, repo, nil, nil, auth.Config{}, log).ResetMFA(ctx, stud.ID)
+}
+
+// GenerateJWTKey writes a new access token signing key into dir. See the
+// key rotation procedure in the README for how to roll it out.
+func GenerateJWTKey(dir, kid, alg string, out io.Writer) error {
+	path, err := auth.GenerateKeyFile(dir, kid, alg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "wrote", path)
+	return nil
+}
+
+// bootstrap sets up logging and loads config for one-off commands
+func bootstrap() (*config.Config, *slog.Logger, error) {
+	log := logger.NewWithServiceContext(ServiceName, Version)
+	slog.SetDefault(log)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return cfg, log, nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"student-service/internal/rbac"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidScope   = errors.New("invalid API key scope")
+	ErrAPIKeyExpiry   = errors.New("API key expiry must be in the future")
+)
+
+// apiKeyPrefix starts every API key, so that AuthMiddleware can tell keys
+// from access tokens and leaked keys are easy to search for
+const apiKeyPrefix = "grud_"
+
+// apiKeyPrefixLen is how much of a key is stored in plain text to recognize
+// it by
+const apiKeyPrefixLen = len(apiKeyPrefix) + 8
+
+// apiKeyTouchInterval limits how often the last use of a key is written, so
+// that a busy client does not cause a write per request
+const apiKeyTouchInterval = time.Minute
+
+// isAPIKey reports whether a bearer token is an API key
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// APIKeyVerifier looks up the API key a request presents. AuthMiddleware
+// refuses API keys without one.
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (*APIKey, error)
+}
+
+// CreateAPIKey creates an API key holding the scopes. The key itself is only
+// part of the result, the database keeps its hash.
+func (s *Service) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (*CreatedAPIKey, error) {
+	scopes := make([]rbac.Permission, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		scope, err := rbac.ParsePermission(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidScope, err)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrAPIKeyExpiry
+	}
+
+	secret, err := randomURLString(32)
+	if err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + secret
+	createdBy, _ := GetStudentID(ctx)
+	apiKey := &APIKey{
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    key[:apiKeyPrefixLen],
+		KeyHash:   hashAccountToken(key),
+		Scopes:    scopes,
+		CreatedBy: &createdBy,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.authRepo.CreateAPIKey(ctx, apiKey); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "API key created",
+		"api_key_id", apiKey.ID, "name", apiKey.Name, "scopes", scopes, "actor_id", createdBy)
+	return &CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// ListAPIKeys returns all API keys without their secrets
+func (s *Service) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys, err := s.authRepo.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey makes an API key stop working at once
+func (s *Service) RevokeAPIKey(ctx context.Context, id int64) error {
+	err := s.authRepo.RevokeAPIKey(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	actor, _ := GetStudentID(ctx)
+	s.logger.InfoContext(ctx, "API key revoked", "api_key_id", id, "actor_id", actor)
+	return nil
+}
+
+// VerifyAPIKey returns the active API key, recording its use
+func (s *Service) VerifyAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	apiKey, err := s.authRepo.GetActiveAPIKey(ctx, hashAccountToken(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		// The request may go on even if this bookkeeping fails
+		if err := s.authRepo.TouchAPIKey(ctx, apiKey.ID); err != nil {
+			s.logger.WarnContext(ctx, "failed to record API key use", "api_key_id", apiKey.ID, "error", err)
+		}
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+const (
+	// CSRFCookieName is the cookie holding the CSRF token of a cookie session.
+	// Scripts of the app can read it, unlike the access token cookie.
+	CSRFCookieName = "csrf_token"
+	// CSRFHeader is the header a cookie-authenticated request repeats the
+	// CSRF token in
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// csrfSafeMethods do not change anything and need no CSRF token
+var csrfSafeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// validCSRF reports whether a request authenticated by the token cookie may
+// go on. Unsafe methods have to repeat the CSRF cookie in CSRFHeader. Another
+// site can make the browser send both cookies, but it can neither read the
+// token nor set the header without passing CORS.
+func validCSRF(r *http.Request) bool {
+	if csrfSafeMethods[r.Method] {
+		return true
+	}
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+// SetCSRFCookie sets the CSRF token next to the access token cookie. It lives
+// as long as the access token cookie and is replaced with it.
+func SetCSRFCookie(w http.ResponseWriter, token string) {
+	sameSite := http.SameSiteStrictMode
+	env := os.Getenv("ENV")
+	if env == "development" || env == "local" {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		HttpOnly: false, // read by the app to fill in CSRFHeader
+		Secure:   env == "production" || env == "prod" || env == "gcp-gke",
+		SameSite: sameSite,
+		Path:     "/",
+		MaxAge:   900, // 15 minutes, as the access token cookie
+	})
+}
+
+// ClearCSRFCookie removes the CSRF cookie
+func ClearCSRFCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    "",
+		Secure:   os.Getenv("ENV") != "local",
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+		MaxAge:   -1,
+	})
+}
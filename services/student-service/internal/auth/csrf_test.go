@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddlewareCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := NewHMACKeySet([]byte("test-secret"))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	token, err := keys.GenerateAccessToken(Claims{StudentID: 1})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(AuthMiddleware(keys, nil, nil, logger))
+	router.Any("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// serve sends a request authenticated by the cookie, or by a bearer
+	// token if bearer is set
+	serve := func(method string, bearer bool, csrfCookie, csrfHeader string) int {
+		req := httptest.NewRequest(method, "/", nil)
+		if bearer {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		if csrfCookie != "" {
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: csrfCookie})
+		}
+		if csrfHeader != "" {
+			req.Header.Set(CSRFHeader, csrfHeader)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		bearer     bool
+		csrfCookie string
+		csrfHeader string
+		wantStatus int
+	}{
+		{"matching header", http.MethodPost, false, "csrf-1", "csrf-1", http.StatusOK},
+		{"no header", http.MethodPost, false, "csrf-1", "", http.StatusForbidden},
+		{"other header", http.MethodDelete, false, "csrf-1", "csrf-2", http.StatusForbidden},
+		{"no cookie", http.MethodPut, false, "", "csrf-1", http.StatusForbidden},
+		{"neither", http.MethodPatch, false, "", "", http.StatusForbidden},
+		{"safe method", http.MethodGet, false, "", "", http.StatusOK},
+		{"head", http.MethodHead, false, "", "", http.StatusOK},
+		{"bearer token", http.MethodPost, true, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, serve(tt.method, tt.bearer, tt.csrfCookie, tt.csrfHeader))
+		})
+	}
+}
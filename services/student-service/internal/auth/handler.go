@@ -3,7 +3,16 @@ package auth
 import (
 	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"student-service/internal/password"
+	"student-service/internal/rbac"
+	"student-service/internal/student"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -23,11 +32,41 @@ func NewHandler(service *Service, logger *slog.Logger) *Handler {
 	}
 }
 
+// AuthModeHeader lets a client pick how it receives tokens. With
+// "X-Auth-Mode: token" the auth endpoints return the tokens in the body only
+// and never set or clear cookies, for clients that send a bearer header.
+const AuthModeHeader = "X-Auth-Mode"
+
 func (h *Handler) RegisterRoutes(router gin.IRouter) {
+	router.GET("/.well-known/jwks.json", h.JWKS)
 	router.POST("/auth/register", h.Register)
 	router.POST("/auth/login", h.Login)
+	router.POST("/auth/login/mfa", h.LoginMFA)
+	router.GET("/auth/oidc/login", h.OIDCLogin)
+	router.GET("/auth/oidc/callback", h.OIDCCallback)
 	router.POST("/auth/refresh", h.Refresh)
 	router.POST("/auth/logout", h.Logout)
+	router.POST("/auth/password/change", h.authenticate(), h.ChangePassword)
+	router.POST("/auth/password/forgot", h.ForgotPassword)
+	router.POST("/auth/password/reset", h.ResetPassword)
+	router.POST("/auth/verify", h.VerifyEmail)
+	router.POST("/auth/verify/resend", h.ResendVerification)
+	router.POST("/auth/invitation/accept", h.AcceptInvitation)
+	router.GET("/auth/sessions", h.authenticate(), h.ListSessions)
+	router.DELETE("/auth/sessions", h.authenticate(), h.LogoutAll)
+	router.DELETE("/auth/sessions/:id", h.authenticate(), h.RevokeSession)
+	router.GET("/auth/mfa", h.authenticate(), h.MFAStatus)
+	router.POST("/auth/mfa/totp", h.authenticate(), h.EnrollTOTP)
+	router.POST("/auth/mfa/totp/confirm", h.authenticate(), h.ConfirmTOTP)
+	router.DELETE("/auth/mfa/totp", h.authenticate(), h.DisableTOTP)
+	router.POST("/auth/mfa/recovery-codes", h.authenticate(), h.RegenerateRecoveryCodes)
+	router.POST("/auth/accounts/:id/unlock", h.authenticate(), rbac.RequirePermission(rbac.AccountsUnlock), h.UnlockAccount)
+	router.POST("/auth/accounts/:id/suspend", h.authenticate(), rbac.RequirePermission(rbac.AccountsSuspend), h.SuspendAccount)
+	router.DELETE("/auth/accounts/:id/suspend", h.authenticate(), rbac.RequirePermission(rbac.AccountsSuspend), h.UnsuspendAccount)
+	router.POST("/auth/accounts/:id/logout", h.authenticate(), rbac.RequirePermission(rbac.AccountsSuspend), h.ForceLogout)
+	router.POST("/auth/api-keys", h.authenticate(), rbac.RequirePermission(rbac.APIKeysManage), h.CreateAPIKey)
+	router.GET("/auth/api-keys", h.authenticate(), rbac.RequirePermission(rbac.APIKeysManage), h.ListAPIKeys)
+	router.DELETE("/auth/api-keys/:id", h.authenticate(), rbac.RequirePermission(rbac.APIKeysManage), h.RevokeAPIKey)
 }
 
 func (h *Handler) Register(c *gin.Context) {
@@ -44,19 +83,23 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.Register(c.Request.Context(), req)
+	resp, err := h.service.Register(c.Request.Context(), req, clientInfo(c, req.DeviceLabel))
 	if err != nil {
 		if errors.Is(err, ErrEmailExists) {
 			c.String(http.StatusConflict, err.Error())
 			return
 		}
+		if errors.Is(err, password.ErrWeakPassword) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
 		h.logger.Error("registration failed", "error", err)
 		c.String(http.StatusInternalServerError, "internal server error")
 		return
 	}
 
-	// Set access token in cookie
-	SetAuthCookie(c.Writer, resp.AccessToken)
+	// Set access token in cookie, unless the student has to verify first
+	h.setAuthCookie(c, resp)
 
 	// Return response with refresh token in body
 	c.JSON(http.StatusCreated, resp)
@@ -76,26 +119,177 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.Login(c.Request.Context(), req)
+	resp, challenge, err := h.service.Login(c.Request.Context(), req, clientInfo(c, req.DeviceLabel))
 	if err != nil {
 		if errors.Is(err, ErrInvalidCredentials) {
 			c.String(http.StatusUnauthorized, err.Error())
 			return
 		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountSuspended) {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+		if h.throttled(c, err) {
+			return
+		}
 		h.logger.Error("login failed", "error", err)
 		c.String(http.StatusInternalServerError, "internal server error")
 		return
 	}
 
+	// The second factor is still missing, no tokens yet
+	if challenge != nil {
+		h.logger.Info("password accepted, second factor required", "email", req.Email)
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
 	h.logger.Info("student logged in", "email", req.Email)
 
 	// Set access token in cookie
-	SetAuthCookie(c.Writer, resp.AccessToken)
+	h.setAuthCookie(c, resp)
 
 	// Return response with refresh token in body
 	c.JSON(http.StatusOK, resp)
 }
 
+// LoginMFA completes a login with a TOTP or recovery code
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := h.service.CompleteMFALogin(c.Request.Context(), req, clientInfo(c, ""))
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFAToken) || errors.Is(err, ErrInvalidMFACode) {
+			c.String(http.StatusUnauthorized, err.Error())
+			return
+		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountSuspended) {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+		if h.throttled(c, err) {
+			return
+		}
+		h.logger.Error("two-factor login failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	h.logger.Info("student logged in with second factor")
+
+	h.setAuthCookie(c, resp)
+	c.JSON(http.StatusOK, resp)
+}
+
+// OIDCLogin sends the browser to the OpenID Connect provider. The optional
+// deviceLabel query parameter names the session.
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	deviceLabel := strings.TrimSpace(c.Query("deviceLabel"))
+	if len(deviceLabel) > 64 {
+		c.String(http.StatusBadRequest, "deviceLabel is too long")
+		return
+	}
+
+	login, err := h.service.BeginOIDCLogin(c.Request.Context(), deviceLabel)
+	if err != nil {
+		if h.oidcError(c, err) {
+			return
+		}
+		h.logger.Error("starting OIDC login failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	setOIDCStateCookie(c.Writer, login.State, time.Until(login.ExpiresAt))
+	c.Redirect(http.StatusFound, login.URL)
+}
+
+// OIDCCallback completes a provider login and sends the browser back to the
+// app. The callback is a navigation, so what Login returns in the body goes
+// in the fragment of the app URL instead, which never reaches a server: the
+// refresh and CSRF tokens next to the cookies, or the MFA challenge for
+// students with 2FA.
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	// The state is single-use whatever the outcome
+	clearOIDCStateCookie(c.Writer)
+
+	resp, challenge, err := h.service.CompleteOIDCLogin(c.Request.Context(), stateToken, OIDCCallback{
+		Code:  c.Query("code"),
+		State: c.Query("state"),
+		Error: c.Query("error"),
+	}, clientInfo(c, ""))
+	if err != nil {
+		if h.oidcError(c, err) {
+			return
+		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountSuspended) {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+		h.logger.Error("OIDC login failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// The fragment holds tokens, so the redirect must not be cached
+	c.Header("Cache-Control", "no-store")
+
+	if challenge != nil {
+		h.logger.Info("provider login accepted, second factor required")
+		c.Redirect(http.StatusFound, h.service.appRedirect(url.Values{
+			"mfaRequired": {"true"},
+			"mfaToken":    {challenge.MFAToken},
+			"expiresAt":   {challenge.ExpiresAt.UTC().Format(time.RFC3339)},
+		}))
+		return
+	}
+
+	h.logger.Info("student logged in with provider")
+
+	h.setAuthCookie(c, resp)
+	fragment := url.Values{"refreshToken": {resp.RefreshToken}}
+	if resp.CSRFToken != "" {
+		fragment.Set("csrfToken", resp.CSRFToken)
+	}
+	if resp.MFAEnrollmentRequired {
+		fragment.Set("mfaEnrollmentRequired", "true")
+	}
+	c.Redirect(http.StatusFound, h.service.appRedirect(fragment))
+}
+
+// oidcError writes the response for the errors of a provider login and
+// reports whether err was one of them
+func (h *Handler) oidcError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ErrOIDCDisabled):
+		c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidOIDCState):
+		c.String(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrOIDCLoginDenied):
+		c.String(http.StatusUnauthorized, ErrOIDCLoginDenied.Error())
+	case errors.Is(err, ErrOIDCEmailNotVerified), errors.Is(err, ErrOIDCNoAccount):
+		c.String(http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrOIDCProvider):
+		h.logger.Error("identity provider failed", "error", err)
+		c.String(http.StatusBadGateway, ErrOIDCProvider.Error())
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *Handler) Refresh(c *gin.Context) {
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -110,19 +304,23 @@ func (h *Handler) Refresh(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.RefreshAccessToken(c.Request.Context(), req.RefreshToken)
+	resp, err := h.service.RefreshAccessToken(c.Request.Context(), req.RefreshToken, clientInfo(c, ""))
 	if err != nil {
 		if errors.Is(err, ErrInvalidRefreshToken) {
 			c.String(http.StatusUnauthorized, err.Error())
 			return
 		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountSuspended) {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
 		h.logger.Error("token refresh failed", "error", err)
 		c.String(http.StatusInternalServerError, "internal server error")
 		return
 	}
 
 	// Set new access token in cookie
-	SetAuthCookie(c.Writer, resp.AccessToken)
+	h.setAuthCookie(c, resp)
 
 	// Return response with new refresh token
 	c.JSON(http.StatusOK, resp)
@@ -136,16 +334,657 @@ func (h *Handler) Logout(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+	// The access token is revoked too when it is still valid
+	var accessToken *Claims
+	if token, source := requestToken(c.Request, h.tokenSources()); source != "" {
+		accessToken, _ = h.service.keys.ValidateAccessToken(token)
+	}
+
+	if err := h.service.Logout(c.Request.Context(), req.RefreshToken, accessToken); err != nil {
 		h.logger.Error("logout failed", "error", err)
 		c.String(http.StatusInternalServerError, "internal server error")
 		return
 	}
 
 	// Clear auth cookie
-	ClearAuthCookie(c.Writer)
+	h.clearAuthCookie(c)
 
 	h.logger.Info("student logged out")
 
 	c.Status(http.StatusNoContent)
 }
+
+// ChangePassword changes the password of the logged-in student and signs out
+// all of their other sessions
+func (h *Handler) ChangePassword(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := h.service.ChangePassword(c.Request.Context(), studentID, req, clientInfo(c, ""))
+	if err != nil {
+		if errors.Is(err, ErrIncorrectPassword) || errors.Is(err, password.ErrWeakPassword) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("password change failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Other sessions are gone, keep this one with new tokens
+	h.setAuthCookie(c, resp)
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ForgotPassword sends a password reset link. The response is the same
+// whether the email is registered or not.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("password reset request failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a reset token
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), req); err != nil {
+		if errors.Is(err, ErrInvalidResetToken) || errors.Is(err, password.ErrWeakPassword) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("password reset failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AcceptInvitation sets the first password of an invited student and logs
+// them in
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := h.service.AcceptInvitation(c.Request.Context(), req, clientInfo(c, req.DeviceLabel))
+	if err != nil {
+		if errors.Is(err, ErrInvalidInvitation) || errors.Is(err, password.ErrWeakPassword) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		// The password is set, but a suspended student gets no tokens
+		if errors.Is(err, ErrAccountSuspended) {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+		h.logger.Error("accepting invitation failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	h.setAuthCookie(c, resp)
+	c.JSON(http.StatusOK, resp)
+}
+
+// VerifyEmail confirms an email address with the token from the verification
+// email. Sessions pick up the verified state on their next refresh.
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		if errors.Is(err, ErrInvalidVerificationToken) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("email verification failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ResendVerification sends a new verification email. The response is the
+// same whether the email is registered, verified or not.
+func (h *Handler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.ResendVerification(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("resending verification failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ListSessions lists the sessions of the logged-in student
+func (h *Handler) ListSessions(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	sessionID, _ := GetSessionID(c.Request.Context())
+
+	sessions, err := h.service.ListSessions(c.Request.Context(), studentID, sessionID)
+	if err != nil {
+		h.logger.Error("listing sessions failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession logs the student out of one of their sessions. Revoking the
+// current session also clears the auth cookie.
+func (h *Handler) RevokeSession(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.service.RevokeSession(c.Request.Context(), studentID, id); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("revoking session failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if current, _ := GetSessionID(c.Request.Context()); current == id {
+		h.clearAuthCookie(c)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll logs the student out of every session, including this one
+func (h *Handler) LogoutAll(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := h.service.LogoutAll(c.Request.Context(), studentID); err != nil {
+		h.logger.Error("logout of all sessions failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	h.clearAuthCookie(c)
+	c.Status(http.StatusNoContent)
+}
+
+// MFAStatus shows the 2FA state of the logged-in student
+func (h *Handler) MFAStatus(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	status, err := h.service.MFAStatus(c.Request.Context(), studentID)
+	if err != nil {
+		h.logger.Error("reading two-factor status failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP starts TOTP enrollment and returns the secret to scan
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	enrollment, err := h.service.EnrollTOTP(c.Request.Context(), studentID)
+	if err != nil {
+		if errors.Is(err, ErrMFAAlreadyEnabled) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("starting TOTP enrollment failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// The secret must not end up in any cache
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP finishes TOTP enrollment and returns the recovery codes
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(c.Request.Context(), studentID, req.Code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, ErrNoPendingEnrollment) || errors.Is(err, ErrMFAAlreadyEnabled) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("confirming TOTP enrollment failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, codes)
+}
+
+// DisableTOTP turns 2FA off for the logged-in student
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	req, ok := h.bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DisableTOTP(c.Request.Context(), studentID, req); err != nil {
+		if !h.mfaError(c, err) {
+			h.logger.Error("disabling TOTP failed", "error", err)
+			c.String(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged-in
+// student
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	studentID, ok := GetStudentID(c.Request.Context())
+	if !ok {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	req, ok := h.bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), studentID, req)
+	if err != nil {
+		if !h.mfaError(c, err) {
+			h.logger.Error("regenerating recovery codes failed", "error", err)
+			c.String(http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, codes)
+}
+
+// bindMFACode reads and validates an MFACodeRequest body
+func (h *Handler) bindMFACode(c *gin.Context) (MFACodeRequest, bool) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// mfaError writes the response for the errors of managing 2FA and reports
+// whether err was one of them
+func (h *Handler) mfaError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ErrInvalidMFACode):
+		c.String(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrMFANotEnabled):
+		c.String(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrMFARequiredForRole):
+		c.String(http.StatusForbidden, err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// UnlockAccount lifts the login lock of a student after too many failed
+// attempts
+func (h *Handler) UnlockAccount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid student id")
+		return
+	}
+
+	if err := h.service.UnlockAccount(c.Request.Context(), id); err != nil {
+		if errors.Is(err, student.ErrStudentNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("unlocking account failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SuspendAccount suspends a student account and signs the student out
+func (h *Handler) SuspendAccount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid student id")
+		return
+	}
+
+	if err := h.service.SuspendAccount(c.Request.Context(), id); err != nil {
+		if errors.Is(err, student.ErrStudentNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, ErrSuspendSelf) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("suspending account failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UnsuspendAccount lifts the suspension of a student account
+func (h *Handler) UnsuspendAccount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid student id")
+		return
+	}
+
+	if err := h.service.UnsuspendAccount(c.Request.Context(), id); err != nil {
+		if errors.Is(err, student.ErrStudentNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("unsuspending account failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ForceLogout signs a student out of every session
+func (h *Handler) ForceLogout(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid student id")
+		return
+	}
+
+	if err := h.service.ForceLogout(c.Request.Context(), id); err != nil {
+		if errors.Is(err, student.ErrStudentNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("signing out account failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CreateAPIKey creates an API key for a machine client. The key is in the
+// response only.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("failed to decode request", "error", err)
+		c.String(http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("validation failed", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.service.CreateAPIKey(c.Request.Context(), req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidScope) || errors.Is(err, ErrAPIKeyExpiry) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("creating API key failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListAPIKeys lists all API keys with their scopes, expiry and last use
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.service.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		h.logger.Error("listing API keys failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes an API key
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid API key id")
+		return
+	}
+
+	if err := h.service.RevokeAPIKey(c.Request.Context(), id); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("revoking API key failed", "error", err)
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// throttled answers 429 with Retry-After if err is a ThrottledError
+func (h *Handler) throttled(c *gin.Context, err error) bool {
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	c.String(http.StatusTooManyRequests, err.Error())
+	return true
+}
+
+// clientInfo describes the client of the request for session metadata
+func clientInfo(c *gin.Context, deviceLabel string) ClientInfo {
+	return ClientInfo{
+		UserAgent:   c.Request.UserAgent(),
+		IPAddress:   c.ClientIP(),
+		DeviceLabel: strings.TrimSpace(deviceLabel),
+	}
+}
+
+// JWKS publishes the public keys access tokens are verified with. Consumers
+// may cache it briefly; a new key is published before it signs any token.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.service.keys.JWKS())
+}
+
+// authenticate is AuthMiddleware with the configured token sources. The
+// account endpoints are for students only, so API keys are refused.
+func (h *Handler) authenticate() gin.HandlerFunc {
+	return AuthMiddleware(h.service.keys, h.service, nil, h.logger, h.tokenSources()...)
+}
+
+// tokenSources returns where access tokens are looked for
+func (h *Handler) tokenSources() []TokenSource {
+	if len(h.service.config.TokenSources) == 0 {
+		return DefaultTokenSources
+	}
+	return h.service.config.TokenSources
+}
+
+// tokenMode reports whether the client asked for tokens in the body only
+func tokenMode(c *gin.Context) bool {
+	return strings.EqualFold(c.GetHeader(AuthModeHeader), "token")
+}
+
+// setAuthCookie sets the access token cookie for cookie mode clients, with a
+// new CSRF token in a cookie and in the response
+func (h *Handler) setAuthCookie(c *gin.Context, resp *AuthResponse) {
+	if resp.AccessToken == "" || tokenMode(c) {
+		return
+	}
+	csrfToken, err := newRandomID()
+	if err != nil {
+		// Without the CSRF token the cookie only works for safe methods
+		h.logger.Error("failed to generate CSRF token", "error", err)
+	} else {
+		SetCSRFCookie(c.Writer, csrfToken)
+		resp.CSRFToken = csrfToken
+	}
+	SetAuthCookie(c.Writer, resp.AccessToken)
+}
+
+// clearAuthCookie clears the access token and CSRF cookies for cookie mode
+// clients
+func (h *Handler) clearAuthCookie(c *gin.Context) {
+	if !tokenMode(c) {
+		ClearAuthCookie(c.Writer)
+		ClearCSRFCookie(c.Writer)
+	}
+}
@@ -4,15 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	commonmetrics "grud/common/metrics"
+	"grud/testing/oidctest"
 	"grud/testing/testdb"
+	"grud/testing/testnats"
 	"student-service/internal/auth"
+	"student-service/internal/mail"
+	"student-service/internal/messaging"
+	"student-service/internal/password"
+	"student-service/internal/rbac"
 	"student-service/internal/student"
 
 	"github.com/gin-gonic/gin"
@@ -32,14 +43,17 @@ func TestAuthService_Shared(t *testing.T) {
 	defer pgContainer.Cleanup(t)
 
 	// Run migrations for students and refresh_tokens tables
-	pgContainer.RunMigrations(t, (*student.Student)(nil), (*auth.RefreshToken)(nil))
+	pgContainer.RunMigrations(t, (*student.Student)(nil), (*auth.RefreshToken)(nil), (*auth.AccountToken)(nil), (*auth.LoginAttempt)(nil),
+		(*auth.TOTPCredential)(nil), (*auth.RecoveryCode)(nil), (*auth.FederatedIdentity)(nil), (*auth.APIKey)(nil), (*auth.Revocation)(nil))
 
 	// Create handler ONCE and reuse across all subtests
 	mockMetrics := commonmetrics.NewMock()
 	studentRepo := student.NewRepository(pgContainer.DB, mockMetrics)
 	authRepo := auth.NewRepository(pgContainer.DB, mockMetrics)
-	authService := auth.NewService(authRepo, studentRepo)
+	mailer := mail.NewMemoryMailer()
+	keys := auth.NewHMACKeySet([]byte("test-secret-key-for-testing"))
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	authService := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{AppURL: "https://app.example.com"}, logger)
 	authHandler := auth.NewHandler(authService, logger)
 	router := gin.New()
 	authHandler.RegisterRoutes(router)
@@ -247,6 +261,36 @@ func TestAuthService_Shared(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "invalid email or password")
 	})
 
+	t.Run("Login_UnknownEmailTakesAsLong", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "login_attempts")
+		defer testdb.CleanupTables(t, pgContainer.DB, "login_attempts")
+
+		argon, err := password.NewArgon2id(password.DefaultParams)
+		require.NoError(t, err)
+		hasher := &recordingHasher{Hasher: argon}
+		service := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{PasswordHasher: hasher}, logger)
+		r := gin.New()
+		auth.NewHandler(service, logger).RegisterRoutes(r)
+
+		// An unknown email still costs a full password verification
+		w := postJSON(r, "/auth/login", map[string]interface{}{"email": "nobody@example.com", "password": "password123"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Len(t, hasher.verified, 1)
+		assert.True(t, strings.HasPrefix(hasher.verified[0], "$argon2id$"), "verified against a real hash")
+
+		// A failed lookup is not a wrong password
+		failing := auth.NewService(authRepo, failingLookups{Repository: studentRepo}, mailer, keys, auth.Config{}, logger)
+		r = gin.New()
+		auth.NewHandler(failing, logger).RegisterRoutes(r)
+		w = postJSON(r, "/auth/login", map[string]interface{}{"email": "nobody@example.com", "password": "password123"})
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+		// Only the unknown email counts as a failed attempt of the client
+		var attempt auth.LoginAttempt
+		require.NoError(t, pgContainer.DB.NewSelect().Model(&attempt).Where("scope = ?", auth.ScopeIP).Scan(context.Background()))
+		assert.Equal(t, 1, attempt.Failures)
+	})
+
 	t.Run("Login_ValidationError", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
 
@@ -265,6 +309,210 @@ func TestAuthService_Shared(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
+	t.Run("LoginThrottling", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "login_attempts")
+		defer testdb.CleanupTables(t, pgContainer.DB, "login_attempts")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		target := &student.Student{FirstName: "Locked", LastName: "Out", Email: "locked@example.com", Password: string(hashedPassword)}
+		_, err := pgContainer.DB.NewInsert().Model(target).Exec(ctx)
+		require.NoError(t, err)
+
+		service := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			Throttle: auth.ThrottleConfig{AccountAttempts: 2, IPAttempts: 100, BaseDelay: time.Minute},
+		}, logger)
+		throttledRouter := gin.New()
+		auth.NewHandler(service, logger).RegisterRoutes(throttledRouter)
+
+		wrong := map[string]interface{}{"email": "locked@example.com", "password": "wrongpassword"}
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusUnauthorized, postJSON(throttledRouter, "/auth/login", wrong).Code)
+		}
+
+		// The third failure locks the account for the base delay
+		w := postJSON(throttledRouter, "/auth/login", wrong)
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+		// Even the right password is refused while locked, and the email case
+		// does not matter
+		w = postJSON(throttledRouter, "/auth/login", map[string]interface{}{"email": "LOCKED@example.com", "password": "password123"})
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		// Only an admin may unlock
+		unlock := func(role rbac.Role, id int) int {
+			token, err := keys.GenerateAccessToken(auth.Claims{StudentID: 999, Role: role})
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/auth/accounts/"+strconv.Itoa(id)+"/unlock", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			throttledRouter.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusForbidden, unlock(rbac.RoleStaff, target.ID))
+		assert.Equal(t, http.StatusNotFound, unlock(rbac.RoleAdmin, target.ID+1000))
+		assert.Equal(t, http.StatusNoContent, unlock(rbac.RoleAdmin, target.ID))
+
+		correct := map[string]interface{}{"email": "locked@example.com", "password": "password123"}
+		assert.Equal(t, http.StatusOK, postJSON(throttledRouter, "/auth/login", correct).Code)
+
+		// A successful login resets the counter
+		assert.Equal(t, http.StatusUnauthorized, postJSON(throttledRouter, "/auth/login", wrong).Code)
+		assert.Equal(t, http.StatusOK, postJSON(throttledRouter, "/auth/login", correct).Code)
+
+		// The IP is throttled across accounts
+		testdb.CleanupTables(t, pgContainer.DB, "login_attempts")
+		ipRouter := gin.New()
+		auth.NewHandler(auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			Throttle: auth.ThrottleConfig{AccountAttempts: 100, IPAttempts: 1, BaseDelay: time.Minute},
+		}, logger), logger).RegisterRoutes(ipRouter)
+		assert.Equal(t, http.StatusUnauthorized, postJSON(ipRouter, "/auth/login", map[string]interface{}{"email": "a@example.com", "password": "x"}).Code)
+		assert.Equal(t, http.StatusTooManyRequests, postJSON(ipRouter, "/auth/login", map[string]interface{}{"email": "b@example.com", "password": "x"}).Code)
+		assert.Equal(t, http.StatusTooManyRequests, postJSON(ipRouter, "/auth/login", correct).Code)
+
+		// Without trusted proxies a forged X-Forwarded-For does not change
+		// the client IP
+		require.NoError(t, ipRouter.SetTrustedProxies(nil))
+		spoofed := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"c@example.com","password":"x"}`))
+		spoofed.Header.Set("Content-Type", "application/json")
+		spoofed.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w = httptest.NewRecorder()
+		ipRouter.ServeHTTP(w, spoofed)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// Cleanup deletes the counters past the window, unless still locked
+		testdb.CleanupTables(t, pgContainer.DB, "login_attempts")
+		past := time.Now().Add(-2 * time.Hour)
+		future := time.Now().Add(time.Hour)
+		for _, attempt := range []*auth.LoginAttempt{
+			{Scope: auth.ScopeAccount, Subject: "stale@example.com", Failures: 3, LastFailureAt: past},
+			{Scope: auth.ScopeAccount, Subject: "locked@example.com", Failures: 9, LastFailureAt: past, LockedUntil: &future},
+			{Scope: auth.ScopeIP, Subject: "192.0.2.1", Failures: 1, LastFailureAt: time.Now()},
+		} {
+			_, err := pgContainer.DB.NewInsert().Model(attempt).Exec(ctx)
+			require.NoError(t, err)
+		}
+		pruned, err := service.PruneLoginAttempts(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, pruned)
+		var subjects []string
+		require.NoError(t, pgContainer.DB.NewSelect().Model((*auth.LoginAttempt)(nil)).Column("subject").Order("subject").Scan(ctx, &subjects))
+		assert.Equal(t, []string{"192.0.2.1", "locked@example.com"}, subjects)
+	})
+
+	t.Run("TwoFactorLogin", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "login_attempts", "totp_credentials", "recovery_codes")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		staff := &student.Student{FirstName: "Staff", LastName: "Member", Email: "staff@example.com", Password: string(hashedPassword), Role: rbac.RoleStaff}
+		_, err := pgContainer.DB.NewInsert().Model(staff).Exec(ctx)
+		require.NoError(t, err)
+
+		service := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			MFA: auth.MFAConfig{Issuer: "GRUD", RequiredRoles: []rbac.Role{rbac.RoleStaff}},
+		}, logger)
+		mfaRouter := gin.New()
+		auth.NewHandler(service, logger).RegisterRoutes(mfaRouter)
+		credentials := map[string]interface{}{"email": "staff@example.com", "password": "password123"}
+
+		// send makes a request with the access token of resp as bearer token
+		send := func(method, path string, resp auth.AuthResponse, payload interface{}) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(payload)
+			req := httptest.NewRequest(method, path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+			w := httptest.NewRecorder()
+			mfaRouter.ServeHTTP(w, req)
+			return w
+		}
+
+		// The policy restricts staff until they enroll
+		w := postJSON(mfaRouter, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, w.Code)
+		var session auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&session))
+		assert.True(t, session.MFAEnrollmentRequired)
+		claims, err := keys.ValidateAccessToken(session.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, claims.Restricted)
+
+		w = send(http.MethodPost, "/auth/mfa/totp", session, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var enrollment auth.TOTPEnrollment
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&enrollment))
+		assert.Contains(t, enrollment.ProvisioningURI, "otpauth://totp/GRUD:staff@example.com?")
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/auth/mfa/totp/confirm", session, map[string]interface{}{"code": "000000"}).Code)
+		code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		w = send(http.MethodPost, "/auth/mfa/totp/confirm", session, map[string]interface{}{"code": code})
+		require.Equal(t, http.StatusOK, w.Code)
+		var recovery auth.RecoveryCodesResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&recovery))
+		require.Len(t, recovery.RecoveryCodes, 10)
+		assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/auth/mfa/totp", session, nil).Code)
+
+		// The password alone gives a challenge, not tokens
+		w = postJSON(mfaRouter, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+		var challenge auth.MFAChallenge
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&challenge))
+		assert.True(t, challenge.MFARequired)
+		require.NotEmpty(t, challenge.MFAToken)
+		_, err = keys.ValidateAccessToken(challenge.MFAToken)
+		assert.Error(t, err, "a challenge is no access token")
+
+		// The code used for enrollment cannot be replayed
+		w = postJSON(mfaRouter, "/auth/login/mfa", map[string]interface{}{"mfaToken": challenge.MFAToken, "code": code})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid two-factor code")
+
+		nextCode, err := auth.TOTPCode(enrollment.Secret, time.Now().Add(30*time.Second))
+		require.NoError(t, err)
+		w = postJSON(mfaRouter, "/auth/login/mfa", map[string]interface{}{"mfaToken": challenge.MFAToken, "code": nextCode})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Result().Cookies())
+		assert.False(t, accessClaims(t, keys, w).Restricted)
+
+		// A recovery code works once, however it is typed
+		code0 := strings.ToUpper(recovery.RecoveryCodes[0])
+		assert.Equal(t, http.StatusOK, useRecoveryLogin(t, mfaRouter, credentials, code0).Code)
+		assert.Equal(t, http.StatusUnauthorized, useRecoveryLogin(t, mfaRouter, credentials, code0).Code)
+
+		assert.Equal(t, http.StatusUnauthorized, postJSON(mfaRouter, "/auth/login/mfa", map[string]interface{}{"mfaToken": "forged", "code": "123456"}).Code)
+
+		assert.Equal(t, http.StatusBadRequest, postJSON(mfaRouter, "/auth/login/mfa", map[string]interface{}{}).Code)
+
+		// The role requires 2FA, so it stays on
+		var full auth.AuthResponse
+		w = useRecoveryLogin(t, mfaRouter, credentials, recovery.RecoveryCodes[1])
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&full))
+		w = send(http.MethodDelete, "/auth/mfa/totp", full, map[string]interface{}{"recoveryCode": recovery.RecoveryCodes[2]})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send(http.MethodGet, "/auth/mfa", full, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var status auth.MFAStatus
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		assert.Equal(t, auth.MFAStatus{Enabled: true, Required: true, RecoveryCodesLeft: 8}, status)
+
+		// A challenge issued before a suspension is no way in
+		w = postJSON(mfaRouter, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&challenge))
+		_, err = pgContainer.DB.NewUpdate().Model(staff).Set("suspended_at = ?", time.Now()).WherePK().Exec(ctx)
+		require.NoError(t, err)
+		w = postJSON(mfaRouter, "/auth/login/mfa", map[string]interface{}{"mfaToken": challenge.MFAToken, "recoveryCode": recovery.RecoveryCodes[3]})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "account is suspended")
+	})
+
 	t.Run("Refresh_Success", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
 
@@ -321,6 +569,444 @@ func TestAuthService_Shared(t *testing.T) {
 		assert.NotEmpty(t, refreshResponse.RefreshToken)
 	})
 
+	t.Run("Refresh_RotationAndReuse", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Rotate",
+			LastName:  "Test",
+			Email:     "rotate@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		credentials := map[string]interface{}{"email": "rotate@example.com", "password": "password123"}
+		login := func() auth.AuthResponse {
+			w := postJSON(router, "/auth/login", credentials)
+			require.Equal(t, http.StatusOK, w.Code)
+			var resp auth.AuthResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			return resp
+		}
+		refresh := func(token string) (int, auth.AuthResponse) {
+			w := postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": token})
+			var resp auth.AuthResponse
+			if w.Code == http.StatusOK {
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			}
+			return w.Code, resp
+		}
+
+		// Logging in on a second device keeps the first session
+		laptop := login()
+		phone := login()
+
+		code, rotated := refresh(laptop.RefreshToken)
+		require.Equal(t, http.StatusOK, code)
+		assert.NotEqual(t, laptop.RefreshToken, rotated.RefreshToken)
+		code, rotated = refresh(rotated.RefreshToken)
+		require.Equal(t, http.StatusOK, code)
+
+		// Replaying a rotated token revokes the whole family, access token
+		// included
+		code, _ = refresh(laptop.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = refresh(rotated.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		req := httptest.NewRequest(http.MethodGet, "/auth/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+rotated.AccessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// Other sessions are not affected
+		code, _ = refresh(phone.RefreshToken)
+		assert.Equal(t, http.StatusOK, code)
+
+		// Cleanup deletes the expired tokens, used ones included
+		total, err := pgContainer.DB.NewSelect().Model((*auth.RefreshToken)(nil)).Count(ctx)
+		require.NoError(t, err)
+		res, err := pgContainer.DB.NewUpdate().Model((*auth.RefreshToken)(nil)).
+			Set("expires_at = ?", time.Now().Add(-time.Minute)).
+			Where("used_at IS NOT NULL").
+			Exec(ctx)
+		require.NoError(t, err)
+		used, err := res.RowsAffected()
+		require.NoError(t, err)
+		require.NotZero(t, used)
+		pruned, err := authService.PruneRefreshTokens(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int(used), pruned)
+		left, err := pgContainer.DB.NewSelect().Model((*auth.RefreshToken)(nil)).Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, total-int(used), left)
+	})
+
+	t.Run("Refresh_TokensHashedAtRest", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		hashed := &student.Student{
+			FirstName: "Hashed",
+			LastName:  "Token",
+			Email:     "hashed@example.com",
+			Password:  string(hashedPassword),
+		}
+		_, err := pgContainer.DB.NewInsert().Model(hashed).Exec(ctx)
+		require.NoError(t, err)
+
+		w := postJSON(router, "/auth/login", map[string]interface{}{"email": "hashed@example.com", "password": "password123"})
+		require.Equal(t, http.StatusOK, w.Code)
+		var session auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&session))
+
+		// Neither column holds the token itself
+		count, err := pgContainer.DB.NewSelect().Model((*auth.RefreshToken)(nil)).
+			Where("token = ? OR token_hash = ?", session.RefreshToken, session.RefreshToken).
+			Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		// A raw token stored before hashing still works once and is hashed on use
+		legacy := &auth.RefreshToken{
+			StudentID: hashed.ID,
+			FamilyID:  "legacy-1",
+			Token:     "legacy-refresh-token",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		_, err = pgContainer.DB.NewInsert().Model(legacy).Exec(ctx)
+		require.NoError(t, err)
+
+		w = postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": "legacy-refresh-token"})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(legacy).WherePK().Scan(ctx))
+		assert.Empty(t, legacy.Token)
+		assert.NotEmpty(t, legacy.TokenHash)
+		assert.NotNil(t, legacy.UsedAt)
+
+		// Replaying it is detected like for any other token
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": "legacy-refresh-token"}).Code)
+
+		// A raw token that is never presented goes with the cleanup once it
+		// expires
+		forgotten := &auth.RefreshToken{
+			StudentID: hashed.ID,
+			FamilyID:  "legacy-2",
+			Token:     "forgotten-refresh-token",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}
+		_, err = pgContainer.DB.NewInsert().Model(forgotten).Exec(ctx)
+		require.NoError(t, err)
+		_, err = authService.PruneRefreshTokens(ctx)
+		require.NoError(t, err)
+		raw, err := pgContainer.DB.NewSelect().Model((*auth.RefreshToken)(nil)).Where("token IS NOT NULL").Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, raw)
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		for _, email := range []string{"sessions@example.com", "other.sessions@example.com"} {
+			_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+				FirstName: "Sessions",
+				LastName:  "Test",
+				Email:     email,
+				Password:  string(hashedPassword),
+			}).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		login := func(email, userAgent, label string) (*httptest.ResponseRecorder, auth.AuthResponse) {
+			body, _ := json.Marshal(map[string]interface{}{"email": email, "password": "password123", "deviceLabel": label})
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", userAgent)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			var resp auth.AuthResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			return w, resp
+		}
+		send := func(method, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			addCookies(req, cookies...)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+		listSessions := func(cookies []*http.Cookie) []auth.Session {
+			w := send(http.MethodGet, "/auth/sessions", cookies)
+			require.Equal(t, http.StatusOK, w.Code)
+			var sessions []auth.Session
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&sessions))
+			return sessions
+		}
+
+		laptopW, _ := login("sessions@example.com", "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0", "")
+		phoneW, phone := login("sessions@example.com", "curl/8.5.0", "My phone")
+		otherW, _ := login("other.sessions@example.com", "curl/8.5.0", "")
+		laptopCookies := laptopW.Result().Cookies()
+
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/auth/sessions", nil).Code)
+
+		sessions := listSessions(laptopCookies)
+		require.Len(t, sessions, 2)
+		labels := map[string]auth.Session{}
+		for _, session := range sessions {
+			labels[session.DeviceLabel] = session
+		}
+		require.Contains(t, labels, "Firefox on Linux")
+		require.Contains(t, labels, "My phone")
+		assert.True(t, labels["Firefox on Linux"].Current)
+		assert.False(t, labels["My phone"].Current)
+		assert.Equal(t, "curl/8.5.0", labels["My phone"].UserAgent)
+		assert.NotEmpty(t, labels["My phone"].IPAddress)
+
+		// Refreshing keeps the session and its label
+		w := postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": phone.RefreshToken})
+		require.Equal(t, http.StatusOK, w.Code)
+		sessions = listSessions(laptopCookies)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "My phone", sessions[0].DeviceLabel, "most recently used first")
+		var refreshed auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&refreshed))
+
+		// Sessions of other students cannot be revoked
+		otherSessions := listSessions(otherW.Result().Cookies())
+		require.Len(t, otherSessions, 1)
+		assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/auth/sessions/"+otherSessions[0].ID, laptopCookies).Code)
+		assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/auth/sessions/unknown", laptopCookies).Code)
+
+		w = send(http.MethodDelete, "/auth/sessions/"+labels["My phone"].ID, laptopCookies)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Result().Cookies(), "revoking another session keeps the cookie")
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": refreshed.RefreshToken}).Code)
+		assert.Len(t, listSessions(laptopCookies), 1)
+		// Its access tokens stop working at once
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/auth/sessions", phoneW.Result().Cookies()).Code)
+
+		// Logging out everywhere ends the remaining sessions and their
+		// access tokens
+		tabletW, _ := login("sessions@example.com", "curl/8.5.0", "")
+		require.Len(t, listSessions(tabletW.Result().Cookies()), 2)
+		w = send(http.MethodDelete, "/auth/sessions", laptopCookies)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/auth/sessions", laptopCookies).Code)
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/auth/sessions", tabletW.Result().Cookies()).Code)
+		assert.Len(t, listSessions(otherW.Result().Cookies()), 1)
+
+		// Logging in again right away works
+		againW, _ := login("sessions@example.com", "curl/8.5.0", "")
+		assert.Len(t, listSessions(againW.Result().Cookies()), 1)
+	})
+
+	t.Run("TokenMode", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Token",
+			LastName:  "Mode",
+			Email:     "token.mode@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		send := func(method, path string, payload interface{}, header http.Header) *httptest.ResponseRecorder {
+			var body []byte
+			if payload != nil {
+				body, _ = json.Marshal(payload)
+			}
+			req := httptest.NewRequest(method, path, bytes.NewReader(body))
+			req.Header = header.Clone()
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+		tokenMode := http.Header{auth.AuthModeHeader: {"token"}}
+
+		w := send(http.MethodPost, "/auth/login", map[string]interface{}{"email": "token.mode@example.com", "password": "password123"}, tokenMode)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+		var session auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&session))
+		require.NotEmpty(t, session.AccessToken)
+
+		w = send(http.MethodPost, "/auth/refresh", map[string]interface{}{"refreshToken": session.RefreshToken}, tokenMode)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&session))
+
+		// The access token works as a bearer token
+		bearer := http.Header{auth.AuthModeHeader: {"token"}, "Authorization": {"Bearer " + session.AccessToken}}
+		w = send(http.MethodGet, "/auth/sessions", nil, bearer)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/auth/sessions", nil, http.Header{"Authorization": {"Bearer invalid"}}).Code)
+
+		w = send(http.MethodDelete, "/auth/sessions", nil, bearer)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Result().Cookies(), "token mode never touches cookies")
+	})
+
+	t.Run("CSRF", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Csrf",
+			LastName:  "Test",
+			Email:     "csrf@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		// cookie returns the named cookie set by the response
+		cookie := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+			for _, c := range w.Result().Cookies() {
+				if c.Name == name {
+					return c
+				}
+			}
+			return nil
+		}
+		// attack sends a request the way another site makes the browser send
+		// it: with the cookies, but without the CSRF header it cannot read
+		attack := func(method, path, contentType, body string, cookies ...*http.Cookie) int {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("Origin", "https://evil.example.com")
+			req.Header.Set("Content-Type", contentType)
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+		credentials := map[string]interface{}{"email": "csrf@example.com", "password": "password123"}
+
+		// Login issues the token in a cookie scripts can read and in the body
+		login := postJSON(router, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, login.Code)
+		var session auth.AuthResponse
+		require.NoError(t, json.NewDecoder(login.Body).Decode(&session))
+		csrf := cookie(login, auth.CSRFCookieName)
+		require.NotNil(t, csrf)
+		assert.False(t, csrf.HttpOnly)
+		assert.NotEmpty(t, session.CSRFToken)
+		assert.Equal(t, session.CSRFToken, csrf.Value)
+		cookies := login.Result().Cookies()
+
+		// Cross-site form posts and requests without the header are refused
+		change := `{"currentPassword":"password123","newPassword":"hijacked-password"}`
+		assert.Equal(t, http.StatusForbidden, attack(http.MethodPost, "/auth/password/change", "text/plain", change, cookies...))
+		assert.Equal(t, http.StatusForbidden, attack(http.MethodPost, "/auth/password/change", "application/x-www-form-urlencoded", "currentPassword=password123", cookies...))
+		assert.Equal(t, http.StatusForbidden, attack(http.MethodDelete, "/auth/sessions", "application/json", "", cookies...))
+		assert.Equal(t, http.StatusForbidden, attack(http.MethodPost, "/auth/mfa/totp", "application/json", "", cookies...))
+
+		// A guessed header does not match, and neither does a header without
+		// the CSRF cookie
+		guessed := httptest.NewRequest(http.MethodDelete, "/auth/sessions", nil)
+		guessed.Header.Set(auth.CSRFHeader, "guessed")
+		for _, c := range cookies {
+			guessed.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, guessed)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"invalid csrf token"}`, w.Body.String())
+
+		tokenOnly := httptest.NewRequest(http.MethodDelete, "/auth/sessions", nil)
+		tokenOnly.Header.Set(auth.CSRFHeader, session.CSRFToken)
+		tokenOnly.AddCookie(cookie(login, "token"))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, tokenOnly)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// None of the attacks went through
+		require.Equal(t, http.StatusOK, postJSON(router, "/auth/login", credentials).Code)
+
+		// Safe methods need no header, so links keep working
+		assert.Equal(t, http.StatusOK, attack(http.MethodGet, "/auth/sessions", "", "", cookies...))
+
+		// Refresh issues a new token; the old one stops matching
+		refreshed := postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": session.RefreshToken})
+		require.Equal(t, http.StatusOK, refreshed.Code)
+		var next auth.AuthResponse
+		require.NoError(t, json.NewDecoder(refreshed.Body).Decode(&next))
+		require.NotNil(t, cookie(refreshed, auth.CSRFCookieName))
+		assert.Equal(t, next.CSRFToken, cookie(refreshed, auth.CSRFCookieName).Value)
+		assert.NotEqual(t, session.CSRFToken, next.CSRFToken)
+
+		stale := httptest.NewRequest(http.MethodDelete, "/auth/sessions/unknown", nil)
+		stale.Header.Set(auth.CSRFHeader, session.CSRFToken)
+		for _, c := range refreshed.Result().Cookies() {
+			stale.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, stale)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// The app repeating the token gets through
+		w = postJSON(router, "/auth/password/change", map[string]interface{}{"currentPassword": "wrong-password", "newPassword": "new-password-1"}, refreshed.Result().Cookies()...)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "past the CSRF check")
+
+		// Bearer tokens are not sent by browsers on their own and are exempt,
+		// even with the cookies of another session along
+		bearer := httptest.NewRequest(http.MethodDelete, "/auth/sessions/unknown", nil)
+		bearer.Header.Set("Authorization", "Bearer "+next.AccessToken)
+		bearer.AddCookie(cookie(login, "token"))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, bearer)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// Token mode gets no CSRF token
+		tokenMode := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"csrf@example.com","password":"password123"}`))
+		tokenMode.Header.Set("Content-Type", "application/json")
+		tokenMode.Header.Set(auth.AuthModeHeader, "token")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, tokenMode)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "csrfToken")
+
+		// The /api routes are covered too
+		apiRouter := gin.New()
+		apiRouter.POST("/api/students", auth.AuthMiddleware(keys, authService, authService, logger), func(c *gin.Context) {
+			c.Status(http.StatusCreated)
+		})
+		form := func(req *http.Request) int {
+			w := httptest.NewRecorder()
+			apiRouter.ServeHTTP(w, req)
+			return w.Code
+		}
+		forged := httptest.NewRequest(http.MethodPost, "/api/students", strings.NewReader("firstName=Evil"))
+		forged.Header.Set("Origin", "https://evil.example.com")
+		forged.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range refreshed.Result().Cookies() {
+			forged.AddCookie(c)
+		}
+		assert.Equal(t, http.StatusForbidden, form(forged))
+		legit := httptest.NewRequest(http.MethodPost, "/api/students", strings.NewReader("{}"))
+		addCookies(legit, refreshed.Result().Cookies()...)
+		assert.Equal(t, http.StatusCreated, form(legit))
+
+		// Logout clears the CSRF cookie along with the token cookie
+		w = postJSON(router, "/auth/logout", map[string]interface{}{"refreshToken": next.RefreshToken}, refreshed.Result().Cookies()...)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		cleared := cookie(w, auth.CSRFCookieName)
+		require.NotNil(t, cleared)
+		assert.Negative(t, cleared.MaxAge)
+	})
+
 	t.Run("Refresh_InvalidToken", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
 
@@ -402,4 +1088,877 @@ func TestAuthService_Shared(t *testing.T) {
 
 		assert.Equal(t, http.StatusUnauthorized, refreshW.Code, "refresh token should be invalid after logout")
 	})
+
+	t.Run("ChangePassword", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Change",
+			LastName:  "Password",
+			Email:     "change@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		credentials := map[string]interface{}{"email": "change@example.com", "password": "password123"}
+		first := postJSON(router, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, first.Code)
+		other := postJSON(router, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, other.Code)
+		var otherSession auth.AuthResponse
+		require.NoError(t, json.NewDecoder(other.Body).Decode(&otherSession))
+
+		// Not logged in
+		w := postJSON(router, "/auth/password/change", map[string]interface{}{"currentPassword": "password123", "newPassword": "new-password-1"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		cookies := first.Result().Cookies()
+		w = postJSON(router, "/auth/password/change", map[string]interface{}{"currentPassword": "wrong-password", "newPassword": "new-password-1"}, cookies...)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "current password is incorrect")
+
+		w = postJSON(router, "/auth/password/change", map[string]interface{}{"currentPassword": "password123", "newPassword": "new-password-1"}, cookies...)
+		require.Equal(t, http.StatusOK, w.Code)
+		var changed auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&changed))
+		assert.NotEmpty(t, changed.RefreshToken)
+
+		// Other sessions are revoked, the new one works
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": otherSession.RefreshToken}).Code)
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": changed.RefreshToken}).Code)
+		w = postJSON(router, "/auth/password/change", map[string]interface{}{"currentPassword": "new-password-1", "newPassword": "new-password-2"}, cookies...)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "the access token of the old session is revoked")
+
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/login", credentials).Code)
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/login", map[string]interface{}{"email": "change@example.com", "password": "new-password-1"}).Code)
+	})
+
+	t.Run("ForgotAndResetPassword", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Forgot",
+			LastName:  "Password",
+			Email:     "forgot@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		login := postJSON(router, "/auth/login", map[string]interface{}{"email": "forgot@example.com", "password": "password123"})
+		require.Equal(t, http.StatusOK, login.Code)
+		var session auth.AuthResponse
+		require.NoError(t, json.NewDecoder(login.Body).Decode(&session))
+
+		// Unknown emails get the same answer and no email
+		sent := len(mailer.Messages())
+		assert.Equal(t, http.StatusAccepted, postJSON(router, "/auth/password/forgot", map[string]interface{}{"email": "nobody@example.com"}).Code)
+		assert.Len(t, mailer.Messages(), sent)
+
+		// Only the latest link works
+		require.Equal(t, http.StatusAccepted, postJSON(router, "/auth/password/forgot", map[string]interface{}{"email": "forgot@example.com"}).Code)
+		oldToken := mailedToken(t, mailer, "forgot@example.com", "/reset-password")
+		require.Equal(t, http.StatusAccepted, postJSON(router, "/auth/password/forgot", map[string]interface{}{"email": "forgot@example.com"}).Code)
+		token := mailedToken(t, mailer, "forgot@example.com", "/reset-password")
+		assert.NotEqual(t, oldToken, token)
+
+		// The database only holds a hash of the token
+		count, err := pgContainer.DB.NewSelect().Model((*auth.AccountToken)(nil)).Where("token_hash = ?", token).Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		w := postJSON(router, "/auth/password/reset", map[string]interface{}{"token": oldToken, "newPassword": "reset-password-1"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = postJSON(router, "/auth/password/reset", map[string]interface{}{"token": token, "newPassword": "short"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = postJSON(router, "/auth/password/reset", map[string]interface{}{"token": token, "newPassword": "reset-password-1"})
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		// Single use
+		w = postJSON(router, "/auth/password/reset", map[string]interface{}{"token": token, "newPassword": "reset-password-2"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid or expired reset token")
+
+		// Existing sessions are revoked
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": session.RefreshToken}).Code)
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/login", map[string]interface{}{"email": "forgot@example.com", "password": "reset-password-1"}).Code)
+	})
+
+	t.Run("PasswordHashing", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens", "login_attempts")
+
+		ctx := context.Background()
+		legacyHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		legacy, err := studentRepo.Create(ctx, &student.Student{
+			FirstName: "Legacy",
+			LastName:  "Hash",
+			Email:     "legacy@example.com",
+			Password:  string(legacyHash),
+		})
+		require.NoError(t, err)
+
+		// A bcrypt hash still verifies and is replaced with Argon2id
+		credentials := map[string]interface{}{"email": "legacy@example.com", "password": "password123"}
+		require.Equal(t, http.StatusOK, postJSON(router, "/auth/login", credentials).Code)
+		upgraded, err := studentRepo.GetByID(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(upgraded.Password, "$argon2id$v=19$"), upgraded.Password)
+		require.Equal(t, http.StatusOK, postJSON(router, "/auth/login", credentials).Code)
+		again, err := studentRepo.GetByID(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, upgraded.Password, again.Password)
+
+		// So does an Argon2id hash with outdated parameters
+		cheap, err := password.NewArgon2id(password.Params{Memory: 64, Iterations: 1, Parallelism: 1})
+		require.NoError(t, err)
+		policyService := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			AppURL:         "https://app.example.com",
+			PasswordHasher: cheap,
+			PasswordPolicy: password.Policy{MinLength: 10, DenyList: map[string]struct{}{"password123!": {}}},
+		}, logger)
+		policyRouter := gin.New()
+		auth.NewHandler(policyService, logger).RegisterRoutes(policyRouter)
+		require.Equal(t, http.StatusOK, postJSON(policyRouter, "/auth/login", credentials).Code)
+		downgraded, err := studentRepo.GetByID(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(downgraded.Password, "$argon2id$v=19$m=64,t=1,p=1$"), downgraded.Password)
+
+		// New passwords have to meet the policy
+		register := func(newPassword string) *httptest.ResponseRecorder {
+			return postJSON(policyRouter, "/auth/register", map[string]interface{}{
+				"firstName": "Policy", "lastName": "Student", "email": "policy@example.com", "password": newPassword,
+			})
+		}
+		for _, weak := range []string{"short-1", "onlylowercase", "Password123!"} {
+			w := register(weak)
+			assert.Equal(t, http.StatusBadRequest, w.Code, weak)
+			assert.Contains(t, w.Body.String(), "password does not meet the policy")
+		}
+		assert.Equal(t, http.StatusCreated, register("correct-horse-42").Code)
+
+		login := postJSON(policyRouter, "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, login.Code)
+		w := postJSON(policyRouter, "/auth/password/change", map[string]interface{}{
+			"currentPassword": "password123", "newPassword": "too-short",
+		}, login.Result().Cookies()...)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// A refused password does not use up the reset link
+		require.Equal(t, http.StatusAccepted, postJSON(policyRouter, "/auth/password/forgot", map[string]interface{}{"email": "legacy@example.com"}).Code)
+		token := mailedToken(t, mailer, "legacy@example.com", "/reset-password")
+		w = postJSON(policyRouter, "/auth/password/reset", map[string]interface{}{"token": token, "newPassword": "password123!"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = postJSON(policyRouter, "/auth/password/reset", map[string]interface{}{"token": token, "newPassword": "a-much-better-one"})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens")
+
+		restrictService := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			AppURL:          "https://app.example.com",
+			UnverifiedLogin: auth.UnverifiedRestrict,
+		}, logger)
+		restrictRouter := gin.New()
+		auth.NewHandler(restrictService, logger).RegisterRoutes(restrictRouter)
+
+		w := postJSON(restrictRouter, "/auth/register", map[string]interface{}{
+			"firstName": "Verify",
+			"lastName":  "Email",
+			"email":     "verify@example.com",
+			"password":  "password123",
+		})
+		require.Equal(t, http.StatusCreated, w.Code)
+		var registered auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&registered))
+		claims, err := keys.ValidateAccessToken(registered.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, claims.Restricted)
+
+		// Resending invalidates the first link
+		oldToken := mailedToken(t, mailer, "verify@example.com", "/verify-email")
+		require.Equal(t, http.StatusAccepted, postJSON(restrictRouter, "/auth/verify/resend", map[string]interface{}{"email": "verify@example.com"}).Code)
+		token := mailedToken(t, mailer, "verify@example.com", "/verify-email")
+		assert.Equal(t, http.StatusBadRequest, postJSON(restrictRouter, "/auth/verify", map[string]interface{}{"token": oldToken}).Code)
+
+		require.Equal(t, http.StatusNoContent, postJSON(restrictRouter, "/auth/verify", map[string]interface{}{"token": token}).Code)
+		assert.Equal(t, http.StatusBadRequest, postJSON(restrictRouter, "/auth/verify", map[string]interface{}{"token": token}).Code)
+
+		// The next refresh drops the restriction
+		w = postJSON(restrictRouter, "/auth/refresh", map[string]interface{}{"refreshToken": registered.RefreshToken})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, accessClaims(t, keys, w).Restricted)
+
+		// Verified students get no more emails
+		sent := len(mailer.Messages())
+		assert.Equal(t, http.StatusAccepted, postJSON(restrictRouter, "/auth/verify/resend", map[string]interface{}{"email": "verify@example.com"}).Code)
+		assert.Len(t, mailer.Messages(), sent)
+
+		// A link sent before an email change does not verify the new address
+		ctx := context.Background()
+		stud, err := studentRepo.GetByEmail(ctx, "verify@example.com")
+		require.NoError(t, err)
+		stud.VerifiedAt = nil
+		stud.Version = 0
+		require.NoError(t, studentRepo.Update(ctx, stud, "verified_at"))
+		require.Equal(t, http.StatusAccepted, postJSON(restrictRouter, "/auth/verify/resend", map[string]interface{}{"email": "verify@example.com"}).Code)
+		staleToken := mailedToken(t, mailer, "verify@example.com", "/verify-email")
+		stud.Email = "verify.changed@example.com"
+		require.NoError(t, studentRepo.Update(ctx, stud, "email"))
+		assert.Equal(t, http.StatusBadRequest, postJSON(restrictRouter, "/auth/verify", map[string]interface{}{"token": staleToken}).Code)
+	})
+
+	t.Run("Invitation", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens")
+
+		ctx := context.Background()
+		invitedAt := time.Now()
+		stud, err := studentRepo.Create(ctx, &student.Student{
+			FirstName: "Invited",
+			LastName:  "Student",
+			Email:     "invited@example.com",
+			InvitedAt: &invitedAt,
+		})
+		require.NoError(t, err)
+
+		// Invited students have no password to log in with
+		credentials := map[string]interface{}{"email": "invited@example.com", "password": "DefaultPassword123!"}
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/login", credentials).Code)
+
+		// Resending invalidates the first link, and so does asking for a reset
+		require.NoError(t, authService.InviteStudent(ctx, stud.ID))
+		oldToken := mailedToken(t, mailer, "invited@example.com", "/accept-invitation")
+		require.Equal(t, http.StatusAccepted, postJSON(router, "/auth/password/forgot", map[string]interface{}{"email": "invited@example.com"}).Code)
+		token := mailedToken(t, mailer, "invited@example.com", "/accept-invitation")
+		assert.NotEqual(t, oldToken, token)
+		w := postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": oldToken, "password": "invited-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Expired links do not work
+		require.NoError(t, authService.InviteStudent(ctx, stud.ID))
+		expiredToken := mailedToken(t, mailer, "invited@example.com", "/accept-invitation")
+		_, err = pgContainer.DB.NewUpdate().Model((*auth.AccountToken)(nil)).
+			Set("expires_at = ?", time.Now().Add(-time.Minute)).
+			Where("purpose = ?", auth.PurposeInvitation).
+			Exec(ctx)
+		require.NoError(t, err)
+		w = postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": expiredToken, "password": "invited-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		require.NoError(t, authService.InviteStudent(ctx, stud.ID))
+		token = mailedToken(t, mailer, "invited@example.com", "/accept-invitation")
+		w = postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": token, "password": "short"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Accepting sets the password, verifies the email and logs in
+		w = postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": token, "password": "invited-password"})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, stud.ID, accessClaims(t, keys, w).StudentID)
+		accepted, err := studentRepo.GetByID(ctx, stud.ID)
+		require.NoError(t, err)
+		assert.False(t, accepted.Invited())
+		assert.NotNil(t, accepted.VerifiedAt)
+
+		// Single use, and there is nothing left to resend
+		w = postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": token, "password": "another-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.ErrorIs(t, authService.InviteStudent(ctx, stud.ID), student.ErrNotInvited)
+
+		credentials["password"] = "invited-password"
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/login", credentials).Code)
+	})
+
+	t.Run("UnverifiedLoginPolicy", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Unverified",
+			LastName:  "Student",
+			Email:     "unverified@example.com",
+			Password:  string(hashedPassword),
+		}).Exec(ctx)
+		require.NoError(t, err)
+		credentials := map[string]interface{}{"email": "unverified@example.com", "password": "password123"}
+
+		routerFor := func(policy auth.UnverifiedPolicy) *gin.Engine {
+			service := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+				AppURL:          "https://app.example.com",
+				UnverifiedLogin: policy,
+			}, logger)
+			r := gin.New()
+			auth.NewHandler(service, logger).RegisterRoutes(r)
+			return r
+		}
+
+		w := postJSON(routerFor(auth.UnverifiedAllow), "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, accessClaims(t, keys, w).Restricted)
+
+		w = postJSON(routerFor(auth.UnverifiedRestrict), "/auth/login", credentials)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, accessClaims(t, keys, w).Restricted)
+
+		blockRouter := routerFor(auth.UnverifiedBlock)
+		w = postJSON(blockRouter, "/auth/login", credentials)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "email address is not verified")
+
+		w = postJSON(blockRouter, "/auth/register", map[string]interface{}{
+			"firstName": "Blocked",
+			"lastName":  "Student",
+			"email":     "blocked@example.com",
+			"password":  "password123",
+		})
+		require.Equal(t, http.StatusCreated, w.Code)
+		var registered auth.AuthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&registered))
+		assert.Empty(t, registered.AccessToken)
+		assert.Empty(t, registered.RefreshToken)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("OIDCLogin", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens", "federated_identities")
+
+		provider := oidctest.New(t, "student-service")
+		routerFor := func(provision bool) *gin.Engine {
+			service := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+				AppURL: "https://app.example.com",
+				OIDC: auth.OIDCConfig{
+					Issuer:      provider.URL,
+					ClientID:    "student-service",
+					RedirectURL: "https://app.example.com/auth/oidc/callback",
+					Provision:   provision,
+				},
+			}, logger)
+			r := gin.New()
+			auth.NewHandler(service, logger).RegisterRoutes(r)
+			return r
+		}
+		linkOnly, provisioning := routerFor(false), routerFor(true)
+
+		// Without an issuer the endpoints do not exist
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// An existing student is linked by verified email
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		verifiedAt := time.Now()
+		existing, err := studentRepo.Create(ctx, &student.Student{
+			FirstName:  "Linked",
+			LastName:   "Student",
+			Email:      "linked@example.com",
+			Password:   string(hashedPassword),
+			VerifiedAt: &verifiedAt,
+		})
+		require.NoError(t, err)
+
+		// The email is matched regardless of case
+		provider.SetUser(oidctest.User{Subject: "sub-linked", Email: "Linked@Example.com", EmailVerified: true})
+		w = oidcLogin(t, provisioning, provider)
+		fragment := oidcFragment(t, w)
+		assert.NotEmpty(t, fragment.Get("refreshToken"))
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": fragment.Get("refreshToken")}).Code)
+		assert.NotContains(t, w.Body.String(), fragment.Get("refreshToken"), "tokens only travel in the fragment")
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Equal(t, existing.ID, cookieClaims(t, keys, w).StudentID)
+
+		// Later logins follow the link, even after the email changed at the
+		// provider
+		provider.SetUser(oidctest.User{Subject: "sub-linked", Email: "renamed@example.com", EmailVerified: true})
+		w = oidcLogin(t, linkOnly, provider)
+		oidcFragment(t, w)
+		assert.Equal(t, existing.ID, cookieClaims(t, keys, w).StudentID)
+
+		var count int
+		count, err = pgContainer.DB.NewSelect().Model((*student.Student)(nil)).Where("lower(email) = ?", "linked@example.com").Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count, "no second account for the same email")
+
+		// The password keeps working for a verified account, typed in any case
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/login", map[string]interface{}{"email": "linked@example.com", "password": "password123"}).Code)
+		assert.Equal(t, http.StatusOK, postJSON(router, "/auth/login", map[string]interface{}{"email": "LINKED@example.com", "password": "password123"}).Code)
+
+		// Unverified emails never match an account
+		provider.SetUser(oidctest.User{Subject: "sub-unverified", Email: "linked@example.com", EmailVerified: false})
+		w = oidcLogin(t, provisioning, provider)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// Unknown emails only get an account when provisioning is on
+		newcomer := oidctest.User{Subject: "sub-new", Email: "newcomer@example.com", EmailVerified: true, GivenName: "Nova", FamilyName: "Studentka"}
+		provider.SetUser(newcomer)
+		w = oidcLogin(t, linkOnly, provider)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		provider.SetUser(newcomer)
+		w = oidcLogin(t, provisioning, provider)
+		provisioned := oidcFragment(t, w)
+		assert.NotEmpty(t, provisioned.Get("refreshToken"))
+		cookies := map[string]string{}
+		for _, cookie := range w.Result().Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		assert.Contains(t, cookies, "token")
+		assert.Equal(t, cookies[auth.CSRFCookieName], provisioned.Get("csrfToken"))
+		created, err := studentRepo.GetByEmail(ctx, "newcomer@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "Nova", created.FirstName)
+		assert.Equal(t, "Studentka", created.LastName)
+		assert.NotNil(t, created.VerifiedAt)
+		assert.Equal(t, rbac.RoleStudent, created.Role)
+
+		// Taking over an unverified account drops its password, sessions and
+		// the links sent to it
+		squatter := postJSON(router, "/auth/register", map[string]interface{}{
+			"firstName": "Squatter",
+			"lastName":  "Student",
+			"email":     "owner@example.com",
+			"password":  "password123",
+		})
+		require.Equal(t, http.StatusCreated, squatter.Code)
+		var squatterSession auth.AuthResponse
+		require.NoError(t, json.NewDecoder(squatter.Body).Decode(&squatterSession))
+		require.Equal(t, http.StatusAccepted, postJSON(router, "/auth/password/forgot", map[string]interface{}{"email": "owner@example.com"}).Code)
+		resetToken := mailedToken(t, mailer, "owner@example.com", "/reset-password")
+
+		provider.SetUser(oidctest.User{Subject: "sub-owner", Email: "owner@example.com", EmailVerified: true})
+		w = oidcLogin(t, linkOnly, provider)
+		oidcFragment(t, w)
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": squatterSession.RefreshToken}).Code)
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/login", map[string]interface{}{"email": "owner@example.com", "password": "password123"}).Code)
+		assert.Equal(t, http.StatusBadRequest, postJSON(router, "/auth/password/reset", map[string]interface{}{"token": resetToken, "newPassword": "squatter-password"}).Code)
+
+		// A pending invitation is dropped too
+		invitedAt := time.Now()
+		invited, err := studentRepo.Create(ctx, &student.Student{FirstName: "Invited", LastName: "Owner", Email: "invited.owner@example.com", InvitedAt: &invitedAt})
+		require.NoError(t, err)
+		require.NoError(t, authService.InviteStudent(ctx, invited.ID))
+		invitation := mailedToken(t, mailer, "invited.owner@example.com", "/accept-invitation")
+
+		provider.SetUser(oidctest.User{Subject: "sub-invited", Email: "invited.owner@example.com", EmailVerified: true})
+		oidcFragment(t, oidcLogin(t, linkOnly, provider))
+		assert.Equal(t, http.StatusBadRequest, postJSON(router, "/auth/invitation/accept", map[string]interface{}{"token": invitation, "password": "squatter-password"}).Code)
+		invited, err = studentRepo.GetByID(ctx, invited.ID)
+		require.NoError(t, err)
+		assert.False(t, invited.Invited())
+
+		// The callback needs the state cookie of the browser that started
+		login := httptest.NewRecorder()
+		linkOnly.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		require.Equal(t, http.StatusFound, login.Code)
+		callback := provider.Authorize(t, login.Header().Get("Location"))
+		w = httptest.NewRecorder()
+		linkOnly.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+callback.Encode(), nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// A login declined at the provider
+		login = httptest.NewRecorder()
+		linkOnly.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		location, err := url.Parse(login.Header().Get("Location"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?error=access_denied&state="+location.Query().Get("state"), nil)
+		for _, cookie := range login.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		linkOnly.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "api_keys")
+
+		// send makes a request with a bearer token to the auth routes, or to
+		// an /api route that needs students:read
+		apiRouter := gin.New()
+		apiRouter.GET("/api/students", auth.AuthMiddleware(keys, authService, authService, logger), rbac.RequirePermission(rbac.StudentsRead), func(c *gin.Context) {
+			p, _ := rbac.GetPrincipal(c.Request.Context())
+			c.JSON(http.StatusOK, gin.H{"apiKey": p.IsAPIKey()})
+		})
+		apiRouter.POST("/api/students", auth.AuthMiddleware(keys, authService, authService, logger), rbac.RequirePermission(rbac.StudentsWrite), func(c *gin.Context) {
+			c.Status(http.StatusCreated)
+		})
+		send := func(r http.Handler, method, path, bearer string, payload interface{}) *httptest.ResponseRecorder {
+			var body []byte
+			if payload != nil {
+				body, _ = json.Marshal(payload)
+			}
+			req := httptest.NewRequest(method, path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+		admin, err := keys.GenerateAccessToken(auth.Claims{StudentID: 999, Role: rbac.RoleAdmin})
+		require.NoError(t, err)
+		staff, err := keys.GenerateAccessToken(auth.Claims{StudentID: 998, Role: rbac.RoleStaff})
+		require.NoError(t, err)
+
+		request := map[string]interface{}{
+			"name":      "reporting",
+			"scopes":    []string{"students:read", "messages:write"},
+			"expiresAt": time.Now().Add(24 * time.Hour),
+		}
+		assert.Equal(t, http.StatusForbidden, send(router, http.MethodPost, "/auth/api-keys", staff, request).Code)
+
+		w := send(router, http.MethodPost, "/auth/api-keys", admin, request)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var created auth.CreatedAPIKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.True(t, strings.HasPrefix(created.Key, created.APIKey.Prefix))
+		assert.Equal(t, []rbac.Permission{rbac.StudentsRead, rbac.MessagesWrite}, created.APIKey.Scopes)
+		require.NotNil(t, created.APIKey.CreatedBy)
+		assert.Equal(t, 999, *created.APIKey.CreatedBy)
+
+		// Only a hash is stored
+		count, err := pgContainer.DB.NewSelect().Model((*auth.APIKey)(nil)).Where("key_hash = ?", created.Key).Count(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		// The key holds its scopes and nothing more
+		w = send(apiRouter, http.MethodGet, "/api/students", created.Key, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"apiKey": true}`, w.Body.String())
+		assert.Equal(t, http.StatusForbidden, send(apiRouter, http.MethodPost, "/api/students", created.Key, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, send(apiRouter, http.MethodGet, "/api/students", created.Key+"x", nil).Code)
+
+		// Keys cannot manage accounts, sessions or other keys
+		assert.Equal(t, http.StatusUnauthorized, send(router, http.MethodGet, "/auth/api-keys", created.Key, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, send(router, http.MethodGet, "/auth/sessions", created.Key, nil).Code)
+
+		w = send(router, http.MethodGet, "/auth/api-keys", admin, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), created.Key)
+		var listed []auth.APIKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		require.Len(t, listed, 1)
+		assert.NotNil(t, listed[0].LastUsedAt)
+
+		// Revoked keys stop working at once
+		id := strconv.FormatInt(created.APIKey.ID, 10)
+		assert.Equal(t, http.StatusNoContent, send(router, http.MethodDelete, "/auth/api-keys/"+id, admin, nil).Code)
+		assert.Equal(t, http.StatusNotFound, send(router, http.MethodDelete, "/auth/api-keys/"+id, admin, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, send(apiRouter, http.MethodGet, "/api/students", created.Key, nil).Code)
+
+		// So do expired ones
+		w = send(router, http.MethodPost, "/auth/api-keys", admin, request)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		_, err = pgContainer.DB.NewUpdate().Model((*auth.APIKey)(nil)).
+			Set("expires_at = ?", time.Now().Add(-time.Minute)).
+			Where("id = ?", created.APIKey.ID).
+			Exec(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, send(apiRouter, http.MethodGet, "/api/students", created.Key, nil).Code)
+
+		// Unknown scopes and past expiry are refused
+		request["scopes"] = []string{"students:fly"}
+		assert.Equal(t, http.StatusBadRequest, send(router, http.MethodPost, "/auth/api-keys", admin, request).Code)
+		request["scopes"] = []string{"students:read"}
+		request["expiresAt"] = time.Now().Add(-time.Hour)
+		assert.Equal(t, http.StatusBadRequest, send(router, http.MethodPost, "/auth/api-keys", admin, request).Code)
+	})
+
+	t.Run("Revocation", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "revoked_tokens")
+
+		ctx := context.Background()
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		revoked := &student.Student{
+			FirstName: "Revoked",
+			LastName:  "Test",
+			Email:     "revoked@example.com",
+			Password:  string(hashedPassword),
+		}
+		_, err := pgContainer.DB.NewInsert().Model(revoked).Exec(ctx)
+		require.NoError(t, err)
+		admin, err := keys.GenerateAccessToken(auth.Claims{StudentID: 999, Role: rbac.RoleAdmin})
+		require.NoError(t, err)
+		staff, err := keys.GenerateAccessToken(auth.Claims{StudentID: 998, Role: rbac.RoleStaff})
+		require.NoError(t, err)
+
+		credentials := map[string]interface{}{"email": "revoked@example.com", "password": "password123"}
+		login := func() (*httptest.ResponseRecorder, auth.AuthResponse) {
+			w := postJSON(router, "/auth/login", credentials)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var resp auth.AuthResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			return w, resp
+		}
+		// authorized reports whether the cookies still authenticate
+		authorized := func(w *httptest.ResponseRecorder) bool {
+			req := httptest.NewRequest(http.MethodGet, "/auth/sessions", nil)
+			for _, cookie := range w.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			return res.Code == http.StatusOK
+		}
+		asAdmin := func(bearer, method, path string) int {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+		id := strconv.Itoa(revoked.ID)
+
+		// Logout revokes the access token in the cookie
+		first, firstTokens := login()
+		second, _ := login()
+		require.True(t, authorized(first))
+		w := postJSON(router, "/auth/logout", map[string]interface{}{"refreshToken": firstTokens.RefreshToken}, first.Result().Cookies()...)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		assert.False(t, authorized(first))
+		assert.True(t, authorized(second))
+
+		// An access token presented with another session's refresh token is
+		// revoked on its own
+		third, thirdTokens := login()
+		w = postJSON(router, "/auth/logout", map[string]interface{}{"refreshToken": "unknown"}, third.Result().Cookies()...)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		assert.False(t, authorized(third))
+		w = postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": thirdTokens.RefreshToken})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&thirdTokens))
+
+		// Admins can sign a student out everywhere
+		assert.Equal(t, http.StatusForbidden, asAdmin(staff, http.MethodPost, "/auth/accounts/"+id+"/logout"))
+		assert.Equal(t, http.StatusNotFound, asAdmin(admin, http.MethodPost, "/auth/accounts/123456/logout"))
+		assert.Equal(t, http.StatusNoContent, asAdmin(admin, http.MethodPost, "/auth/accounts/"+id+"/logout"))
+		assert.False(t, authorized(second))
+		assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/auth/refresh", map[string]interface{}{"refreshToken": thirdTokens.RefreshToken}).Code)
+		again, _ := login()
+		assert.True(t, authorized(again))
+
+		// A suspended student is signed out and cannot log in
+		assert.Equal(t, http.StatusForbidden, asAdmin(staff, http.MethodPost, "/auth/accounts/"+id+"/suspend"))
+		assert.Equal(t, http.StatusNoContent, asAdmin(admin, http.MethodPost, "/auth/accounts/"+id+"/suspend"))
+		assert.Equal(t, http.StatusNoContent, asAdmin(admin, http.MethodPost, "/auth/accounts/"+id+"/suspend"))
+		assert.False(t, authorized(again))
+		w = postJSON(router, "/auth/login", credentials)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "account is suspended")
+		stored, err := studentRepo.GetByID(ctx, revoked.ID)
+		require.NoError(t, err)
+		assert.True(t, stored.Suspended())
+
+		// Admins cannot lock themselves out
+		self, err := keys.GenerateAccessToken(auth.Claims{StudentID: revoked.ID, Role: rbac.RoleAdmin})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, asAdmin(self, http.MethodPost, "/auth/accounts/"+id+"/suspend"))
+
+		assert.Equal(t, http.StatusNoContent, asAdmin(admin, http.MethodDelete, "/auth/accounts/"+id+"/suspend"))
+		afterSuspension, afterSuspensionTokens := login()
+		assert.True(t, authorized(afterSuspension))
+
+		// Every revocation is stored with its reason
+		var reasons []string
+		require.NoError(t, pgContainer.DB.NewSelect().Model((*auth.Revocation)(nil)).Column("reason").Scan(ctx, &reasons))
+		assert.Subset(t, reasons, []string{auth.ReasonLogout, auth.ReasonForceLogout, auth.ReasonSuspended})
+
+		// Another instance picks the revocations up from the database
+		otherInstance := auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+			Revocation: auth.RevocationConfig{ResyncInterval: 50 * time.Millisecond},
+		}, logger)
+		syncCtx, stopSync := context.WithCancel(ctx)
+		defer stopSync()
+		go otherInstance.SyncRevocations(syncCtx)
+
+		claims, err := keys.ValidateAccessToken(afterSuspensionTokens.AccessToken)
+		require.NoError(t, err)
+		require.False(t, otherInstance.IsRevoked(claims))
+		require.NoError(t, authService.LogoutAll(ctx, revoked.ID))
+		assert.Eventually(t, func() bool { return otherInstance.IsRevoked(claims) }, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("RevocationOverNATS", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "revoked_tokens")
+
+		natsContainer := testnats.SetupSharedNATS(t)
+		defer natsContainer.Cleanup(t)
+
+		// Neither instance resyncs during the test, so only NATS can tell
+		// the second one
+		newInstance := func() *auth.Service {
+			producer, err := messaging.NewProducer(natsContainer.URL, "student.messages", logger)
+			require.NoError(t, err)
+			t.Cleanup(func() { producer.Close() })
+			return auth.NewService(authRepo, studentRepo, mailer, keys, auth.Config{
+				Revocation: auth.RevocationConfig{
+					Bus:            producer,
+					Subject:        "test.revocations",
+					ResyncInterval: time.Hour,
+				},
+			}, logger)
+		}
+		first, second := newInstance(), newInstance()
+
+		// A revocation only in the database shows that the second instance
+		// has subscribed and done its initial resync
+		ctx := context.Background()
+		require.NoError(t, authRepo.CreateRevocation(ctx, &auth.Revocation{
+			Kind:      auth.RevokeSession,
+			Subject:   "probe",
+			Reason:    auth.ReasonSessionRevoked,
+			RevokedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		}))
+		syncCtx, stopSync := context.WithCancel(ctx)
+		defer stopSync()
+		go second.SyncRevocations(syncCtx)
+		require.Eventually(t, func() bool {
+			return second.IsRevoked(&auth.Claims{SessionID: "probe"})
+		}, 5*time.Second, 20*time.Millisecond)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		stud := &student.Student{FirstName: "Nats", LastName: "Test", Email: "nats.revocation@example.com", Password: string(hashedPassword)}
+		_, err := pgContainer.DB.NewInsert().Model(stud).Exec(ctx)
+		require.NoError(t, err)
+		resp, _, err := first.Login(ctx, auth.LoginRequest{Email: stud.Email, Password: "password123"}, auth.ClientInfo{})
+		require.NoError(t, err)
+		claims, err := keys.ValidateAccessToken(resp.AccessToken)
+		require.NoError(t, err)
+
+		require.NoError(t, first.RevokeSession(ctx, stud.ID, claims.SessionID))
+		assert.Eventually(t, func() bool { return second.IsRevoked(claims) }, 5*time.Second, 20*time.Millisecond)
+		assert.True(t, first.IsRevoked(claims))
+	})
+}
+
+// recordingHasher records the hashes it verifies passwords against
+type recordingHasher struct {
+	password.Hasher
+	verified []string
+}
+
+func (h *recordingHasher) Verify(plain, hash string) (bool, bool, error) {
+	h.verified = append(h.verified, hash)
+	return h.Hasher.Verify(plain, hash)
+}
+
+// failingLookups is a student repository whose email lookups fail
+type failingLookups struct {
+	student.Repository
+}
+
+func (failingLookups) GetByEmail(context.Context, string) (*student.Student, error) {
+	return nil, errors.New("connection refused")
+}
+
+// oidcLogin runs a provider login through the router and returns the
+// response of the callback
+func oidcLogin(t *testing.T, router http.Handler, provider *oidctest.Provider) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	require.Equal(t, http.StatusFound, login.Code, login.Body.String())
+
+	callback := provider.Authorize(t, login.Header().Get("Location"))
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+callback.Encode(), nil)
+	for _, cookie := range login.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// postJSON sends a JSON POST request to the router
+func postJSON(router http.Handler, path string, payload interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	addCookies(req, cookies...)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// addCookies adds the cookies to the request and, like the web app, repeats
+// the CSRF cookie in the CSRF header
+func addCookies(req *http.Request, cookies ...*http.Cookie) {
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+		if cookie.Name == auth.CSRFCookieName {
+			req.Header.Set(auth.CSRFHeader, cookie.Value)
+		}
+	}
+}
+
+// useRecoveryLogin logs in with a password and a recovery code
+func useRecoveryLogin(t *testing.T, router http.Handler, credentials map[string]interface{}, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := postJSON(router, "/auth/login", credentials)
+	require.Equal(t, http.StatusOK, w.Code)
+	var challenge auth.MFAChallenge
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&challenge))
+	return postJSON(router, "/auth/login/mfa", map[string]interface{}{"mfaToken": challenge.MFAToken, "recoveryCode": code})
+}
+
+// mailedToken extracts the token from the link to path in the last email
+// sent to the address
+func mailedToken(t *testing.T, mailer *mail.MemoryMailer, to, path string) string {
+	t.Helper()
+	msg, ok := mailer.Last(to)
+	require.True(t, ok, "email to %s should be sent", to)
+
+	for _, field := range strings.Fields(msg.Body) {
+		if strings.HasPrefix(field, "https://app.example.com"+path+"?") {
+			link, err := url.Parse(field)
+			require.NoError(t, err)
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no %s link in %q", path, msg.Body)
+	return ""
+}
+
+// accessClaims parses the access token of a login or refresh response
+// oidcFragment checks that a provider login sent the browser back to the app
+// and returns the values in the fragment
+func oidcFragment(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/", location.Scheme+"://"+location.Host+location.Path)
+	assert.Empty(t, location.RawQuery)
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.NoError(t, err)
+	return fragment
+}
+
+// cookieClaims returns the claims of the access token in the token cookie
+func cookieClaims(t *testing.T, keys *auth.KeySet, w *httptest.ResponseRecorder) *auth.Claims {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "token" {
+			claims, err := keys.ValidateAccessToken(cookie.Value)
+			require.NoError(t, err)
+			return claims
+		}
+	}
+	require.FailNow(t, "no token cookie")
+	return nil
+}
+
+func accessClaims(t *testing.T, keys *auth.KeySet, w *httptest.ResponseRecorder) *auth.Claims {
+	t.Helper()
+	var resp auth.AuthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	claims, err := keys.ValidateAccessToken(resp.AccessToken)
+	require.NoError(t, err)
+	return claims
 }
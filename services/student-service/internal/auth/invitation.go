@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"student-service/internal/mail"
+	"student-service/internal/student"
+)
+
+var ErrInvalidInvitation = errors.New("invalid, expired or already accepted invitation")
+
+const defaultInvitationTTL = 7 * 24 * time.Hour
+
+// InviteStudent emails an invited student a link to choose their password.
+// Links sent earlier stop working, so it doubles as resending the invitation.
+// It returns student.ErrNotInvited once the invitation was accepted.
+func (s *Service) InviteStudent(ctx context.Context, studentID int) error {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	if !stud.Invited() {
+		return student.ErrNotInvited
+	}
+
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := s.authRepo.CreateAccountToken(ctx, &AccountToken{
+		StudentID: stud.ID,
+		Purpose:   PurposeInvitation,
+		TokenHash: hashAccountToken(token),
+		Email:     stud.Email,
+		ExpiresAt: time.Now().Add(s.invitationTTL()),
+	}); err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      stud.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"an account has been created for you. To choose your password and sign in open\n\n"+
+			"%s\n\n"+
+			"The link is valid for %s and can be used once. If it expires, ask for a new one.\n",
+			stud.FirstName, s.appLink("/accept-invitation", token), s.invitationTTL()),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send invitation email: %w", err)
+	}
+
+	actor, _ := GetStudentID(ctx)
+	s.logger.InfoContext(ctx, "invitation sent", "student_id", stud.ID, "actor_id", actor)
+	return nil
+}
+
+// AcceptInvitation sets the first password of an invited student and signs
+// them in. Following the link proves the email address, so the student is
+// verified too.
+func (s *Service) AcceptInvitation(ctx context.Context, req AcceptInvitationRequest, client ClientInfo) (*AuthResponse, error) {
+	// A refused password leaves the link usable
+	if err := s.config.PasswordPolicy.Check(req.Password); err != nil {
+		return nil, err
+	}
+	token, err := s.authRepo.ConsumeAccountToken(ctx, PurposeInvitation, hashAccountToken(req.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stud, err := s.studentRepo.GetByID(ctx, token.StudentID)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	// An invitation sent before an email change does not reach the new address
+	if !stud.Invited() || !strings.EqualFold(stud.Email, token.Email) {
+		return nil, ErrInvalidInvitation
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stud.Password = hashedPassword
+	stud.InvitedAt = nil
+	stud.VerifiedAt = &now
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "password", "invited_at", "verified_at"); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "invitation accepted", "student_id", stud.ID)
+	return s.generateTokenPair(ctx, stud, client)
+}
+
+func (s *Service) invitationTTL() time.Duration {
+	if s.config.InvitationTTL <= 0 {
+		return defaultInvitationTTL
+	}
+	return s.config.InvitationTTL
+}
@@ -1,13 +1,17 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"os"
 	"time"
 
+	"student-service/internal/rbac"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -19,30 +23,77 @@ var (
 
 // Claims represents JWT claims
 type Claims struct {
-	StudentID int    `json:"student_id"`
-	Email     string `json:"email"`
+	StudentID int       `json:"student_id"`
+	Email     string    `json:"email"`
+	Role      rbac.Role `json:"role"`
+	// Restricted marks sessions of students with an unverified email, or
+	// who have yet to enroll in the 2FA their role requires
+	Restricted bool `json:"restricted,omitempty"`
+	// SessionID is the refresh token family the access token was issued for
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateAccessToken creates a new JWT access token (15 minutes)
-func GenerateAccessToken(studentID int, email string) (string, error) {
-	secret, err := getJWTSecret()
+// accessTokenTTL is how long an access token is valid, and so how long a
+// revocation has to be remembered
+const accessTokenTTL = 15 * time.Minute
+
+// GenerateAccessToken creates a new JWT access token (15 minutes) carrying
+// the given claims, signed with the signing key of the set. The registered
+// claims are filled in here; the random "jti" lets the token be revoked.
+func (ks *KeySet) GenerateAccessToken(claims Claims) (string, error) {
+	tokenID, err := newRandomID()
 	if err != nil {
 		return "", err
 	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "student-service",
+	}
+
+	return ks.sign(claims)
+}
 
-	claims := Claims{
-		StudentID: studentID,
-		Email:     email,
+// mfaChallengeAudience marks MFA challenge tokens, so that they are never
+// accepted as access tokens
+const mfaChallengeAudience = "mfa-challenge"
+
+// mfaChallengeClaims are the claims of the token that carries a login from
+// the password step to the second factor
+type mfaChallengeClaims struct {
+	StudentID   int    `json:"student_id"`
+	DeviceLabel string `json:"device_label,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// generateMFAChallenge creates a challenge token for a student who passed
+// the password step
+func (ks *KeySet) generateMFAChallenge(studentID int, deviceLabel string, expiresAt time.Time) (string, error) {
+	return ks.sign(mfaChallengeClaims{
+		StudentID:   studentID,
+		DeviceLabel: deviceLabel,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "student-service",
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
 		},
-	}
+	})
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// validateMFAChallenge validates a challenge token and returns its claims
+func (ks *KeySet) validateMFAChallenge(tokenString string) (*mfaChallengeClaims, error) {
+	token, err := ks.verify(tokenString, &mfaChallengeClaims{}, jwt.WithAudience(mfaChallengeAudience))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(*mfaChallengeClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
 
 // GenerateRefreshToken creates a random refresh token (7 days lifetime)
@@ -54,21 +105,21 @@ func GenerateRefreshToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-// ValidateAccessToken validates JWT token and returns claims
-func ValidateAccessToken(tokenString string) (*Claims, error) {
-	secret, err := getJWTSecret()
+// hashRefreshToken returns the keyed hash under which a refresh token is
+// stored, so that a copy of the database is not enough to use the tokens
+func hashRefreshToken(token string) (string, error) {
+	key, err := getRefreshTokenKey()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
-
+// ValidateAccessToken validates JWT token and returns claims
+func (ks *KeySet) ValidateAccessToken(tokenString string) (*Claims, error) {
+	token, err := ks.verify(tokenString, &Claims{})
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
@@ -80,6 +131,10 @@ func ValidateAccessToken(tokenString string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, ErrInvalidToken
 	}
+	// Tokens for other audiences are signed with the same keys
+	if len(claims.Audience) > 0 {
+		return nil, ErrInvalidToken
+	}
 
 	return claims, nil
 }
@@ -92,3 +147,34 @@ func getJWTSecret() (string, error) {
 	}
 	return secret, nil
 }
+
+// getRefreshTokenKey retrieves the refresh token hash key from environment.
+// Without REFRESH_TOKEN_SECRET a key is derived from JWT_SECRET, so that the
+// secret itself is not used for two purposes.
+func getRefreshTokenKey() ([]byte, error) {
+	if secret := os.Getenv("REFRESH_TOKEN_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	return deriveKey("refresh-token")
+}
+
+// getTOTPKey retrieves the 32 byte key TOTP secrets are encrypted with. It is
+// the SHA-256 of TOTP_ENCRYPTION_KEY, or derived from JWT_SECRET.
+func getTOTPKey() ([]byte, error) {
+	if secret := os.Getenv("TOTP_ENCRYPTION_KEY"); secret != "" {
+		key := sha256.Sum256([]byte(secret))
+		return key[:], nil
+	}
+	return deriveKey("totp-secret")
+}
+
+// deriveKey derives a key for one purpose from JWT_SECRET
+func deriveKey(purpose string) ([]byte, error) {
+	secret, err := getJWTSecret()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRefreshToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("REFRESH_TOKEN_SECRET", "")
+
+	derived, err := hashRefreshToken("token")
+	require.NoError(t, err)
+	again, err := hashRefreshToken("token")
+	require.NoError(t, err)
+	assert.Equal(t, derived, again)
+	assert.Len(t, derived, 64)
+
+	other, err := hashRefreshToken("other-token")
+	require.NoError(t, err)
+	assert.NotEqual(t, derived, other)
+
+	// The hash depends on the key
+	t.Setenv("REFRESH_TOKEN_SECRET", "refresh-secret")
+	keyed, err := hashRefreshToken("token")
+	require.NoError(t, err)
+	assert.NotEqual(t, derived, keyed)
+
+	t.Setenv("REFRESH_TOKEN_SECRET", "")
+	t.Setenv("JWT_SECRET", "")
+	_, err = hashRefreshToken("token")
+	assert.ErrorIs(t, err, ErrMissingSecret)
+}
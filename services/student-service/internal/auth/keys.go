@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported key algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const minRSAKeyBits = 2048
+
+// KeyConfig tells LoadKeySet where the signing keys are
+type KeyConfig struct {
+	// Dir holds one PEM file per key, named <kid>.pem. Private keys (PKCS#8,
+	// or PKCS#1 for RSA) can sign and verify, public keys (PKIX) only verify.
+	// Without a directory tokens are signed HS256 with JWT_SECRET.
+	Dir string
+	// SigningKeyID is the kid of the private key that signs new tokens. It may
+	// be empty if the directory holds a single private key.
+	SigningKeyID string
+	// AcceptHS256 keeps accepting tokens signed with JWT_SECRET next to the
+	// keys in Dir, for switching an installation over to asymmetric keys
+	AcceptHS256 bool
+}
+
+// verificationKey is a public key tokens may be signed with
+type verificationKey struct {
+	id     string
+	alg    string
+	public crypto.PublicKey
+}
+
+// KeySet signs access tokens with one key and verifies them with any of the
+// keys it holds, so that a new key can be rolled out before it signs
+type KeySet struct {
+	signingID  string
+	signingAlg string
+	signingKey interface{}
+	keys       map[string]verificationKey
+	// hmacSecret is set when tokens are signed or may be signed with HS256
+	hmacSecret []byte
+}
+
+// NewHMACKeySet returns a key set that signs and verifies HS256 with secret
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{signingAlg: jwt.SigningMethodHS256.Alg(), hmacSecret: secret}
+}
+
+// LoadKeySet reads the keys described by cfg
+func LoadKeySet(cfg KeyConfig) (*KeySet, error) {
+	if cfg.Dir == "" {
+		secret, err := getJWTSecret()
+		if err != nil {
+			return nil, err
+		}
+		return NewHMACKeySet([]byte(secret)), nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{keys: map[string]verificationKey{}}
+	private := map[string]interface{}{}
+	for _, file := range files {
+		id := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".pem"), ".pub")
+		if _, ok := ks.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key id %q in %s", id, cfg.Dir)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", file, err)
+		}
+		public, alg, err := publicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", file, err)
+		}
+		ks.keys[id] = verificationKey{id: id, alg: alg, public: public}
+		switch key.(type) {
+		case *rsa.PrivateKey, ed25519.PrivateKey:
+			private[id] = key
+		}
+	}
+
+	signingID := cfg.SigningKeyID
+	if signingID == "" {
+		if len(private) != 1 {
+			return nil, fmt.Errorf("%s holds %d private keys, set the signing key id", cfg.Dir, len(private))
+		}
+		for id := range private {
+			signingID = id
+		}
+	}
+	signingKey, ok := private[signingID]
+	if !ok {
+		return nil, fmt.Errorf("no private key %q in %s", signingID, cfg.Dir)
+	}
+	ks.signingID = signingID
+	ks.signingAlg = ks.keys[signingID].alg
+	ks.signingKey = signingKey
+
+	if cfg.AcceptHS256 {
+		secret, err := getJWTSecret()
+		if err != nil {
+			return nil, err
+		}
+		ks.hmacSecret = []byte(secret)
+	}
+	return ks, nil
+}
+
+// parseKey decodes a PEM encoded private or public key
+func parseKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// publicKey returns the public half of key and the algorithm it signs with.
+// For a public key the key itself is returned.
+func publicKey(key interface{}) (crypto.PublicKey, string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return publicKey(&k.PublicKey)
+	case ed25519.PrivateKey:
+		return k.Public(), AlgEdDSA, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, "", fmt.Errorf("RSA key has %d bits, at least %d are required", k.N.BitLen(), minRSAKeyBits)
+		}
+		return k, AlgRS256, nil
+	case ed25519.PublicKey:
+		return k, AlgEdDSA, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// sign signs the claims with the signing key and sets the kid header
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks.signingKey == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.hmacSecret)
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(ks.signingAlg), claims)
+	token.Header["kid"] = ks.signingID
+	return token.SignedString(ks.signingKey)
+}
+
+// verify parses a token into claims. The kid header picks the key, and the
+// token must use the algorithm of that key. opts add further checks.
+func (ks *KeySet) verify(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA, jwt.SigningMethodHS256.Alg()}))
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc, opts...)
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if ks.hmacSecret == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return ks.hmacSecret, nil
+	}
+
+	id, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("key %q does not sign %v", id, token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// publicKey decodes the key and checks it like keys loaded from PEM files
+func (k JWK) publicKey() (crypto.PublicKey, string, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, "", fmt.Errorf("key %q: invalid modulus: %w", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", fmt.Errorf("key %q: invalid exponent", k.KeyID)
+		}
+		return publicKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())})
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, "", fmt.Errorf("key %q: unsupported or invalid OKP key", k.KeyID)
+		}
+		return publicKey(ed25519.PublicKey(x))
+	default:
+		return nil, "", fmt.Errorf("key %q: unsupported key type %q", k.KeyID, k.KeyType)
+	}
+}
+
+// JWKS is the response of /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public verification keys, sorted by kid. HS256 secrets
+// are never published, so an HS256-only key set has no keys.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.alg}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// GenerateKeyFile writes a new private key as <dir>/<kid>.pem. alg is AlgEdDSA
+// or AlgRS256. kid defaults to the current date.
+func GenerateKeyFile(dir, kid, alg string) (string, error) {
+	if kid == "" {
+		kid = time.Now().UTC().Format("2006-01-02")
+	}
+
+	var key interface{}
+	switch alg {
+	case AlgEdDSA, "":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		key = private
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			return "", err
+		}
+		key = private
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q, use %s or %s", alg, AlgEdDSA, AlgRS256)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, kid+".pem")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
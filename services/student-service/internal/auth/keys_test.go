@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	_, err := GenerateKeyFile(dir, "2026-01", AlgEdDSA)
+	require.NoError(t, err)
+
+	// A single private key signs without further configuration
+	first, err := LoadKeySet(KeyConfig{Dir: dir})
+	require.NoError(t, err)
+	oldToken, err := first.GenerateAccessToken(Claims{StudentID: 1, Email: "jan@example.com"})
+	require.NoError(t, err)
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(oldToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2026-01", parsed.Header["kid"])
+	assert.Equal(t, AlgEdDSA, parsed.Header["alg"])
+
+	// Step 1: the new key is published but does not sign yet
+	_, err = GenerateKeyFile(dir, "2026-02", AlgRS256)
+	require.NoError(t, err)
+	_, err = LoadKeySet(KeyConfig{Dir: dir})
+	assert.Error(t, err, "two private keys need a signing key id")
+	published, err := LoadKeySet(KeyConfig{Dir: dir, SigningKeyID: "2026-01"})
+	require.NoError(t, err)
+	jwks := published.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, JWK{KeyType: "OKP", KeyID: "2026-01", Use: "sig", Algorithm: AlgEdDSA, Curve: "Ed25519", X: jwks.Keys[0].X}, jwks.Keys[0])
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, AlgRS256, jwks.Keys[1].Algorithm)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+
+	// Step 2: the new key signs, tokens of the old one stay valid
+	rotated, err := LoadKeySet(KeyConfig{Dir: dir, SigningKeyID: "2026-02"})
+	require.NoError(t, err)
+	newToken, err := rotated.GenerateAccessToken(Claims{StudentID: 2})
+	require.NoError(t, err)
+	claims, err := rotated.ValidateAccessToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.StudentID)
+	claims, err = published.ValidateAccessToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, 2, claims.StudentID)
+
+	// Step 3: the old key is removed
+	require.NoError(t, os.Remove(filepath.Join(dir, "2026-01.pem")))
+	retired, err := LoadKeySet(KeyConfig{Dir: dir})
+	require.NoError(t, err)
+	_, err = retired.ValidateAccessToken(oldToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = retired.ValidateAccessToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestKeySetPublicKeysOnlyVerify(t *testing.T) {
+	signerDir, verifierDir := t.TempDir(), t.TempDir()
+	path, err := GenerateKeyFile(signerDir, "current", AlgEdDSA)
+	require.NoError(t, err)
+	signer, err := LoadKeySet(KeyConfig{Dir: signerDir})
+	require.NoError(t, err)
+
+	// Write the public half the way a consumer of the JWKS would hold it
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	private, err := parseKey(data)
+	require.NoError(t, err)
+	public, _, err := publicKey(private)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(verifierDir, "current.pub.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	_, err = LoadKeySet(KeyConfig{Dir: verifierDir})
+	assert.Error(t, err, "a public key cannot sign")
+
+	token, err := signer.GenerateAccessToken(Claims{StudentID: 7})
+	require.NoError(t, err)
+	verifier := &KeySet{keys: map[string]verificationKey{"current": {id: "current", alg: AlgEdDSA, public: public}}}
+	claims, err := verifier.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 7, claims.StudentID)
+}
+
+func TestKeySetRejectsHS256(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	dir := t.TempDir()
+	_, err := GenerateKeyFile(dir, "current", AlgEdDSA)
+	require.NoError(t, err)
+
+	hmacToken, err := NewHMACKeySet([]byte("jwt-secret")).GenerateAccessToken(Claims{StudentID: 1})
+	require.NoError(t, err)
+
+	strict, err := LoadKeySet(KeyConfig{Dir: dir})
+	require.NoError(t, err)
+	_, err = strict.ValidateAccessToken(hmacToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Only while switching over from HS256
+	transition, err := LoadKeySet(KeyConfig{Dir: dir, AcceptHS256: true})
+	require.NoError(t, err)
+	_, err = transition.ValidateAccessToken(hmacToken)
+	assert.NoError(t, err)
+
+	// A token claiming another algorithm than its key is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{StudentID: 1})
+	forged.Header["kid"] = "current"
+	forgedToken, err := forged.SignedString([]byte("guess"))
+	require.NoError(t, err)
+	_, err = transition.ValidateAccessToken(forgedToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	_, err := GenerateKeyFile(dir, "current", AlgEdDSA)
+	require.NoError(t, err)
+	keys, err := LoadKeySet(KeyConfig{Dir: dir})
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	router := gin.New()
+	NewHandler(NewService(nil, nil, nil, keys, Config{}, logger), logger).RegisterRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age")
+	var jwks JWKS
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&jwks))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "current", jwks.Keys[0].KeyID)
+}
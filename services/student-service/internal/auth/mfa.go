@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"student-service/internal/rbac"
+	"student-service/internal/student"
+)
+
+var (
+	ErrInvalidMFAToken     = errors.New("invalid or expired MFA token")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingEnrollment = errors.New("no two-factor enrollment in progress")
+	ErrMFARequiredForRole  = errors.New("two-factor authentication is required for your role")
+)
+
+// MFAConfig configures two-factor authentication
+type MFAConfig struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+	// RequiredRoles must use 2FA. Until a student with such a role enrolls,
+	// their sessions are restricted like those of unverified students.
+	RequiredRoles []rbac.Role
+	// ChallengeTTL is how long the second login step may take
+	ChallengeTTL time.Duration
+}
+
+const (
+	defaultMFAIssuer       = "GRUD"
+	defaultMFAChallengeTTL = 5 * time.Minute
+)
+
+// mfaChallenge issues the challenge a student with 2FA gets instead of
+// tokens after the password step
+func (s *Service) mfaChallenge(stud *student.Student, client ClientInfo) (*MFAChallenge, error) {
+	ttl := s.config.MFA.ChallengeTTL
+	if ttl <= 0 {
+		ttl = defaultMFAChallengeTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+	token, err := s.keys.generateMFAChallenge(stud.ID, client.DeviceLabel, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallenge{MFARequired: true, MFAToken: token, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteMFALogin is the second step of a login with 2FA. It exchanges the
+// challenge token and a TOTP or recovery code for a token pair. Wrong codes
+// count as failed logins of the account.
+func (s *Service) CompleteMFALogin(ctx context.Context, req LoginMFARequest, client ClientInfo) (*AuthResponse, error) {
+	claims, err := s.keys.validateMFAChallenge(req.MFAToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	stud, err := s.studentRepo.GetByID(ctx, claims.StudentID)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stud.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	if !s.mayLogin(stud) {
+		return nil, ErrEmailNotVerified
+	}
+
+	if err := s.checkLoginThrottle(ctx, stud.Email, client.IPAddress); err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, stud.ID, req.Code, req.RecoveryCode); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			return nil, err
+		}
+		if err := s.recordLoginFailure(ctx, stud.Email, client.IPAddress); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidMFACode
+	}
+	if err := s.clearAccountLock(ctx, stud.Email); err != nil {
+		return nil, err
+	}
+
+	if client.DeviceLabel == "" {
+		client.DeviceLabel = claims.DeviceLabel
+	}
+	return s.generateTokenPair(ctx, stud, client)
+}
+
+// MFAStatus tells a student whether 2FA is on and how many recovery codes
+// are left
+func (s *Service) MFAStatus(ctx context.Context, studentID int) (*MFAStatus, error) {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := s.mfaEnabled(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	status := &MFAStatus{Enabled: enabled, Required: s.mfaRequired(stud.Role)}
+	if enabled {
+		if status.RecoveryCodesLeft, err = s.authRepo.CountRecoveryCodes(ctx, studentID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// EnrollTOTP starts TOTP enrollment with a new secret. The secret is not
+// asked for at login until ConfirmTOTP proves the authenticator has it.
+// Starting again replaces a pending secret.
+func (s *Service) EnrollTOTP(ctx context.Context, studentID int) (*TOTPEnrollment, error) {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authRepo.SavePendingTOTPCredential(ctx, &TOTPCredential{StudentID: studentID, Secret: sealed})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := s.config.MFA.Issuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(issuer, stud.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP turns on 2FA with the first code of the authenticator and
+// returns the recovery codes
+func (s *Service) ConfirmTOTP(ctx context.Context, studentID int, code string) (*RecoveryCodesResponse, error) {
+	credential, err := s.authRepo.GetTOTPCredential(ctx, studentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoPendingEnrollment
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if err := s.useTOTPCode(ctx, credential, code, true); err != nil {
+		return nil, err
+	}
+	codes, err := s.newRecoveryCodes(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "two-factor authentication enabled", "student_id", studentID)
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after checking a code. Students whose role
+// requires 2FA cannot turn it off.
+func (s *Service) DisableTOTP(ctx context.Context, studentID int, req MFACodeRequest) error {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	if s.mfaRequired(stud.Role) {
+		return ErrMFARequiredForRole
+	}
+	if err := s.requireMFAEnabled(ctx, studentID); err != nil {
+		return err
+	}
+	if err := s.verifySecondFactor(ctx, studentID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	if err := s.authRepo.DeleteMFA(ctx, studentID); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "two-factor authentication disabled", "student_id", studentID)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, studentID int, req MFACodeRequest) (*RecoveryCodesResponse, error) {
+	if err := s.requireMFAEnabled(ctx, studentID); err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, studentID, req.Code, req.RecoveryCode); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, studentID)
+}
+
+// ResetMFA removes the second factor of a student who lost both the
+// authenticator and the recovery codes
+func (s *Service) ResetMFA(ctx context.Context, studentID int) error {
+	if err := s.authRepo.DeleteMFA(ctx, studentID); err != nil {
+		return err
+	}
+	s.logger.WarnContext(ctx, "security event: two-factor authentication reset",
+		"event", "mfa_reset", "student_id", studentID)
+	return nil
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code if one is given,
+// and uses it up. Any wrong or used code gives ErrInvalidMFACode.
+func (s *Service) verifySecondFactor(ctx context.Context, studentID int, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		err := s.authRepo.UseRecoveryCode(ctx, studentID, hashAccountToken(normalizeRecoveryCode(recoveryCode)))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidMFACode
+		}
+		if err != nil {
+			return err
+		}
+		s.logger.InfoContext(ctx, "recovery code used", "student_id", studentID)
+		return nil
+	}
+
+	credential, err := s.authRepo.GetTOTPCredential(ctx, studentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+	if credential.ConfirmedAt == nil {
+		return ErrInvalidMFACode
+	}
+	return s.useTOTPCode(ctx, credential, code, false)
+}
+
+// useTOTPCode checks a code against the credential and records its time
+// step, confirming a pending credential if confirm is set
+func (s *Service) useTOTPCode(ctx context.Context, credential *TOTPCredential, code string, confirm bool) error {
+	secret, err := openTOTPSecret(credential.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := verifyTOTP(secret, code, time.Now(), credential.LastUsedStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	err = s.authRepo.UseTOTPStep(ctx, credential.StudentID, step, confirm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidMFACode
+	}
+	return err
+}
+
+// newRecoveryCodes replaces the recovery codes of a student and returns the
+// new ones in plain text
+func (s *Service) newRecoveryCodes(ctx context.Context, studentID int) (*RecoveryCodesResponse, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashAccountToken(normalizeRecoveryCode(code))
+	}
+	if err := s.authRepo.ReplaceRecoveryCodes(ctx, studentID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// mfaEnabled reports whether the student has a confirmed second factor
+func (s *Service) mfaEnabled(ctx context.Context, studentID int) (bool, error) {
+	credential, err := s.authRepo.GetTOTPCredential(ctx, studentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return credential.ConfirmedAt != nil, nil
+}
+
+func (s *Service) requireMFAEnabled(ctx context.Context, studentID int) error {
+	enabled, err := s.mfaEnabled(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMFANotEnabled
+	}
+	return nil
+}
+
+// mfaRequired reports whether the policy requires 2FA for the role
+func (s *Service) mfaRequired(role rbac.Role) bool {
+	return slices.Contains(s.config.MFA.RequiredRoles, role)
+}
+
+// mfaEnrollmentPending reports whether the role of the student requires 2FA
+// that the student has not set up yet
+func (s *Service) mfaEnrollmentPending(ctx context.Context, stud *student.Student) (bool, error) {
+	if !s.mfaRequired(stud.Role) {
+		return false, nil
+	}
+	enabled, err := s.mfaEnabled(ctx, stud.ID)
+	return !enabled, err
+}
@@ -2,9 +2,16 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"student-service/internal/rbac"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,30 +23,103 @@ const (
 	StudentIDKey contextKey = "student_id"
 	// EmailKey is the context key for email
 	EmailKey contextKey = "email"
+	// SessionIDKey is the context key for the session (refresh token family)
+	SessionIDKey contextKey = "session_id"
+	// APIKeyKey is the context key for the API key of a machine client
+	APIKeyKey contextKey = "api_key"
 )
 
-// AuthMiddleware validates JWT from cookie and adds claims to context
-func AuthMiddleware(logger *slog.Logger) gin.HandlerFunc {
+// TokenSource is a place in the request the access token is read from
+type TokenSource string
+
+const (
+	// SourceHeader reads "Authorization: Bearer <token>"
+	SourceHeader TokenSource = "header"
+	// SourceCookie reads the token cookie set on login
+	SourceCookie TokenSource = "cookie"
+)
+
+// DefaultTokenSources prefers an explicit Authorization header over the
+// cookie a browser sends along anyway
+var DefaultTokenSources = []TokenSource{SourceHeader, SourceCookie}
+
+// ParseTokenSources validates a precedence list such as ["header", "cookie"].
+// An empty list gives DefaultTokenSources.
+func ParseTokenSources(names []string) ([]TokenSource, error) {
+	if len(names) == 0 {
+		return DefaultTokenSources, nil
+	}
+	sources := make([]TokenSource, 0, len(names))
+	for _, name := range names {
+		source := TokenSource(strings.ToLower(strings.TrimSpace(name)))
+		if source != SourceHeader && source != SourceCookie {
+			return nil, fmt.Errorf("unknown token source %q", name)
+		}
+		if slices.Contains(sources, source) {
+			return nil, fmt.Errorf("token source %q listed twice", name)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// AuthMiddleware validates the JWT against the key set and adds claims to
+// context. The token is taken from the first of the sources present in the
+// request, DefaultTokenSources if none are given. A present but invalid token
+// is rejected even if another source holds a valid one. Tokens revoked
+// according to revocations are rejected too; nil revocations checks none.
+// Requests authenticated by the cookie with an unsafe method also need the
+// CSRF token in CSRFHeader; bearer tokens are not sent by browsers on their
+// own and need none.
+//
+// A bearer token that is an API key is checked with apiKeys instead and
+// authenticates an API key principal, which has no student. With nil
+// apiKeys, API keys are refused.
+func AuthMiddleware(keys *KeySet, revocations RevocationChecker, apiKeys APIKeyVerifier, logger *slog.Logger, sources ...TokenSource) gin.HandlerFunc {
+	if len(sources) == 0 {
+		sources = DefaultTokenSources
+	}
 	return func(c *gin.Context) {
-		// Get token from cookie
-		cookie, err := c.Request.Cookie("token")
-		if err != nil {
-			logger.Warn("no auth cookie found", "path", c.Request.URL.Path)
+		token, source := requestToken(c.Request, sources)
+		if token == "" {
+			logger.Warn("no access token found", "path", c.Request.URL.Path)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
+		if source == SourceHeader && isAPIKey(token) {
+			authenticateAPIKey(c, apiKeys, logger, token)
+			return
+		}
 
 		// Validate JWT
-		claims, err := ValidateAccessToken(cookie.Value)
+		claims, err := keys.ValidateAccessToken(token)
 		if err != nil {
-			logger.Warn("invalid token", "error", err)
+			logger.Warn("invalid token", "source", source, "error", err)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
+		if revocations != nil && revocations.IsRevoked(claims) {
+			logger.Warn("revoked token", "source", source, "student_id", claims.StudentID, "session_id", claims.SessionID)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		// The browser sends the cookie along with requests of any site
+		if source == SourceCookie && !validCSRF(c.Request) {
+			logger.Warn("missing or invalid CSRF token", "method", c.Request.Method, "path", c.Request.URL.Path, "student_id", claims.StudentID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid csrf token"})
+			return
+		}
 
 		// Add claims to context
 		ctx := context.WithValue(c.Request.Context(), StudentIDKey, claims.StudentID)
 		ctx = context.WithValue(ctx, EmailKey, claims.Email)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		ctx = rbac.WithPrincipal(ctx, rbac.Principal{
+			Kind:       rbac.PrincipalStudent,
+			StudentID:  claims.StudentID,
+			Role:       principalRole(claims),
+			Restricted: claims.Restricted,
+		})
 		c.Request = c.Request.WithContext(ctx)
 
 		// Call next handler
@@ -47,18 +127,95 @@ func AuthMiddleware(logger *slog.Logger) gin.HandlerFunc {
 	}
 }
 
+// authenticateAPIKey is the part of AuthMiddleware for API keys
+func authenticateAPIKey(c *gin.Context, apiKeys APIKeyVerifier, logger *slog.Logger, key string) {
+	if apiKeys == nil {
+		logger.Warn("API key not accepted", "path", c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	apiKey, err := apiKeys.VerifyAPIKey(c.Request.Context(), key)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		logger.Warn("invalid API key", "prefix", key[:min(len(key), apiKeyPrefixLen)])
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err != nil {
+		logger.Error("API key lookup failed", "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), APIKeyKey, apiKey)
+	ctx = rbac.WithPrincipal(ctx, rbac.Principal{
+		Kind:     rbac.PrincipalAPIKey,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	})
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+}
+
+// requestToken returns the access token from the first source that has one
+func requestToken(r *http.Request, sources []TokenSource) (string, TokenSource) {
+	for _, source := range sources {
+		switch source {
+		case SourceHeader:
+			scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if ok && strings.EqualFold(scheme, "Bearer") && strings.TrimSpace(token) != "" {
+				return strings.TrimSpace(token), source
+			}
+		case SourceCookie:
+			if cookie, err := r.Cookie("token"); err == nil && cookie.Value != "" {
+				return cookie.Value, source
+			}
+		}
+	}
+	return "", ""
+}
+
 // GetStudentID extracts student ID from context
 func GetStudentID(ctx context.Context) (int, bool) {
 	studentID, ok := ctx.Value(StudentIDKey).(int)
 	return studentID, ok
 }
 
+// GetAPIKey extracts the API key the request was made with. Requests of
+// students have none.
+func GetAPIKey(ctx context.Context) (*APIKey, bool) {
+	apiKey, ok := ctx.Value(APIKeyKey).(*APIKey)
+	return apiKey, ok
+}
+
+// GetRole extracts the role of the authenticated student from context
+func GetRole(ctx context.Context) (rbac.Role, bool) {
+	p, ok := rbac.GetPrincipal(ctx)
+	return p.Role, ok
+}
+
+// principalRole returns the role from the claims. Tokens issued before roles
+// existed carry none and get the least privileged role.
+func principalRole(claims *Claims) rbac.Role {
+	if claims.Role == "" {
+		return rbac.RoleStudent
+	}
+	return claims.Role
+}
+
 // GetEmail extracts email from context
 func GetEmail(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(EmailKey).(string)
 	return email, ok
 }
 
+// GetSessionID extracts the session of the access token from context. Tokens
+// issued before sessions were tracked have none.
+func GetSessionID(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(SessionIDKey).(string)
+	return sessionID, ok && sessionID != ""
+}
+
 // SetAuthCookie sets JWT token in secure HttpOnly cookie
 func SetAuthCookie(w http.ResponseWriter, token string) {
 	// Determine SameSite based on environment
@@ -94,3 +251,35 @@ func ClearAuthCookie(w http.ResponseWriter) {
 		MaxAge:   -1, // Delete cookie
 	})
 }
+
+// oidcStateCookie holds the state of a provider login between the redirect
+// to the provider and the callback
+const oidcStateCookie = "oidc_state"
+
+// setOIDCStateCookie stores the state token of a provider login. It is
+// SameSite Lax, as the callback is a navigation coming from the provider,
+// and is only sent to the OIDC endpoints.
+func setOIDCStateCookie(w http.ResponseWriter, state string, maxAge time.Duration) {
+	env := os.Getenv("ENV")
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		HttpOnly: true,
+		Secure:   env == "production" || env == "prod" || env == "gcp-gke",
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/auth/oidc",
+		MaxAge:   int(maxAge.Seconds()),
+	})
+}
+
+// clearOIDCStateCookie removes the state cookie of a provider login
+func clearOIDCStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/auth/oidc",
+		MaxAge:   -1,
+	})
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"student-service/internal/rbac"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddlewareTokenSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := NewHMACKeySet([]byte("test-secret"))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	headerToken, err := keys.GenerateAccessToken(Claims{StudentID: 1})
+	require.NoError(t, err)
+	cookieToken, err := keys.GenerateAccessToken(Claims{StudentID: 2})
+	require.NoError(t, err)
+
+	// serve returns the status and the student the request was authenticated as
+	serve := func(sources []TokenSource, authorization, cookie string) (int, int) {
+		router := gin.New()
+		var studentID int
+		router.GET("/", AuthMiddleware(keys, nil, nil, logger, sources...), func(c *gin.Context) {
+			studentID, _ = GetStudentID(c.Request.Context())
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code, studentID
+	}
+
+	tests := []struct {
+		name          string
+		sources       []TokenSource
+		authorization string
+		cookie        string
+		wantStatus    int
+		wantStudent   int
+	}{
+		{"bearer header", nil, "Bearer " + headerToken, "", http.StatusOK, 1},
+		{"lowercase scheme", nil, "bearer " + headerToken, "", http.StatusOK, 1},
+		{"cookie", nil, "", cookieToken, http.StatusOK, 2},
+		{"header wins by default", nil, "Bearer " + headerToken, cookieToken, http.StatusOK, 1},
+		{"cookie first", []TokenSource{SourceCookie, SourceHeader}, "Bearer " + headerToken, cookieToken, http.StatusOK, 2},
+		{"other schemes are ignored", nil, "Basic dXNlcjpwYXNz", cookieToken, http.StatusOK, 2},
+		{"invalid header does not fall back", nil, "Bearer invalid", cookieToken, http.StatusUnauthorized, 0},
+		{"disabled source", []TokenSource{SourceCookie}, "Bearer " + headerToken, "", http.StatusUnauthorized, 0},
+		{"no token", nil, "", "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, studentID := serve(tt.sources, tt.authorization, tt.cookie)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantStudent, studentID)
+		})
+	}
+}
+
+// apiKeyVerifierFunc lets a function stand in for the service
+type apiKeyVerifierFunc func(ctx context.Context, key string) (*APIKey, error)
+
+func (f apiKeyVerifierFunc) VerifyAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	return f(ctx, key)
+}
+
+func TestAuthMiddlewareAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := NewHMACKeySet([]byte("test-secret"))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	verifier := apiKeyVerifierFunc(func(_ context.Context, key string) (*APIKey, error) {
+		switch key {
+		case "grud_valid":
+			return &APIKey{ID: 3, Scopes: []rbac.Permission{rbac.StudentsRead}}, nil
+		case "grud_broken":
+			return nil, errors.New("database is down")
+		default:
+			return nil, ErrInvalidAPIKey
+		}
+	})
+
+	// serve returns the status and the principal of the request
+	serve := func(apiKeys APIKeyVerifier, authorization, cookie string) (int, rbac.Principal) {
+		router := gin.New()
+		var principal rbac.Principal
+		router.GET("/", AuthMiddleware(keys, nil, apiKeys, logger), func(c *gin.Context) {
+			principal, _ = rbac.GetPrincipal(c.Request.Context())
+			_, hasStudent := GetStudentID(c.Request.Context())
+			_, hasKey := GetAPIKey(c.Request.Context())
+			assert.NotEqual(t, hasStudent, hasKey)
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code, principal
+	}
+
+	status, principal := serve(verifier, "Bearer grud_valid", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, principal.IsAPIKey())
+	assert.Equal(t, int64(3), principal.APIKeyID)
+	assert.Zero(t, principal.StudentID)
+	assert.True(t, principal.Can(rbac.StudentsRead))
+	assert.False(t, principal.Can(rbac.StudentsWrite))
+
+	status, _ = serve(verifier, "Bearer grud_revoked", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = serve(verifier, "Bearer grud_broken", "")
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	// Routes without a verifier only take student tokens
+	status, _ = serve(nil, "Bearer grud_valid", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Keys are bearer credentials, a cookie never carries one
+	status, _ = serve(verifier, "", "grud_valid")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	token, err := keys.GenerateAccessToken(Claims{StudentID: 1, Role: rbac.RoleAdmin})
+	require.NoError(t, err)
+	status, principal = serve(verifier, "Bearer "+token, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.False(t, principal.IsAPIKey())
+	assert.Equal(t, 1, principal.StudentID)
+}
+
+func TestParseTokenSources(t *testing.T) {
+	sources, err := ParseTokenSources(nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTokenSources, sources)
+
+	sources, err = ParseTokenSources([]string{"Cookie", " header"})
+	require.NoError(t, err)
+	assert.Equal(t, []TokenSource{SourceCookie, SourceHeader}, sources)
+
+	_, err = ParseTokenSources([]string{"query"})
+	assert.Error(t, err)
+	_, err = ParseTokenSources([]string{"cookie", "cookie"})
+	assert.Error(t, err)
+}
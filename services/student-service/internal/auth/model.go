@@ -3,24 +3,197 @@ package auth
 import (
 	"time"
 
+	"student-service/internal/rbac"
+
 	"github.com/uptrace/bun"
 )
 
-// RefreshToken stores refresh tokens in database
+// RefreshToken stores refresh tokens in database, identified by the keyed
+// hash of the token. Each token can be used
+// once. Refreshing marks it used and issues the next token of the same
+// family; a family is one login session.
 type RefreshToken struct {
 	bun.BaseModel `bun:"table:refresh_tokens,alias:rt"`
 
-	ID        int       `bun:"id,pk,autoincrement"`
+	ID        int        `bun:"id,pk,autoincrement"`
+	StudentID int        `bun:"student_id,notnull"`
+	FamilyID  string     `bun:"family_id,notnull"`
+	TokenHash string     `bun:"token_hash,unique"`
+	ExpiresAt time.Time  `bun:"expires_at,notnull"`
+	UsedAt    *time.Time `bun:"used_at,nullzero"`
+	CreatedAt time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+	// Token is only set on rows written before tokens were hashed, see
+	// Repository.AdoptLegacyRefreshToken
+	Token string `bun:"token,unique,nullzero"`
+
+	// Session metadata. UserAgent and IPAddress are those of the client that
+	// last used the session, the rest is carried over from the login.
+	UserAgent        string    `bun:"user_agent,nullzero"`
+	IPAddress        string    `bun:"ip_address,nullzero"`
+	DeviceLabel      string    `bun:"device_label,nullzero"`
+	SessionStartedAt time.Time `bun:"session_started_at,notnull,default:current_timestamp"`
+	LastUsedAt       time.Time `bun:"last_used_at,notnull,default:current_timestamp"`
+}
+
+// Session is a login session of a student as listed by GET /auth/sessions.
+// Its ID is the refresh token family.
+type Session struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"deviceLabel"`
+	UserAgent   string    `json:"userAgent"`
+	IPAddress   string    `json:"ipAddress"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastUsedAt  time.Time `json:"lastUsedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+}
+
+// ClientInfo describes the client that logs in or refreshes a session
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+	// DeviceLabel is chosen by the student; it defaults to a description of
+	// the user agent
+	DeviceLabel string
+}
+
+// Account token purposes
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeInvitation        = "invitation"
+)
+
+// AccountToken is a single-use token sent to a student by email. Only the
+// hash of the token is stored. Email is the address a verification token or
+// an invitation was sent to.
+type AccountToken struct {
+	bun.BaseModel `bun:"table:account_tokens,alias:at"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	StudentID int        `bun:"student_id,notnull"`
+	Purpose   string     `bun:"purpose,notnull"`
+	TokenHash string     `bun:"token_hash,unique,notnull"`
+	Email     string     `bun:"email,nullzero"`
+	ExpiresAt time.Time  `bun:"expires_at,notnull"`
+	UsedAt    *time.Time `bun:"used_at,nullzero"`
+	CreatedAt time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// Login attempt scopes
+const (
+	ScopeAccount = "account"
+	ScopeIP      = "ip"
+)
+
+// LoginAttempt counts recent failed logins for an account or a client IP
+type LoginAttempt struct {
+	bun.BaseModel `bun:"table:login_attempts,alias:la"`
+
+	Scope         string     `bun:"scope,pk"`
+	Subject       string     `bun:"subject,pk"`
+	Failures      int        `bun:"failures,notnull,default:0"`
+	LastFailureAt time.Time  `bun:"last_failure_at,notnull,default:current_timestamp"`
+	LockedUntil   *time.Time `bun:"locked_until,nullzero"`
+}
+
+// TOTPCredential is the TOTP second factor of a student. Secret is sealed
+// with sealTOTPSecret. Until ConfirmedAt is set the enrollment is pending
+// and the factor is not asked for at login.
+type TOTPCredential struct {
+	bun.BaseModel `bun:"table:totp_credentials,alias:tc"`
+
+	StudentID    int        `bun:"student_id,pk"`
+	Secret       string     `bun:"secret,notnull"`
+	ConfirmedAt  *time.Time `bun:"confirmed_at,nullzero"`
+	LastUsedStep int64      `bun:"last_used_step,notnull,default:0"`
+	CreatedAt    time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code. Only the hash
+// of the normalized code is stored.
+type RecoveryCode struct {
+	bun.BaseModel `bun:"table:recovery_codes,alias:rc"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	StudentID int        `bun:"student_id,notnull"`
+	CodeHash  string     `bun:"code_hash,unique,notnull"`
+	UsedAt    *time.Time `bun:"used_at,nullzero"`
+	CreatedAt time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// FederatedIdentity links an account at an OpenID Connect provider to a
+// student. Subject is the stable "sub" claim of the provider.
+type FederatedIdentity struct {
+	bun.BaseModel `bun:"table:federated_identities,alias:fi"`
+
+	Issuer    string    `bun:"issuer,pk"`
+	Subject   string    `bun:"subject,pk"`
 	StudentID int       `bun:"student_id,notnull"`
-	Token     string    `bun:"token,unique,notnull"`
-	ExpiresAt time.Time `bun:"expires_at,notnull"`
 	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp"`
 }
 
+// APIKey lets a machine client call /api with a fixed set of permissions
+// instead of logging in as a student. Only the hash of the key is stored;
+// Prefix is its start, to recognize a key in the list. CreatedBy is nil once
+// the student who created the key is purged.
+type APIKey struct {
+	bun.BaseModel `bun:"table:api_keys,alias:ak"`
+
+	ID         int64             `bun:"id,pk,autoincrement" json:"id"`
+	Name       string            `bun:"name,notnull" json:"name"`
+	Prefix     string            `bun:"prefix,notnull" json:"prefix"`
+	KeyHash    string            `bun:"key_hash,unique,notnull" json:"-"`
+	Scopes     []rbac.Permission `bun:"scopes,type:jsonb,notnull" json:"scopes"`
+	CreatedBy  *int              `bun:"created_by" json:"createdBy,omitempty"`
+	ExpiresAt  time.Time         `bun:"expires_at,notnull" json:"expiresAt"`
+	LastUsedAt *time.Time        `bun:"last_used_at,nullzero" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time        `bun:"revoked_at,nullzero" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time         `bun:"created_at,notnull,default:current_timestamp" json:"createdAt"`
+}
+
+// Revocation kinds, which decide what the subject of a Revocation is
+const (
+	// RevokeToken revokes one access token; the subject is its "jti"
+	RevokeToken = "token"
+	// RevokeSession revokes the access tokens of a session; the subject is
+	// the refresh token family
+	RevokeSession = "session"
+)
+
+// Revocation reasons
+const (
+	ReasonLogout            = "logout"
+	ReasonSessionRevoked    = "session_revoked"
+	ReasonLogoutAll         = "logout_all"
+	ReasonPasswordChange    = "password_change"
+	ReasonPasswordReset     = "password_reset"
+	ReasonSuspended         = "suspended"
+	ReasonForceLogout       = "force_logout"
+	ReasonAccountTakeover   = "account_takeover"
+	ReasonRefreshTokenReuse = "refresh_token_reuse"
+)
+
+// Revocation makes access tokens invalid before they expire. It is kept
+// until ExpiresAt, when the last token it covers has expired anyway. The
+// JSON form is what is sent to the other instances over NATS.
+type Revocation struct {
+	bun.BaseModel `bun:"table:revoked_tokens,alias:rv"`
+
+	ID        int64     `bun:"id,pk,autoincrement" json:"id"`
+	Kind      string    `bun:"kind,notnull" json:"kind"`
+	Subject   string    `bun:"subject,notnull" json:"subject"`
+	Reason    string    `bun:"reason,notnull" json:"reason"`
+	RevokedAt time.Time `bun:"revoked_at,notnull" json:"revokedAt"`
+	ExpiresAt time.Time `bun:"expires_at,notnull" json:"expiresAt"`
+}
+
 // LoginRequest is the request body for login
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email       string `json:"email" validate:"required,email"`
+	Password    string `json:"password" validate:"required"`
+	DeviceLabel string `json:"deviceLabel" validate:"max=64"`
 }
 
 // RegisterRequest is the request body for registration
@@ -28,9 +201,11 @@ type RegisterRequest struct {
 	FirstName string `json:"firstName" validate:"required"`
 	LastName  string `json:"lastName" validate:"required"`
 	Email     string `json:"email" validate:"required,email"`
-	Password  string `json:"password" validate:"required,min=8"`
+	Password  string `json:"password" validate:"required"`
 	Major     string `json:"major"`
 	Year      int    `json:"year" validate:"min=0,max=10"`
+	// DeviceLabel names the session started by the registration
+	DeviceLabel string `json:"deviceLabel" validate:"max=64"`
 }
 
 // RefreshRequest is the request body for token refresh
@@ -38,9 +213,114 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" validate:"required"`
 }
 
+// ChangePasswordRequest is the request body for changing the own password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required"`
+}
+
+// ForgotPasswordRequest is the request body for requesting a reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest is the request body for setting a password with a reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+// AcceptInvitationRequest is the request body for choosing the first
+// password with an invitation token
+type AcceptInvitationRequest struct {
+	Token       string `json:"token" validate:"required"`
+	Password    string `json:"password" validate:"required"`
+	DeviceLabel string `json:"deviceLabel" validate:"max=64"`
+}
+
+// VerifyEmailRequest is the request body for confirming an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ResendVerificationRequest is the request body for a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// MFAChallenge is the response of the password step of a login when the
+// student has two-factor authentication enabled. The token is exchanged for
+// an AuthResponse at POST /auth/login/mfa together with a code.
+type MFAChallenge struct {
+	MFARequired bool      `json:"mfaRequired"`
+	MFAToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// LoginMFARequest is the request body for the second step of a login. Either
+// a TOTP code or a recovery code is required.
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfaToken" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// ConfirmTOTPRequest is the request body for finishing TOTP enrollment
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// MFACodeRequest confirms an MFA change with a TOTP code or a recovery code
+type MFACodeRequest struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// TOTPEnrollment is the response of starting TOTP enrollment. The
+// provisioning URI is shown as a QR code; the secret is for typing it in.
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// RecoveryCodesResponse lists new recovery codes. They are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAStatus is the response of GET /auth/mfa
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required is set when the role of the student has to use 2FA
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// CreateAPIKeyRequest is the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string    `json:"name" validate:"required,max=100"`
+	Scopes    []string  `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt time.Time `json:"expiresAt" validate:"required"`
+}
+
+// CreatedAPIKey is the response of creating an API key. Key is shown only
+// this once.
+type CreatedAPIKey struct {
+	APIKey *APIKey `json:"apiKey"`
+	Key    string  `json:"key"`
+}
+
 // AuthResponse is the response for successful authentication
+// The tokens are empty when the login policy does not allow the student in yet.
 type AuthResponse struct {
-	AccessToken  string      `json:"accessToken"`
-	RefreshToken string      `json:"refreshToken"`
+	AccessToken  string      `json:"accessToken,omitempty"`
+	RefreshToken string      `json:"refreshToken,omitempty"`
 	Student      interface{} `json:"student"`
+	// MFAEnrollmentRequired is set when the role of the student requires 2FA
+	// but the student has not enrolled yet. The session is restricted until
+	// then.
+	MFAEnrollmentRequired bool `json:"mfaEnrollmentRequired,omitempty"`
+	// CSRFToken has to be sent in the X-CSRF-Token header with unsafe
+	// requests authenticated by the cookie. It is only issued with the cookie.
+	CSRFToken string `json:"csrfToken,omitempty"`
 }
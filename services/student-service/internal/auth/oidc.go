@@ -0,0 +1,543 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"student-service/internal/student"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrOIDCDisabled         = errors.New("OpenID Connect login is not configured")
+	ErrInvalidOIDCState     = errors.New("invalid or expired login state")
+	ErrOIDCLoginDenied      = errors.New("login was not completed at the identity provider")
+	ErrOIDCProvider         = errors.New("identity provider request failed")
+	ErrOIDCEmailNotVerified = errors.New("the identity provider has not verified the email address")
+	ErrOIDCNoAccount        = errors.New("no account exists for this email address")
+)
+
+// OIDCConfig configures login with an OpenID Connect provider (authorization
+// code flow with PKCE). The client secret is not part of it, it is read from
+// OIDC_CLIENT_SECRET.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider. OIDC login is off without it.
+	Issuer   string
+	ClientID string
+	// RedirectURL is where the provider sends the browser back to; it must
+	// lead to GET /auth/oidc/callback with the query intact
+	RedirectURL string
+	// Scopes are requested in addition to openid, defaultOIDCScopes if empty
+	Scopes []string
+	// Provision creates an account for a verified email that has none.
+	// Without it only existing students can log in with the provider.
+	Provision bool
+	// HTTPClient talks to the provider, a client with a 10 second timeout if
+	// nil
+	HTTPClient *http.Client
+}
+
+var defaultOIDCScopes = []string{"email", "profile"}
+
+// oidcStateTTL is how long a student may take at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCLogin is the start of a provider login. The browser is sent to URL and
+// keeps State in a cookie until it comes back to the callback.
+type OIDCLogin struct {
+	URL       string
+	State     string
+	ExpiresAt time.Time
+}
+
+// OIDCCallback is what the provider sends the browser back with
+type OIDCCallback struct {
+	Code  string
+	State string
+	// Error is set instead of Code when the login failed at the provider,
+	// for example because the student declined
+	Error string
+}
+
+// oidcStateAudience marks the tokens that carry a provider login from the
+// start to the callback
+const oidcStateAudience = "oidc-state"
+
+// oidcStateClaims bind the callback to the browser that started the login.
+// The token is only ever stored in an HttpOnly cookie.
+type oidcStateClaims struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	DeviceLabel  string `json:"device_label,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// idTokenClaims are the ID token claims a login needs
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// OIDCEnabled reports whether login with a provider is configured
+func (s *Service) OIDCEnabled() bool {
+	return s.oidc != nil
+}
+
+// BeginOIDCLogin starts a provider login. The state, nonce and PKCE verifier
+// travel in the signed State token rather than in the database.
+func (s *Service) BeginOIDCLogin(ctx context.Context, deviceLabel string) (*OIDCLogin, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+	discovery, err := s.oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := oidcStateClaims{DeviceLabel: deviceLabel}
+	for _, value := range []*string{&claims.State, &claims.Nonce, &claims.CodeVerifier} {
+		if *value, err = randomURLString(32); err != nil {
+			return nil, err
+		}
+	}
+	expiresAt := time.Now().Add(oidcStateTTL)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "student-service",
+		Audience:  jwt.ClaimStrings{oidcStateAudience},
+	}
+	state, err := s.keys.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", s.oidc.config.ClientID)
+	query.Set("redirect_uri", s.oidc.config.RedirectURL)
+	query.Set("scope", strings.Join(s.oidc.scopes(), " "))
+	query.Set("state", claims.State)
+	query.Set("nonce", claims.Nonce)
+	query.Set("code_challenge", pkceChallenge(claims.CodeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid authorization endpoint: %v", ErrOIDCProvider, err)
+	}
+	for key, values := range authURL.Query() {
+		query[key] = values
+	}
+	authURL.RawQuery = query.Encode()
+
+	return &OIDCLogin{URL: authURL.String(), State: state, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteOIDCLogin finishes a provider login. stateToken is the State of
+// the OIDCLogin the browser kept. The student is found by the linked
+// provider account, then by verified email, and is created if provisioning
+// is on. Students with 2FA get an MFAChallenge like after a password.
+func (s *Service) CompleteOIDCLogin(ctx context.Context, stateToken string, callback OIDCCallback, client ClientInfo) (*AuthResponse, *MFAChallenge, error) {
+	if s.oidc == nil {
+		return nil, nil, ErrOIDCDisabled
+	}
+	state, err := s.validateOIDCState(stateToken, callback.State)
+	if err != nil {
+		return nil, nil, err
+	}
+	if callback.Error != "" {
+		return nil, nil, fmt.Errorf("%w: %s", ErrOIDCLoginDenied, callback.Error)
+	}
+	if callback.Code == "" {
+		return nil, nil, ErrInvalidOIDCState
+	}
+
+	idToken, err := s.oidc.exchange(ctx, callback.Code, state.CodeVerifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, err := s.oidc.verifyIDToken(ctx, idToken, state.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stud, err := s.oidcStudent(ctx, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stud.Suspended() {
+		return nil, nil, ErrAccountSuspended
+	}
+	if !s.mayLogin(stud) {
+		return nil, nil, ErrEmailNotVerified
+	}
+
+	mfaEnabled, err := s.mfaEnabled(ctx, stud.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.DeviceLabel == "" {
+		client.DeviceLabel = state.DeviceLabel
+	}
+	if mfaEnabled {
+		challenge, err := s.mfaChallenge(stud, client)
+		return nil, challenge, err
+	}
+	resp, err := s.generateTokenPair(ctx, stud, client)
+	return resp, nil, err
+}
+
+// validateOIDCState checks the state token of the browser against the state
+// the provider sent back, which stops a login being completed in a browser
+// other than the one that started it
+func (s *Service) validateOIDCState(stateToken, state string) (*oidcStateClaims, error) {
+	token, err := s.keys.verify(stateToken, &oidcStateClaims{}, jwt.WithAudience(oidcStateAudience))
+	if err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	claims, ok := token.Claims.(*oidcStateClaims)
+	if !ok || !token.Valid || state == "" || claims.State != state {
+		return nil, ErrInvalidOIDCState
+	}
+	return claims, nil
+}
+
+// oidcStudent returns the student a verified provider account logs in as,
+// linking the account on first use
+func (s *Service) oidcStudent(ctx context.Context, claims *idTokenClaims) (*student.Student, error) {
+	identity, err := s.authRepo.GetFederatedIdentity(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		stud, err := s.studentRepo.GetByID(ctx, identity.StudentID)
+		if !errors.Is(err, student.ErrStudentNotFound) {
+			return stud, err
+		}
+		// The student was deleted; fall through to the email
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	stud, err := s.studentRepo.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if stud.VerifiedAt == nil {
+			if err := s.takeOverUnverified(ctx, stud); err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, student.ErrStudentNotFound):
+		if !s.oidc.config.Provision {
+			return nil, ErrOIDCNoAccount
+		}
+		if stud, err = s.provisionOIDCStudent(ctx, claims); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := s.authRepo.LinkFederatedIdentity(ctx, &FederatedIdentity{
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		StudentID: stud.ID,
+	}); err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "provider account linked", "student_id", stud.ID, "issuer", claims.Issuer)
+	return stud, nil
+}
+
+// takeOverUnverified hands an unverified account to the owner of its email,
+// whom the provider vouches for. Whoever registered it may not have been the
+// owner, so the password, a pending invitation, the links sent by email and
+// the sessions are dropped.
+func (s *Service) takeOverUnverified(ctx context.Context, stud *student.Student) error {
+	now := time.Now()
+	stud.VerifiedAt = &now
+	stud.Password = ""
+	stud.InvitedAt = nil
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "verified_at", "password", "invited_at"); err != nil {
+		return err
+	}
+	if err := s.authRepo.DeleteAccountTokens(ctx, stud.ID); err != nil {
+		return err
+	}
+	if err := s.signOut(ctx, stud.ID, ReasonAccountTakeover); err != nil {
+		return err
+	}
+	s.logger.WarnContext(ctx, "security event: unverified account taken over by provider login",
+		"event", "oidc_account_takeover", "student_id", stud.ID)
+	return nil
+}
+
+// provisionOIDCStudent creates a verified student without a password. A
+// password can be set later with the reset flow.
+func (s *Service) provisionOIDCStudent(ctx context.Context, claims *idTokenClaims) (*student.Student, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	now := time.Now()
+	stud, err := s.studentRepo.Create(ctx, &student.Student{
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      claims.Email,
+		VerifiedAt: &now,
+	})
+	if errors.Is(err, student.ErrEmailTaken) {
+		// A concurrent login created it, or a deleted student still holds
+		// the email
+		stud, err = s.studentRepo.GetByEmail(ctx, claims.Email)
+		if errors.Is(err, student.ErrStudentNotFound) {
+			return nil, ErrOIDCNoAccount
+		}
+		return stud, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "student provisioned from provider login", "student_id", stud.ID, "issuer", claims.Issuer)
+	return stud, nil
+}
+
+// oidcProvider talks to the provider. Discovery and keys are fetched on
+// first use and cached; the keys are fetched again for an unknown kid, so
+// that the provider can rotate them.
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]verificationKey
+}
+
+// oidcDiscovery is the part of the provider metadata a login needs
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func newOIDCProvider(config OIDCConfig) *oidcProvider {
+	if config.Issuer == "" {
+		return nil
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &oidcProvider{config: config, client: client}
+}
+
+func (p *oidcProvider) scopes() []string {
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+	return append([]string{"openid"}, scopes...)
+}
+
+// discover returns the provider metadata
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovery names issuer %q", ErrOIDCProvider, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete discovery document", ErrOIDCProvider)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// exchange redeems the authorization code and returns the ID token
+func (p *oidcProvider) exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if secret := os.Getenv("OIDC_CLIENT_SECRET"); secret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(secret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no ID token", ErrOIDCProvider)
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token
+func (p *oidcProvider) verifyIDToken(ctx context.Context, idToken, nonce string) (*idTokenClaims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("key %q does not sign %v", id, token.Header["alg"])
+		}
+		return key.public, nil
+	}
+	token, err := jwt.ParseWithClaims(idToken, &idTokenClaims{}, keyFunc,
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token: %v", ErrOIDCProvider, err)
+	}
+	claims, ok := token.Claims.(*idTokenClaims)
+	if !ok || !token.Valid || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: invalid ID token", ErrOIDCProvider)
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: ID token nonce does not match", ErrOIDCProvider)
+	}
+	return claims, nil
+}
+
+// key returns a signing key of the provider
+func (p *oidcProvider) key(ctx context.Context, id string) (verificationKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[id]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return verificationKey{}, err
+	}
+	var set JWKS
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return verificationKey{}, err
+	}
+	keys := map[string]verificationKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, alg, err := jwk.publicKey()
+		if err != nil {
+			// Keys of types we do not use are no reason to fail
+			continue
+		}
+		keys[jwk.KeyID] = verificationKey{id: jwk.KeyID, alg: alg, public: public}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok = keys[id]; !ok {
+		return verificationKey{}, fmt.Errorf("unknown key id %q", id)
+	}
+	return key, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.do(req, v)
+}
+
+// do sends a request to the provider and decodes the JSON response. Any
+// failure is an ErrOIDCProvider.
+func (p *oidcProvider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s %s returned %s", ErrOIDCProvider, req.Method, req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid response from %s: %v", ErrOIDCProvider, req.URL.Path, err)
+	}
+	return nil
+}
+
+// pkceChallenge returns the S256 code challenge of a verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomURLString returns n random bytes, base64url encoded
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
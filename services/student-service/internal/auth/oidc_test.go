@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"grud/testing/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLoginFlow(t *testing.T) {
+	provider := oidctest.New(t, "student-service")
+	provider.SetUser(oidctest.User{Subject: "42", Email: "jan@example.com", EmailVerified: true, GivenName: "Jan"})
+	s := &Service{
+		keys: NewHMACKeySet([]byte("test-secret")),
+		oidc: newOIDCProvider(OIDCConfig{
+			Issuer:      provider.URL,
+			ClientID:    "student-service",
+			RedirectURL: "https://app.example.com/auth/oidc/callback",
+		}),
+	}
+	ctx := context.Background()
+
+	login, err := s.BeginOIDCLogin(ctx, "laptop")
+	require.NoError(t, err)
+	authURL, err := url.Parse(login.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "openid email profile", authURL.Query().Get("scope"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+
+	// The challenge is derived from the verifier that stays with the browser
+	state, err := s.validateOIDCState(login.State, authURL.Query().Get("state"))
+	require.NoError(t, err)
+	assert.Equal(t, pkceChallenge(state.CodeVerifier), authURL.Query().Get("code_challenge"))
+	assert.Equal(t, "laptop", state.DeviceLabel)
+
+	// A state from another login is refused
+	other, err := s.BeginOIDCLogin(ctx, "")
+	require.NoError(t, err)
+	_, err = s.validateOIDCState(other.State, authURL.Query().Get("state"))
+	assert.ErrorIs(t, err, ErrInvalidOIDCState)
+	_, err = s.validateOIDCState("", authURL.Query().Get("state"))
+	assert.ErrorIs(t, err, ErrInvalidOIDCState)
+
+	callback := provider.Authorize(t, login.URL)
+	assert.Equal(t, authURL.Query().Get("state"), callback.Get("state"))
+
+	// The code is bound to the verifier
+	_, err = s.oidc.exchange(ctx, callback.Get("code"), "another-verifier")
+	assert.ErrorIs(t, err, ErrOIDCProvider)
+
+	callback = provider.Authorize(t, login.URL)
+	idToken, err := s.oidc.exchange(ctx, callback.Get("code"), state.CodeVerifier)
+	require.NoError(t, err)
+
+	claims, err := s.oidc.verifyIDToken(ctx, idToken, state.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, provider.URL, claims.Issuer)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "jan@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, "Jan", claims.GivenName)
+
+	_, err = s.oidc.verifyIDToken(ctx, idToken, "another-nonce")
+	assert.ErrorIs(t, err, ErrOIDCProvider)
+
+	// A token issued to another client is refused
+	otherClient := newOIDCProvider(OIDCConfig{Issuer: provider.URL, ClientID: "other-client"})
+	_, err = otherClient.verifyIDToken(ctx, idToken, state.Nonce)
+	assert.ErrorIs(t, err, ErrOIDCProvider)
+
+	// So is a token of another provider
+	impostor := oidctest.New(t, "student-service")
+	impostorClient := newOIDCProvider(OIDCConfig{Issuer: impostor.URL, ClientID: "student-service"})
+	_, err = impostorClient.verifyIDToken(ctx, idToken, state.Nonce)
+	assert.ErrorIs(t, err, ErrOIDCProvider)
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	s := &Service{oidc: newOIDCProvider(OIDCConfig{})}
+	assert.False(t, s.OIDCEnabled())
+	_, err := s.BeginOIDCLogin(context.Background(), "")
+	assert.ErrorIs(t, err, ErrOIDCDisabled)
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"student-service/internal/mail"
+	"student-service/internal/student"
+)
+
+var (
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+// ChangePassword sets a new password after checking the current one. All
+// other sessions of the student are revoked and a fresh token pair is
+// returned for the caller.
+func (s *Service) ChangePassword(ctx context.Context, studentID int, req ChangePasswordRequest, client ClientInfo) (*AuthResponse, error) {
+	if err := s.config.PasswordPolicy.Check(req.NewPassword); err != nil {
+		return nil, err
+	}
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _, err := s.hasher.Verify(req.CurrentPassword, stud.Password); !ok {
+		if err != nil {
+			s.logger.ErrorContext(ctx, "stored password hash is unusable", "student_id", studentID, "error", err)
+		}
+		return nil, ErrIncorrectPassword
+	}
+
+	if err := s.setPassword(ctx, stud, req.NewPassword, ReasonPasswordChange); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "password changed", "student_id", studentID)
+	return s.generateTokenPair(ctx, stud, client)
+}
+
+// ForgotPassword emails a password reset link if a student with the email
+// exists. It reports success either way so that it cannot be used to find
+// out which emails are registered. Invited students get a new invitation
+// instead.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	stud, err := s.studentRepo.GetByEmail(ctx, email)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		s.logger.InfoContext(ctx, "password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stud.Invited() {
+		return s.InviteStudent(ctx, stud.ID)
+	}
+
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.passwordResetTTL())
+	if err := s.authRepo.CreateAccountToken(ctx, &AccountToken{
+		StudentID: stud.ID,
+		Purpose:   PurposePasswordReset,
+		TokenHash: hashAccountToken(token),
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      stud.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"someone asked to reset the password of your account. To choose a new password open\n\n"+
+			"%s\n\n"+
+			"The link is valid for %s and can be used once. If you did not ask for it, ignore this email.\n",
+			stud.FirstName, s.appLink("/reset-password", token), s.passwordResetTTL()),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send password reset email: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "password reset email sent", "student_id", stud.ID)
+	return nil
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// signs the student out everywhere
+func (s *Service) ResetPassword(ctx context.Context, req ResetPasswordRequest) error {
+	// A refused password leaves the link usable
+	if err := s.config.PasswordPolicy.Check(req.NewPassword); err != nil {
+		return err
+	}
+	token, err := s.authRepo.ConsumeAccountToken(ctx, PurposePasswordReset, hashAccountToken(req.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	stud, err := s.studentRepo.GetByID(ctx, token.StudentID)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(ctx, stud, req.NewPassword, ReasonPasswordReset); err != nil {
+		return err
+	}
+	// Whoever can read the mailbox may sign in again right away
+	if err := s.clearAccountLock(ctx, stud.Email); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "password reset", "student_id", stud.ID)
+	return nil
+}
+
+// setPassword stores a new password hash and revokes all sessions with their
+// access tokens, giving reason for the revocations. The password must have
+// passed the policy.
+func (s *Service) setPassword(ctx context.Context, stud *student.Student, newPassword, reason string) error {
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	stud.Password = hashedPassword
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "password"); err != nil {
+		return err
+	}
+	return s.signOut(ctx, stud.ID, reason)
+}
+
+// rehashPassword replaces a hash made with an outdated algorithm or outdated
+// parameters after a successful login. The login goes on if it fails.
+func (s *Service) rehashPassword(ctx context.Context, stud *student.Student, plain string) {
+	hashedPassword, err := s.hasher.Hash(plain)
+	if err == nil {
+		stud.Password = hashedPassword
+		stud.Version = 0
+		err = s.studentRepo.Update(ctx, stud, "password")
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "failed to upgrade password hash", "student_id", stud.ID, "error", err)
+		return
+	}
+	s.logger.InfoContext(ctx, "password hash upgraded", "student_id", stud.ID)
+}
+
+func (s *Service) passwordResetTTL() time.Duration {
+	if s.config.PasswordResetTTL <= 0 {
+		return defaultPasswordResetTTL
+	}
+	return s.config.PasswordResetTTL
+}
+
+// appLink builds a link into the web app carrying a token
+func (s *Service) appLink(path, token string) string {
+	return strings.TrimRight(s.config.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// appRedirect returns the app URL with the values in its fragment
+func (s *Service) appRedirect(fragment url.Values) string {
+	return strings.TrimRight(s.config.AppURL, "/") + "/#" + fragment.Encode()
+}
+
+// hashAccountToken returns the hex SHA-256 of a token. The tokens are 256-bit
+// random values, so a plain hash is enough to make a database leak useless.
+func hashAccountToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
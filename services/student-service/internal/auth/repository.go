@@ -2,9 +2,13 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"slices"
 	"time"
 
 	"grud/common/metrics"
+	"student-service/internal/student"
 
 	"github.com/uptrace/bun"
 )
@@ -21,85 +25,705 @@ func NewRepository(db *bun.DB, m *metrics.Metrics) *Repository {
 	}
 }
 
+// errRefreshTokenReused is returned by RotateRefreshToken for a token that
+// was already used. Its family has been revoked by then.
+var errRefreshTokenReused = errors.New("refresh token reused")
+
 // CreateRefreshToken stores a new refresh token
-func (r *Repository) CreateRefreshToken(ctx context.Context, studentID int, token string, expiresAt time.Time) error {
+func (r *Repository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
 	start := time.Now()
-	refreshToken := &RefreshToken{
-		StudentID: studentID,
-		Token:     token,
-		ExpiresAt: expiresAt,
-	}
 
-	_, err := r.db.NewInsert().Model(refreshToken).Exec(ctx)
+	_, err := r.db.NewInsert().Model(token).Exec(ctx)
 
 	r.metrics.Database.RecordQuery(ctx, "insert", "refresh_tokens", time.Since(start), err)
 
 	return err
 }
 
-func (r *Repository) cleanTokens(ctx context.Context, id int) error {
+// RotateRefreshToken marks the token with the hash used and stores next in
+// its family. It returns the presented token. If that was used already, the
+// whole family is deleted and errRefreshTokenReused returned along with it.
+// Unknown and expired tokens give sql.ErrNoRows.
+func (r *Repository) RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error) {
+	start := time.Now()
+	current := new(RefreshToken)
+	reused := false
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().
+			Model(current).
+			Where("token_hash = ?", tokenHash).
+			Where("expires_at > ?", time.Now()).
+			For("UPDATE").
+			Scan(ctx); err != nil {
+			return err
+		}
+
+		if current.UsedAt != nil {
+			reused = true
+			_, err := tx.NewDelete().
+				Model((*RefreshToken)(nil)).
+				Where("family_id = ?", current.FamilyID).
+				Exec(ctx)
+			return err
+		}
+
+		if _, err := tx.NewUpdate().
+			Model(current).
+			Set("used_at = CURRENT_TIMESTAMP").
+			WherePK().
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		next.StudentID = current.StudentID
+		next.FamilyID = current.FamilyID
+		next.DeviceLabel = current.DeviceLabel
+		next.SessionStartedAt = current.SessionStartedAt
+		if next.UserAgent == "" {
+			next.UserAgent = current.UserAgent
+		}
+		if next.IPAddress == "" {
+			next.IPAddress = current.IPAddress
+		}
+		_, err := tx.NewInsert().Model(next).Exec(ctx)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "update", "refresh_tokens", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return current, errRefreshTokenReused
+	}
+	return current, nil
+}
+
+// DeleteRefreshTokenFamily removes the token with the hash and every other
+// token of its family (for logout). It returns the family, or "" if there
+// was no such token.
+func (r *Repository) DeleteRefreshTokenFamily(ctx context.Context, tokenHash string) (string, error) {
 	start := time.Now()
+	var familyIDs []string
 	_, err := r.db.NewDelete().
-		Model(&RefreshToken{}).
-		Where("student_id = ?", id).
-		Exec(ctx)
+		Model((*RefreshToken)(nil)).
+		Where("family_id IN (?)", r.db.NewSelect().
+			Model((*RefreshToken)(nil)).
+			Column("family_id").
+			Where("token_hash = ?", tokenHash)).
+		Returning("family_id").
+		Exec(ctx, &familyIDs)
 	r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
-	return err
+
+	if err != nil || len(familyIDs) == 0 {
+		return "", err
+	}
+	return familyIDs[0], nil
 }
 
-// GetRefreshToken retrieves a refresh token by token string
-func (r *Repository) GetRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+// AdoptLegacyRefreshToken replaces the raw token stored before tokens were
+// hashed with its hash. It reports whether there was such a row.
+func (r *Repository) AdoptLegacyRefreshToken(ctx context.Context, token, tokenHash string) (bool, error) {
 	start := time.Now()
-	refreshToken := &RefreshToken{}
-	err := r.db.NewSelect().
-		Model(refreshToken).
+	res, err := r.db.NewUpdate().
+		Model((*RefreshToken)(nil)).
+		Set("token_hash = ?", tokenHash).
+		Set("token = NULL").
 		Where("token = ?", token).
+		Where("token_hash IS NULL").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "refresh_tokens", time.Since(start), err)
+
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListSessions returns the current token of each active session of the
+// student, most recently used first
+func (r *Repository) ListSessions(ctx context.Context, studentID int) ([]RefreshToken, error) {
+	start := time.Now()
+	var tokens []RefreshToken
+	err := r.db.NewSelect().
+		Model(&tokens).
+		Where("student_id = ?", studentID).
+		Where("used_at IS NULL").
 		Where("expires_at > ?", time.Now()).
+		OrderExpr("last_used_at DESC, id DESC").
 		Scan(ctx)
 
 	r.metrics.Database.RecordQuery(ctx, "select", "refresh_tokens", time.Since(start), err)
 
+	return tokens, err
+}
+
+// DeleteSession removes all tokens of a session of the student. It returns
+// sql.ErrNoRows if the student has no such session.
+func (r *Repository) DeleteSession(ctx context.Context, studentID int, familyID string) error {
+	start := time.Now()
+	res, err := r.db.NewDelete().
+		Model((*RefreshToken)(nil)).
+		Where("student_id = ?", studentID).
+		Where("family_id = ?", familyID).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
+
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return refreshToken, nil
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// DeleteRefreshToken removes a refresh token (for logout)
-func (r *Repository) DeleteRefreshToken(ctx context.Context, token string) error {
+// DeleteExpiredTokens removes all expired refresh tokens, used or not, and
+// returns how many it removed (cleanup)
+func (r *Repository) DeleteExpiredTokens(ctx context.Context) (int, error) {
 	start := time.Now()
-	_, err := r.db.NewDelete().
+	res, err := r.db.NewDelete().
 		Model((*RefreshToken)(nil)).
-		Where("token = ?", token).
+		Where("expires_at < ?", time.Now()).
 		Exec(ctx)
+
 	r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
 
-	return err
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
 }
 
-// DeleteExpiredTokens removes all expired refresh tokens (cleanup)
-func (r *Repository) DeleteExpiredTokens(ctx context.Context) error {
+// DeleteAllStudentTokens removes all refresh tokens of a student and
+// returns the sessions they belonged to
+func (r *Repository) DeleteAllStudentTokens(ctx context.Context, studentID int) ([]string, error) {
 	start := time.Now()
+	var familyIDs []string
 	_, err := r.db.NewDelete().
 		Model((*RefreshToken)(nil)).
-		Where("expires_at < ?", time.Now()).
+		Where("student_id = ?", studentID).
+		Returning("family_id").
+		Exec(ctx, &familyIDs)
+	r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(familyIDs)
+	return slices.Compact(familyIDs), nil
+}
+
+// LoginLockedUntil returns the latest lock among the login attempt counters
+// of the scope/subject pairs, or nil if none of them is locked
+func (r *Repository) LoginLockedUntil(ctx context.Context, subjects map[string]string) (*time.Time, error) {
+	start := time.Now()
+	var lockedUntil []time.Time
+	err := r.db.NewSelect().
+		Model((*LoginAttempt)(nil)).
+		Column("locked_until").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			for scope, subject := range subjects {
+				q = q.WhereOr("scope = ? AND subject = ?", scope, subject)
+			}
+			return q
+		}).
+		Where("locked_until > ?", time.Now()).
+		OrderExpr("locked_until DESC").
+		Limit(1).
+		Scan(ctx, &lockedUntil)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "login_attempts", time.Since(start), err)
+
+	if err != nil || len(lockedUntil) == 0 {
+		return nil, err
+	}
+	return &lockedUntil[0], nil
+}
+
+// RecordLoginFailure counts a failed login for the scope and subject and
+// returns the new count. Counts older than window start over. lock decides
+// from the count how long the subject is locked, if at all.
+func (r *Repository) RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration, lock func(failures int) time.Duration) (int, error) {
+	start := time.Now()
+	attempt := &LoginAttempt{Scope: scope, Subject: subject, Failures: 1}
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().
+			Model(attempt).
+			On("CONFLICT (scope, subject) DO UPDATE").
+			Set("failures = CASE WHEN la.last_failure_at < ? THEN 1 ELSE la.failures + 1 END", time.Now().Add(-window)).
+			Set("last_failure_at = CURRENT_TIMESTAMP").
+			Returning("failures").
+			Exec(ctx, &attempt.Failures); err != nil {
+			return err
+		}
+
+		duration := lock(attempt.Failures)
+		if duration <= 0 {
+			return nil
+		}
+		_, err := tx.NewUpdate().
+			Model(attempt).
+			Set("locked_until = ?", time.Now().Add(duration)).
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "upsert", "login_attempts", time.Since(start), err)
+
+	return attempt.Failures, err
+}
+
+// DeleteStaleLoginAttempts deletes the counters without a failure since
+// before and without an active lock, and returns how many it deleted
+func (r *Repository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	res, err := r.db.NewDelete().
+		Model((*LoginAttempt)(nil)).
+		Where("last_failure_at < ?", before).
+		Where("locked_until IS NULL OR locked_until <= ?", time.Now()).
 		Exec(ctx)
 
-	r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
+	r.metrics.Database.RecordQuery(ctx, "delete", "login_attempts", time.Since(start), err)
+
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// ClearLoginFailures resets the counter of the scope and subject. It reports
+// whether there was one.
+func (r *Repository) ClearLoginFailures(ctx context.Context, scope, subject string) (bool, error) {
+	start := time.Now()
+	res, err := r.db.NewDelete().
+		Model((*LoginAttempt)(nil)).
+		Where("scope = ?", scope).
+		Where("subject = ?", subject).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "login_attempts", time.Since(start), err)
+
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CreateAccountToken stores the hash of a new single-use token. Unused tokens
+// of the same purpose issued earlier to the student stop working.
+func (r *Repository) CreateAccountToken(ctx context.Context, token *AccountToken) error {
+	start := time.Now()
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().
+			Model((*AccountToken)(nil)).
+			Where("student_id = ?", token.StudentID).
+			Where("purpose = ?", token.Purpose).
+			Where("used_at IS NULL").
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := tx.NewInsert().Model(token).Exec(ctx)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "account_tokens", time.Since(start), err)
 
 	return err
 }
 
-// DeleteAllStudentTokens removes all refresh tokens for a student
-func (r *Repository) DeleteAllStudentTokens(ctx context.Context, studentID int) error {
+// ConsumeAccountToken marks an unused, unexpired token as used and returns
+// it. It returns sql.ErrNoRows for any other token.
+func (r *Repository) ConsumeAccountToken(ctx context.Context, purpose, tokenHash string) (*AccountToken, error) {
+	start := time.Now()
+	token := new(AccountToken)
+	_, err := r.db.NewUpdate().
+		Model(token).
+		Set("used_at = CURRENT_TIMESTAMP").
+		Where("token_hash = ?", tokenHash).
+		Where("purpose = ?", purpose).
+		Where("used_at IS NULL").
+		Where("expires_at > ?", time.Now()).
+		Returning("*").
+		Exec(ctx, token)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "account_tokens", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	if token.ID == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return token, nil
+}
+
+// DeleteAccountTokens deletes the unused tokens of every purpose sent to the
+// student, so that none of the links in their emails work any more
+func (r *Repository) DeleteAccountTokens(ctx context.Context, studentID int) error {
 	start := time.Now()
 	_, err := r.db.NewDelete().
-		Model((*RefreshToken)(nil)).
+		Model((*AccountToken)(nil)).
+		Where("student_id = ?", studentID).
+		Where("used_at IS NULL").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "account_tokens", time.Since(start), err)
+
+	return err
+}
+
+// GetTOTPCredential returns the TOTP credential of a student, pending or
+// confirmed, or sql.ErrNoRows
+func (r *Repository) GetTOTPCredential(ctx context.Context, studentID int) (*TOTPCredential, error) {
+	start := time.Now()
+	credential := new(TOTPCredential)
+	err := r.db.NewSelect().
+		Model(credential).
+		Where("student_id = ?", studentID).
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "totp_credentials", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// SavePendingTOTPCredential stores a new unconfirmed credential, replacing
+// an earlier pending one. A confirmed credential is left alone and
+// sql.ErrNoRows is returned.
+func (r *Repository) SavePendingTOTPCredential(ctx context.Context, credential *TOTPCredential) error {
+	start := time.Now()
+	res, err := r.db.NewInsert().
+		Model(credential).
+		On("CONFLICT (student_id) DO UPDATE").
+		Set("secret = EXCLUDED.secret").
+		Set("last_used_step = 0").
+		Set("created_at = CURRENT_TIMESTAMP").
+		Where("tc.confirmed_at IS NULL").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "upsert", "totp_credentials", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of step was used. It fails with
+// sql.ErrNoRows if the step or a later one was used already, so that
+// concurrent requests cannot both use the same code. With confirm set a
+// pending credential is confirmed at the same time.
+func (r *Repository) UseTOTPStep(ctx context.Context, studentID int, step int64, confirm bool) error {
+	start := time.Now()
+	q := r.db.NewUpdate().
+		Model((*TOTPCredential)(nil)).
+		Set("last_used_step = ?", step).
+		Where("student_id = ?", studentID).
+		Where("last_used_step < ?", step)
+	if confirm {
+		q = q.Set("confirmed_at = CURRENT_TIMESTAMP").Where("confirmed_at IS NULL")
+	} else {
+		q = q.Where("confirmed_at IS NOT NULL")
+	}
+	res, err := q.Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "totp_credentials", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteMFA removes the TOTP credential and the recovery codes of a student
+func (r *Repository) DeleteMFA(ctx context.Context, studentID int) error {
+	start := time.Now()
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().
+			Model((*RecoveryCode)(nil)).
+			Where("student_id = ?", studentID).
+			Exec(ctx); err != nil {
+			return err
+		}
+		_, err := tx.NewDelete().
+			Model((*TOTPCredential)(nil)).
+			Where("student_id = ?", studentID).
+			Exec(ctx)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "totp_credentials", time.Since(start), err)
+
+	return err
+}
+
+// ReplaceRecoveryCodes stores the hashes of new recovery codes; all earlier
+// codes of the student stop working
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, studentID int, codeHashes []string) error {
+	start := time.Now()
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().
+			Model((*RecoveryCode)(nil)).
+			Where("student_id = ?", studentID).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{StudentID: studentID, CodeHash: hash}
+		}
+		_, err := tx.NewInsert().Model(&codes).Exec(ctx)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "recovery_codes", time.Since(start), err)
+
+	return err
+}
+
+// UseRecoveryCode marks an unused recovery code of the student as used. It
+// returns sql.ErrNoRows for any other code.
+func (r *Repository) UseRecoveryCode(ctx context.Context, studentID int, codeHash string) error {
+	start := time.Now()
+	res, err := r.db.NewUpdate().
+		Model((*RecoveryCode)(nil)).
+		Set("used_at = CURRENT_TIMESTAMP").
 		Where("student_id = ?", studentID).
+		Where("code_hash = ?", codeHash).
+		Where("used_at IS NULL").
 		Exec(ctx)
-	if r.metrics != nil {
-		r.metrics.Database.RecordQuery(ctx, "delete", "refresh_tokens", time.Since(start), err)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "recovery_codes", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a student has
+func (r *Repository) CountRecoveryCodes(ctx context.Context, studentID int) (int, error) {
+	start := time.Now()
+	count, err := r.db.NewSelect().
+		Model((*RecoveryCode)(nil)).
+		Where("student_id = ?", studentID).
+		Where("used_at IS NULL").
+		Count(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "recovery_codes", time.Since(start), err)
+
+	return count, err
+}
+
+// GetFederatedIdentity returns the link of a provider account, or
+// sql.ErrNoRows
+func (r *Repository) GetFederatedIdentity(ctx context.Context, issuer, subject string) (*FederatedIdentity, error) {
+	start := time.Now()
+	identity := new(FederatedIdentity)
+	err := r.db.NewSelect().
+		Model(identity).
+		Where("issuer = ?", issuer).
+		Where("subject = ?", subject).
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "federated_identities", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
 	}
+	return identity, nil
+}
+
+// LinkFederatedIdentity links a provider account to a student, replacing an
+// earlier link of the same account
+func (r *Repository) LinkFederatedIdentity(ctx context.Context, identity *FederatedIdentity) error {
+	start := time.Now()
+	_, err := r.db.NewInsert().
+		Model(identity).
+		On("CONFLICT (issuer, subject) DO UPDATE").
+		Set("student_id = EXCLUDED.student_id").
+		Set("created_at = CURRENT_TIMESTAMP").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "upsert", "federated_identities", time.Since(start), err)
+
+	return err
+}
+
+// CreateAPIKey stores a new API key
+func (r *Repository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	start := time.Now()
+	_, err := r.db.NewInsert().Model(key).Returning("*").Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "api_keys", time.Since(start), err)
+
+	return err
+}
+
+// ListAPIKeys returns all API keys, revoked and expired ones included,
+// oldest first
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	start := time.Now()
+	var keys []APIKey
+	err := r.db.NewSelect().
+		Model(&keys).
+		Order("id ASC").
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "api_keys", time.Since(start), err)
+
+	return keys, err
+}
+
+// GetActiveAPIKey returns the unrevoked, unexpired API key with the hash, or
+// sql.ErrNoRows
+func (r *Repository) GetActiveAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	start := time.Now()
+	key := new(APIKey)
+	err := r.db.NewSelect().
+		Model(key).
+		Where("key_hash = ?", keyHash).
+		Where("revoked_at IS NULL").
+		Where("expires_at > ?", time.Now()).
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// TouchAPIKey records that an API key was just used
+func (r *Repository) TouchAPIKey(ctx context.Context, id int64) error {
+	start := time.Now()
+	_, err := r.db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("last_used_at = CURRENT_TIMESTAMP").
+		Where("id = ?", id).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "api_keys", time.Since(start), err)
+
+	return err
+}
+
+// RevokeAPIKey revokes an API key. It returns sql.ErrNoRows if there is no
+// such key or it was revoked already.
+func (r *Repository) RevokeAPIKey(ctx context.Context, id int64) error {
+	start := time.Now()
+	res, err := r.db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("revoked_at = CURRENT_TIMESTAMP").
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateRevocation stores a revocation
+func (r *Repository) CreateRevocation(ctx context.Context, revocation *Revocation) error {
+	start := time.Now()
+	_, err := r.db.NewInsert().
+		Model(revocation).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "revoked_tokens", time.Since(start), err)
+
+	return err
+}
+
+// ListRevocations returns the revocations that still cover unexpired tokens
+func (r *Repository) ListRevocations(ctx context.Context) ([]Revocation, error) {
+	start := time.Now()
+	var revocations []Revocation
+	err := r.db.NewSelect().
+		Model(&revocations).
+		Where("expires_at > ?", time.Now()).
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "revoked_tokens", time.Since(start), err)
+
+	return revocations, err
+}
+
+// DeleteExpiredRevocations removes the revocations whose tokens have all
+// expired (cleanup)
+func (r *Repository) DeleteExpiredRevocations(ctx context.Context) error {
+	start := time.Now()
+	_, err := r.db.NewDelete().
+		Model((*Revocation)(nil)).
+		Where("expires_at <= ?", time.Now()).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "revoked_tokens", time.Since(start), err)
+
+	return err
+}
+
+// PurgeStudents is the student.PurgeHook of auth. It deletes the tokens,
+// second factors and linked provider accounts of purged students and clears
+// them as the creator of API keys.
+func (r *Repository) PurgeStudents(ctx context.Context, db bun.IDB, students []student.Student) error {
+	ids := make([]int, len(students))
+	for i := range students {
+		ids[i] = students[i].ID
+	}
+
+	for _, table := range []string{"refresh_tokens", "account_tokens", "totp_credentials", "recovery_codes", "federated_identities"} {
+		start := time.Now()
+		_, err := db.NewDelete().
+			TableExpr(table).
+			Where("student_id IN (?)", bun.In(ids)).
+			Exec(ctx)
+
+		r.metrics.Database.RecordQuery(ctx, "delete", table, time.Since(start), err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	_, err := db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("created_by = NULL").
+		Where("created_by IN (?)", bun.In(ids)).
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "api_keys", time.Since(start), err)
+
 	return err
 }
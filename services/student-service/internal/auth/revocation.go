@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultRevocationSubject is the NATS subject revocations are published on
+const DefaultRevocationSubject = "student-service.auth.revocations"
+
+const defaultRevocationResync = time.Minute
+
+// RevocationChecker tells AuthMiddleware whether an access token was revoked
+type RevocationChecker interface {
+	IsRevoked(claims *Claims) bool
+}
+
+// RevocationBus carries revocations between the instances of the service.
+// messaging.Producer implements it over NATS.
+type RevocationBus interface {
+	PublishTo(ctx context.Context, subject string, value interface{}) error
+	Subscribe(subject string, handler func(ctx context.Context, data []byte)) (func() error, error)
+}
+
+// RevocationConfig decides how revocations reach the other instances
+type RevocationConfig struct {
+	// Bus tells the other instances about a revocation right away. Without
+	// it they only learn about it on their next resync.
+	Bus RevocationBus
+	// Subject is the subject on the bus, DefaultRevocationSubject if empty
+	Subject string
+	// ResyncInterval is how often the list is reloaded from the database, a
+	// minute if zero
+	ResyncInterval time.Duration
+}
+
+type revocationKey struct {
+	kind, subject string
+}
+
+// revocationList holds the revocations of unexpired tokens in memory, so
+// that checking a token needs no query. The database has the full list;
+// revocations made by other instances arrive over the bus and with every
+// resync. Revocations are never taken back, so entries are only merged and
+// dropped once expired.
+type revocationList struct {
+	repo   *Repository
+	config RevocationConfig
+	logger *slog.Logger
+
+	mu sync.RWMutex
+	// entries maps revoked subjects to when their revocation expires
+	entries map[revocationKey]time.Time
+}
+
+func newRevocationList(repo *Repository, config RevocationConfig, logger *slog.Logger) *revocationList {
+	if config.Subject == "" {
+		config.Subject = DefaultRevocationSubject
+	}
+	if config.ResyncInterval <= 0 {
+		config.ResyncInterval = defaultRevocationResync
+	}
+	return &revocationList{
+		repo:    repo,
+		config:  config,
+		logger:  logger,
+		entries: map[revocationKey]time.Time{},
+	}
+}
+
+// revoke stores a revocation and publishes it. A zero ExpiresAt is taken to
+// be when the last access token issued up to RevokedAt expires.
+func (l *revocationList) revoke(ctx context.Context, r *Revocation) error {
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = time.Now()
+	}
+	if r.ExpiresAt.IsZero() {
+		r.ExpiresAt = r.RevokedAt.Add(accessTokenTTL)
+	}
+	if err := l.repo.CreateRevocation(ctx, r); err != nil {
+		return err
+	}
+	l.add(*r)
+
+	if l.config.Bus != nil {
+		// The other instances catch up on their next resync
+		if err := l.config.Bus.PublishTo(ctx, l.config.Subject, r); err != nil {
+			l.logger.WarnContext(ctx, "failed to publish revocation", "kind", r.Kind, "error", err)
+		}
+	}
+	return nil
+}
+
+// add merges a revocation into the list
+func (l *revocationList) add(r Revocation) {
+	key := revocationKey{r.Kind, r.Subject}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r.ExpiresAt.After(l.entries[key]) {
+		l.entries[key] = r.ExpiresAt
+	}
+}
+
+// isRevoked reports whether a revocation covers the token
+func (l *revocationList) isRevoked(claims *Claims) bool {
+	now := time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	active := func(kind, subject string) bool {
+		expiresAt, ok := l.entries[revocationKey{kind, subject}]
+		return subject != "" && ok && now.Before(expiresAt)
+	}
+	return active(RevokeToken, claims.ID) || active(RevokeSession, claims.SessionID)
+}
+
+// reload merges the revocations in the database into the list, drops the
+// expired ones and deletes them from the database
+func (l *revocationList) reload(ctx context.Context) error {
+	revocations, err := l.repo.ListRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range revocations {
+		l.add(r)
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	for key, expiresAt := range l.entries {
+		if !now.Before(expiresAt) {
+			delete(l.entries, key)
+		}
+	}
+	l.mu.Unlock()
+
+	return l.repo.DeleteExpiredRevocations(ctx)
+}
+
+// run follows the bus and resyncs with the database until ctx is done
+func (l *revocationList) run(ctx context.Context) {
+	if l.config.Bus != nil {
+		unsubscribe, err := l.config.Bus.Subscribe(l.config.Subject, l.receive)
+		if err != nil {
+			l.logger.ErrorContext(ctx, "failed to subscribe to revocations, relying on resync", "error", err)
+		} else {
+			defer unsubscribe()
+		}
+	}
+
+	ticker := time.NewTicker(l.config.ResyncInterval)
+	defer ticker.Stop()
+
+	l.logger.InfoContext(ctx, "starting revocation sync", "interval", l.config.ResyncInterval.String())
+
+	for {
+		// The first reload also covers what was published before subscribing
+		if err := l.reload(ctx); err != nil {
+			l.logger.ErrorContext(ctx, "failed to reload revocations", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			l.logger.InfoContext(ctx, "stopping revocation sync")
+			return
+		}
+	}
+}
+
+// receive handles a revocation published by an instance, this one included
+func (l *revocationList) receive(ctx context.Context, data []byte) {
+	var r Revocation
+	if err := json.Unmarshal(data, &r); err != nil || r.Kind == "" || r.Subject == "" {
+		l.logger.WarnContext(ctx, "ignoring malformed revocation message", "error", err)
+		return
+	}
+	l.add(r)
+}
+
+// IsRevoked reports whether the access token was revoked by logout, a
+// password change, suspension of the account or an admin
+func (s *Service) IsRevoked(claims *Claims) bool {
+	return s.revocations.isRevoked(claims)
+}
+
+// SyncRevocations keeps the revocations of this instance up to date with
+// the other instances until ctx is done
+func (s *Service) SyncRevocations(ctx context.Context) {
+	s.revocations.run(ctx)
+}
+
+// revoke records a revocation of the tokens of a session or of one token. A
+// zero expiresAt covers tokens issued until now.
+func (s *Service) revoke(ctx context.Context, kind, subject, reason string, expiresAt time.Time) error {
+	if err := s.revocations.revoke(ctx, &Revocation{
+		Kind:      kind,
+		Subject:   subject,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "access tokens revoked", "kind", kind, "subject", subject, "reason", reason)
+	return nil
+}
+
+// revokeSessions revokes the access tokens of sessions whose refresh tokens
+// were just deleted
+func (s *Service) revokeSessions(ctx context.Context, sessionIDs []string, reason string) error {
+	for _, sessionID := range sessionIDs {
+		if err := s.revoke(ctx, RevokeSession, sessionID, reason, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
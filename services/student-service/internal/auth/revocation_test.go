@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationList(t *testing.T) {
+	list := newRevocationList(nil, RevocationConfig{}, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	now := time.Now()
+	claims := func(sessionID, tokenID string) *Claims {
+		return &Claims{StudentID: 1, SessionID: sessionID, RegisteredClaims: jwt.RegisteredClaims{ID: tokenID}}
+	}
+
+	list.add(Revocation{Kind: RevokeToken, Subject: "jti-1", RevokedAt: now, ExpiresAt: now.Add(time.Minute)})
+	list.add(Revocation{Kind: RevokeSession, Subject: "session-1", RevokedAt: now, ExpiresAt: now.Add(time.Minute)})
+	list.add(Revocation{Kind: RevokeSession, Subject: "expired", RevokedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)})
+
+	assert.True(t, list.isRevoked(claims("session-2", "jti-1")))
+	assert.True(t, list.isRevoked(claims("session-1", "jti-2")))
+	assert.False(t, list.isRevoked(claims("session-2", "jti-2")))
+	assert.False(t, list.isRevoked(claims("expired", "jti-2")))
+	// Tokens without a session or ID are not matched by empty subjects
+	assert.False(t, list.isRevoked(claims("", "")))
+
+	// The list keeps the latest expiry of a subject
+	list.add(Revocation{Kind: RevokeSession, Subject: "expired", RevokedAt: now, ExpiresAt: now.Add(time.Minute)})
+	list.add(Revocation{Kind: RevokeSession, Subject: "expired", RevokedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)})
+	assert.True(t, list.isRevoked(claims("expired", "")))
+}
+
+func TestRevocationListReceive(t *testing.T) {
+	list := newRevocationList(nil, RevocationConfig{}, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	assert.Equal(t, DefaultRevocationSubject, list.config.Subject)
+	assert.Equal(t, time.Minute, list.config.ResyncInterval)
+
+	data, err := json.Marshal(&Revocation{
+		Kind:      RevokeSession,
+		Subject:   "session-1",
+		Reason:    ReasonLogout,
+		RevokedAt: time.Now(),
+		ExpiresAt: time.Now().Add(accessTokenTTL),
+	})
+	require.NoError(t, err)
+	list.receive(context.Background(), data)
+	list.receive(context.Background(), []byte("not json"))
+	list.receive(context.Background(), []byte(`{"kind":"session"}`))
+
+	assert.True(t, list.isRevoked(&Claims{SessionID: "session-1"}))
+	assert.Len(t, list.entries, 1)
+}
+
+// revocationCheckerFunc lets a function stand in for the service
+type revocationCheckerFunc func(claims *Claims) bool
+
+func (f revocationCheckerFunc) IsRevoked(claims *Claims) bool {
+	return f(claims)
+}
+
+func TestAuthMiddlewareRevocations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := NewHMACKeySet([]byte("test-secret"))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	revoked, err := keys.GenerateAccessToken(Claims{StudentID: 1, SessionID: "revoked"})
+	require.NoError(t, err)
+	valid, err := keys.GenerateAccessToken(Claims{StudentID: 1, SessionID: "valid"})
+	require.NoError(t, err)
+
+	// Every token gets its own ID
+	revokedClaims, err := keys.ValidateAccessToken(revoked)
+	require.NoError(t, err)
+	validClaims, err := keys.ValidateAccessToken(valid)
+	require.NoError(t, err)
+	assert.Len(t, revokedClaims.ID, 32)
+	assert.NotEqual(t, revokedClaims.ID, validClaims.ID)
+
+	checker := revocationCheckerFunc(func(claims *Claims) bool {
+		return claims.SessionID == "revoked"
+	})
+	router := gin.New()
+	router.GET("/", AuthMiddleware(keys, checker, nil, logger), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	serve := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(revoked))
+	assert.Equal(t, http.StatusOK, serve(valid))
+}
@@ -2,34 +2,101 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"log/slog"
+	"sync"
 	"time"
 
+	"student-service/internal/mail"
+	"student-service/internal/password"
 	"student-service/internal/student"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials  = errors.New("invalid email or password")
 	ErrEmailExists         = errors.New("email already exists")
 	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrEmailNotVerified    = errors.New("email address is not verified")
+	ErrAccountSuspended    = errors.New("account is suspended")
 )
 
+// Config holds the auth settings that are not secrets
+type Config struct {
+	// AppURL is the base URL of the web app; links in emails point there
+	AppURL string
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL time.Duration
+	// EmailVerificationTTL is how long an email verification link stays valid
+	EmailVerificationTTL time.Duration
+	// InvitationTTL is how long the link in an invitation stays valid
+	InvitationTTL time.Duration
+	// UnverifiedLogin decides what students with an unverified email get. The
+	// zero value lets them in like UnverifiedAllow.
+	UnverifiedLogin UnverifiedPolicy
+	// TokenSources is the order AuthMiddleware looks for the access token
+	// in, DefaultTokenSources if empty
+	TokenSources []TokenSource
+	// Throttle limits failed logins per account and client IP. Zero fields
+	// take their DefaultThrottleConfig value.
+	Throttle ThrottleConfig
+	MFA      MFAConfig
+	OIDC     OIDCConfig
+	// PasswordHasher hashes and verifies passwords, Argon2id with
+	// password.DefaultParams if nil
+	PasswordHasher password.Hasher
+	// PasswordPolicy applies to every password a student chooses
+	PasswordPolicy password.Policy
+	Revocation     RevocationConfig
+}
+
 type Service struct {
 	authRepo    *Repository
 	studentRepo student.Repository
+	mailer      mail.Mailer
+	keys        *KeySet
+	config      Config
+	logger      *slog.Logger
+	hasher      password.Hasher
+	revocations *revocationList
+	// dummyHash is what a login with an unknown email is verified against
+	dummyHash func() string
+	// oidc is nil unless an OpenID Connect provider is configured
+	oidc *oidcProvider
 }
 
-func NewService(authRepo *Repository, studentRepo student.Repository) *Service {
+func NewService(authRepo *Repository, studentRepo student.Repository, mailer mail.Mailer, keys *KeySet, config Config, logger *slog.Logger) *Service {
+	hasher := config.PasswordHasher
+	if hasher == nil {
+		// The default parameters are valid
+		hasher, _ = password.NewArgon2id(password.DefaultParams)
+	}
 	return &Service{
 		authRepo:    authRepo,
+		keys:        keys,
 		studentRepo: studentRepo,
+		mailer:      mailer,
+		config:      config,
+		logger:      logger,
+		hasher:      hasher,
+		revocations: newRevocationList(authRepo, config.Revocation, logger),
+		dummyHash: sync.OnceValue(func() string {
+			// Hashed with the same parameters as real passwords so that
+			// verifying it costs the same
+			hash, err := hasher.Hash(rand.Text())
+			if err != nil {
+				logger.Error("failed to create the dummy password hash", "error", err)
+			}
+			return hash
+		}),
+		oidc: newOIDCProvider(config.OIDC),
 	}
 }
 
 // Register creates a new student account
-func (s *Service) Register(ctx context.Context, req RegisterRequest) (*AuthResponse, error) {
+func (s *Service) Register(ctx context.Context, req RegisterRequest, client ClientInfo) (*AuthResponse, error) {
 	// Check if email exists
 	existingStudent, _ := s.studentRepo.GetByEmail(ctx, req.Email)
 	if existingStudent != nil {
@@ -37,7 +104,10 @@ func (s *Service) Register(ctx context.Context, req RegisterRequest) (*AuthRespo
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err := s.config.PasswordPolicy.Check(req.Password); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -47,89 +117,278 @@ func (s *Service) Register(ctx context.Context, req RegisterRequest) (*AuthRespo
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Email:     req.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		Major:     req.Major,
 		Year:      req.Year,
 	}
 
 	createdStudent, err := s.studentRepo.Create(ctx, newStudent)
 	if err != nil {
+		if errors.Is(err, student.ErrEmailTaken) {
+			return nil, ErrEmailExists
+		}
 		return nil, err
 	}
 
+	// The account exists either way, a failed email can be resent
+	if err := s.sendVerification(ctx, createdStudent); err != nil {
+		s.logger.ErrorContext(ctx, "failed to send verification email", "student_id", createdStudent.ID, "error", err)
+	}
+
+	if s.config.UnverifiedLogin == UnverifiedBlock {
+		return &AuthResponse{Student: createdStudent}, nil
+	}
+
 	// Generate tokens
-	return s.generateTokenPair(ctx, createdStudent)
+	return s.generateTokenPair(ctx, createdStudent, client)
 }
 
-// Login authenticates a student and returns tokens
-func (s *Service) Login(ctx context.Context, req LoginRequest) (*AuthResponse, error) {
-	// Find student by email
+// Login authenticates a student and returns tokens. Students with 2FA get
+// an MFAChallenge instead, to be completed with CompleteMFALogin.
+func (s *Service) Login(ctx context.Context, req LoginRequest, client ClientInfo) (*AuthResponse, *MFAChallenge, error) {
+	if err := s.checkLoginThrottle(ctx, req.Email, client.IPAddress); err != nil {
+		return nil, nil, err
+	}
+
+	// Find student by email and verify password
 	stud, err := s.studentRepo.GetByEmail(ctx, req.Email)
-	if err != nil {
-		return nil, ErrInvalidCredentials
+	found := err == nil
+	if err != nil && !errors.Is(err, student.ErrStudentNotFound) {
+		return nil, nil, err
+	}
+	var ok, needsRehash bool
+	if !found || stud.Password == "" {
+		// Unknown emails and accounts without a password take as long to
+		// refuse as a wrong password, so the timing tells nothing about which
+		// accounts exist
+		s.hasher.Verify(req.Password, s.dummyHash())
+	} else {
+		ok, needsRehash, err = s.hasher.Verify(req.Password, stud.Password)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "stored password hash is unusable", "student_id", stud.ID, "error", err)
+		}
+	}
+	if !ok {
+		if err := s.recordLoginFailure(ctx, req.Email, client.IPAddress); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		s.rehashPassword(ctx, stud, req.Password)
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(stud.Password), []byte(req.Password)); err != nil {
-		return nil, ErrInvalidCredentials
+	if stud.Suspended() {
+		return nil, nil, ErrAccountSuspended
+	}
+	if !s.mayLogin(stud) {
+		return nil, nil, ErrEmailNotVerified
+	}
+
+	// The failed attempts are only forgiven once the second factor is in too
+	mfaEnabled, err := s.mfaEnabled(ctx, stud.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mfaEnabled {
+		challenge, err := s.mfaChallenge(stud, client)
+		return nil, challenge, err
+	}
+	if err := s.clearAccountLock(ctx, req.Email); err != nil {
+		return nil, nil, err
 	}
 
 	// Generate tokens
-	return s.generateTokenPair(ctx, stud)
+	resp, err := s.generateTokenPair(ctx, stud, client)
+	return resp, nil, err
 }
 
-// RefreshAccessToken generates a new access token using refresh token
-func (s *Service) RefreshAccessToken(ctx context.Context, refreshTokenString string) (*AuthResponse, error) {
-	// Validate refresh token
-	refreshToken, err := s.authRepo.GetRefreshToken(ctx, refreshTokenString)
+// RefreshAccessToken exchanges a refresh token for a new token pair. The
+// presented token stops working. Presenting it again ends the session of
+// whoever holds the newer token too, since one of them is not the student.
+func (s *Service) RefreshAccessToken(ctx context.Context, refreshTokenString string, client ClientInfo) (*AuthResponse, error) {
+	presentedHash, err := s.refreshTokenHash(ctx, refreshTokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := GenerateRefreshToken()
 	if err != nil {
+		return nil, err
+	}
+	nextHash, err := hashRefreshToken(next)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := s.authRepo.RotateRefreshToken(ctx, presentedHash, &RefreshToken{
+		TokenHash: nextHash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		s.logger.WarnContext(ctx, "security event: refresh token reused, session revoked",
+			"event", "refresh_token_reuse", "student_id", rotated.StudentID, "family_id", rotated.FamilyID)
+		if err := s.revokeSessions(ctx, []string{rotated.FamilyID}, ReasonRefreshTokenReuse); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidRefreshToken
 	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Get student
-	stud, err := s.studentRepo.GetByID(ctx, refreshToken.StudentID)
+	stud, err := s.studentRepo.GetByID(ctx, rotated.StudentID)
 	if err != nil {
 		return nil, ErrInvalidRefreshToken
 	}
+	if stud.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	if !s.mayLogin(stud) {
+		return nil, ErrEmailNotVerified
+	}
 
-	// Generate new token pair
-	return s.generateTokenPair(ctx, stud)
+	mfaPending, err := s.mfaEnrollmentPending(ctx, stud)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.accessToken(stud, rotated.FamilyID, mfaPending)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{
+		AccessToken:           accessToken,
+		RefreshToken:          next,
+		Student:               stud,
+		MFAEnrollmentRequired: mfaPending,
+	}, nil
 }
 
-// Logout invalidates refresh token
-func (s *Service) Logout(ctx context.Context, refreshTokenString string) error {
-	return s.authRepo.DeleteRefreshToken(ctx, refreshTokenString)
+// Logout ends the session of the refresh token and revokes its access
+// tokens. accessToken is the access token the request came with, if any; it
+// is revoked too when it belongs to another session or none.
+func (s *Service) Logout(ctx context.Context, refreshTokenString string, accessToken *Claims) error {
+	tokenHash, err := s.refreshTokenHash(ctx, refreshTokenString)
+	if err != nil {
+		return err
+	}
+	sessionID, err := s.authRepo.DeleteRefreshTokenFamily(ctx, tokenHash)
+	if err != nil {
+		return err
+	}
+	if sessionID != "" {
+		if err := s.revoke(ctx, RevokeSession, sessionID, ReasonLogout, time.Time{}); err != nil {
+			return err
+		}
+	}
+	if accessToken == nil || accessToken.ID == "" || (sessionID != "" && accessToken.SessionID == sessionID) {
+		return nil
+	}
+	var expiresAt time.Time
+	if accessToken.ExpiresAt != nil {
+		expiresAt = accessToken.ExpiresAt.Time
+	}
+	return s.revoke(ctx, RevokeToken, accessToken.ID, ReasonLogout, expiresAt)
 }
 
-// LogoutAll invalidates all refresh tokens for a student
-func (s *Service) LogoutAll(ctx context.Context, studentID int) error {
-	return s.authRepo.DeleteAllStudentTokens(ctx, studentID)
+// refreshTokenHash hashes a presented refresh token. A token stored before
+// tokens were hashed is converted first, so it keeps working.
+func (s *Service) refreshTokenHash(ctx context.Context, token string) (string, error) {
+	tokenHash, err := hashRefreshToken(token)
+	if err != nil {
+		return "", err
+	}
+	adopted, err := s.authRepo.AdoptLegacyRefreshToken(ctx, token, tokenHash)
+	if err != nil {
+		return "", err
+	}
+	if adopted {
+		s.logger.InfoContext(ctx, "hashed legacy refresh token")
+	}
+	return tokenHash, nil
 }
 
-// generateTokenPair creates access and refresh tokens
-func (s *Service) generateTokenPair(ctx context.Context, stud *student.Student) (*AuthResponse, error) {
-	accessToken, err := GenerateAccessToken(stud.ID, stud.Email)
+// refreshTokenTTL is how long a refresh token stays valid if it is not used
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// generateTokenPair creates access and refresh tokens for a new session
+func (s *Service) generateTokenPair(ctx context.Context, stud *student.Student, client ClientInfo) (*AuthResponse, error) {
+	if stud.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	familyID, err := newRandomID()
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := GenerateRefreshToken()
+	mfaPending, err := s.mfaEnrollmentPending(ctx, stud)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.accessToken(stud, familyID, mfaPending)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.authRepo.cleanTokens(ctx, stud.ID); err != nil {
+	refreshToken, err := GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshTokenHash, err := hashRefreshToken(refreshToken)
+	if err != nil {
 		return nil, err
 	}
 
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := s.authRepo.CreateRefreshToken(ctx, stud.ID, refreshToken, expiresAt); err != nil {
+	label := client.DeviceLabel
+	if label == "" {
+		label = describeUserAgent(client.UserAgent)
+	}
+	if err := s.authRepo.CreateRefreshToken(ctx, &RefreshToken{
+		StudentID:   stud.ID,
+		FamilyID:    familyID,
+		TokenHash:   refreshTokenHash,
+		ExpiresAt:   time.Now().Add(refreshTokenTTL),
+		UserAgent:   client.UserAgent,
+		IPAddress:   client.IPAddress,
+		DeviceLabel: label,
+	}); err != nil {
 		return nil, err
 	}
 
 	return &AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		Student:      stud,
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		Student:               stud,
+		MFAEnrollmentRequired: mfaPending,
 	}, nil
 }
+
+// accessToken issues an access token for the session, restricted if the
+// unverified login policy says so or if the student still has to enroll in
+// 2FA
+func (s *Service) accessToken(stud *student.Student, sessionID string, mfaPending bool) (string, error) {
+	return s.keys.GenerateAccessToken(Claims{
+		StudentID:  stud.ID,
+		Email:      stud.Email,
+		Role:       stud.Role,
+		Restricted: (stud.VerifiedAt == nil && s.config.UnverifiedLogin == UnverifiedRestrict) || mfaPending,
+		SessionID:  sessionID,
+	})
+}
+
+// newRandomID returns a random ID for a new refresh token family or access
+// token
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ListSessions returns the active sessions of the student. currentSessionID
+// is the session of the caller, which is marked as current.
+func (s *Service) ListSessions(ctx context.Context, studentID int, currentSessionID string) ([]Session, error) {
+	tokens, err := s.authRepo.ListSessions(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = Session{
+			ID:          token.FamilyID,
+			DeviceLabel: token.DeviceLabel,
+			UserAgent:   token.UserAgent,
+			IPAddress:   token.IPAddress,
+			CreatedAt:   token.SessionStartedAt,
+			LastUsedAt:  token.LastUsedAt,
+			ExpiresAt:   token.ExpiresAt,
+			Current:     token.FamilyID == currentSessionID,
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs the student out of one of their sessions, including
+// the access tokens already issued for it
+func (s *Service) RevokeSession(ctx context.Context, studentID int, sessionID string) error {
+	err := s.authRepo.DeleteSession(ctx, studentID, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.revoke(ctx, RevokeSession, sessionID, ReasonSessionRevoked, time.Time{}); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "session revoked", "student_id", studentID, "session_id", sessionID)
+	return nil
+}
+
+// LogoutAll invalidates all refresh tokens for a student and revokes the
+// access tokens issued so far
+func (s *Service) LogoutAll(ctx context.Context, studentID int) error {
+	if err := s.signOut(ctx, studentID, ReasonLogoutAll); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "logged out of all sessions", "student_id", studentID)
+	return nil
+}
+
+// PruneRefreshTokens deletes the expired refresh tokens and returns how many
+// it deleted. Rotation keeps used tokens to detect their reuse, so without it
+// every login and refresh would leave a row behind for good.
+func (s *Service) PruneRefreshTokens(ctx context.Context) (int, error) {
+	return s.authRepo.DeleteExpiredTokens(ctx)
+}
+
+// userAgentBrowsers and userAgentSystems are checked in order, so more
+// specific tokens come first (Edge and Opera also claim to be Chrome, and
+// Chrome claims to be Safari)
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeUserAgent turns a User-Agent header into a label such as
+// "Firefox on Linux" for sessions the student did not name
+func describeUserAgent(userAgent string) string {
+	var browser, system string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, sys := range userAgentSystems {
+		if strings.Contains(userAgent, sys.token) {
+			system = sys.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
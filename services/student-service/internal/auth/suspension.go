@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrSuspendSelf = errors.New("you cannot suspend your own account")
+
+// SuspendAccount stops a student from logging in, ends their sessions and
+// revokes their access tokens. Suspending a suspended account does nothing.
+func (s *Service) SuspendAccount(ctx context.Context, studentID int) error {
+	actor, _ := GetStudentID(ctx)
+	if actor == studentID {
+		return ErrSuspendSelf
+	}
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	if stud.Suspended() {
+		return nil
+	}
+
+	now := time.Now()
+	stud.SuspendedAt = &now
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "suspended_at"); err != nil {
+		return err
+	}
+	if err := s.signOut(ctx, studentID, ReasonSuspended); err != nil {
+		return err
+	}
+
+	s.logger.WarnContext(ctx, "security event: account suspended",
+		"event", "account_suspended", "student_id", studentID, "actor_id", actor)
+	return nil
+}
+
+// UnsuspendAccount lets a suspended student log in again. Their old tokens
+// stay revoked.
+func (s *Service) UnsuspendAccount(ctx context.Context, studentID int) error {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	if !stud.Suspended() {
+		return nil
+	}
+
+	stud.SuspendedAt = nil
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "suspended_at"); err != nil {
+		return err
+	}
+
+	actor, _ := GetStudentID(ctx)
+	s.logger.InfoContext(ctx, "account unsuspended", "student_id", studentID, "actor_id", actor)
+	return nil
+}
+
+// ForceLogout ends every session of a student and revokes their access
+// tokens, for an account that may be compromised. The student can log in
+// again right away.
+func (s *Service) ForceLogout(ctx context.Context, studentID int) error {
+	if _, err := s.studentRepo.GetByID(ctx, studentID); err != nil {
+		return err
+	}
+	if err := s.signOut(ctx, studentID, ReasonForceLogout); err != nil {
+		return err
+	}
+
+	actor, _ := GetStudentID(ctx)
+	s.logger.WarnContext(ctx, "security event: student signed out by an admin",
+		"event", "force_logout", "student_id", studentID, "actor_id", actor)
+	return nil
+}
+
+// signOut deletes the refresh tokens of a student and revokes the access
+// tokens of all their sessions. Every access token belongs to a session
+// whose refresh tokens outlive it, so none is missed.
+func (s *Service) signOut(ctx context.Context, studentID int, reason string) error {
+	sessionIDs, err := s.authRepo.DeleteAllStudentTokens(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	return s.revokeSessions(ctx, sessionIDs, reason)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrLoginThrottled = errors.New("too many failed login attempts, try again later")
+
+// ThrottledError is returned by Login while the account or the client IP is
+// locked. It matches ErrLoginThrottled with errors.Is.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string { return ErrLoginThrottled.Error() }
+
+func (e *ThrottledError) Unwrap() error { return ErrLoginThrottled }
+
+// ThrottleConfig controls login throttling. Every subject (an account or a
+// client IP) gets a number of free failed attempts. Each failure after that
+// locks the subject for BaseDelay, doubled per further failure up to
+// MaxLockout. Counters start over after Window without failures.
+type ThrottleConfig struct {
+	AccountAttempts int
+	IPAttempts      int
+	BaseDelay       time.Duration
+	MaxLockout      time.Duration
+	Window          time.Duration
+}
+
+// DefaultThrottleConfig is used for the zero ThrottleConfig fields
+var DefaultThrottleConfig = ThrottleConfig{
+	AccountAttempts: 5,
+	IPAttempts:      20,
+	BaseDelay:       time.Second,
+	MaxLockout:      15 * time.Minute,
+	Window:          time.Hour,
+}
+
+func (c ThrottleConfig) withDefaults() ThrottleConfig {
+	if c.AccountAttempts <= 0 {
+		c.AccountAttempts = DefaultThrottleConfig.AccountAttempts
+	}
+	if c.IPAttempts <= 0 {
+		c.IPAttempts = DefaultThrottleConfig.IPAttempts
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = DefaultThrottleConfig.BaseDelay
+	}
+	if c.MaxLockout <= 0 {
+		c.MaxLockout = DefaultThrottleConfig.MaxLockout
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultThrottleConfig.Window
+	}
+	return c
+}
+
+// lockout returns how long a subject is locked after its nth failure
+func (c ThrottleConfig) lockout(failures, freeAttempts int) time.Duration {
+	over := failures - freeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := float64(c.BaseDelay) * math.Pow(2, float64(over-1))
+	if delay >= float64(c.MaxLockout) {
+		return c.MaxLockout
+	}
+	return time.Duration(delay)
+}
+
+// loginSubjects returns the throttling subjects of a login attempt. Without
+// a known client IP only the account is throttled.
+func loginSubjects(email, ip string) map[string]string {
+	subjects := map[string]string{ScopeAccount: strings.ToLower(strings.TrimSpace(email))}
+	if ip != "" {
+		subjects[ScopeIP] = ip
+	}
+	return subjects
+}
+
+// checkLoginThrottle fails with a ThrottledError while the account or the IP
+// is locked. It runs before the password is checked, so locked attempts do
+// not cost a password hash comparison.
+func (s *Service) checkLoginThrottle(ctx context.Context, email, ip string) error {
+	lockedUntil, err := s.authRepo.LoginLockedUntil(ctx, loginSubjects(email, ip))
+	if err != nil {
+		return err
+	}
+	if lockedUntil == nil {
+		return nil
+	}
+	return &ThrottledError{RetryAfter: time.Until(*lockedUntil)}
+}
+
+// recordLoginFailure counts a failed login against the account and the IP.
+// It returns a ThrottledError if the failure locked either of them.
+func (s *Service) recordLoginFailure(ctx context.Context, email, ip string) error {
+	cfg := s.config.Throttle.withDefaults()
+	var locked time.Duration
+	for scope, subject := range loginSubjects(email, ip) {
+		free := cfg.AccountAttempts
+		if scope == ScopeIP {
+			free = cfg.IPAttempts
+		}
+		failures, err := s.authRepo.RecordLoginFailure(ctx, scope, subject, cfg.Window, func(failures int) time.Duration {
+			return cfg.lockout(failures, free)
+		})
+		if err != nil {
+			return err
+		}
+		if lockout := cfg.lockout(failures, free); lockout > 0 {
+			s.logger.WarnContext(ctx, "security event: login locked after failed attempts",
+				"event", "login_lockout", "scope", scope, "subject", subject, "failures", failures, "locked_for", lockout)
+			locked = max(locked, lockout)
+		}
+	}
+	if locked > 0 {
+		return &ThrottledError{RetryAfter: locked}
+	}
+	return nil
+}
+
+// PruneLoginAttempts deletes the failed login counters that would start over
+// on the next failure and hold no lock, and returns how many it deleted
+func (s *Service) PruneLoginAttempts(ctx context.Context) (int, error) {
+	cfg := s.config.Throttle.withDefaults()
+	return s.authRepo.DeleteStaleLoginAttempts(ctx, time.Now().Add(-cfg.Window))
+}
+
+// clearAccountLock resets the failed login counter of the account
+func (s *Service) clearAccountLock(ctx context.Context, email string) error {
+	_, err := s.authRepo.ClearLoginFailures(ctx, ScopeAccount, loginSubjects(email, "")[ScopeAccount])
+	return err
+}
+
+// UnlockAccount lifts the login lock of a student and resets the failed
+// attempt counter of the account. Locks of client IPs are not affected.
+func (s *Service) UnlockAccount(ctx context.Context, studentID int) error {
+	stud, err := s.studentRepo.GetByID(ctx, studentID)
+	if err != nil {
+		return err
+	}
+	unlocked, err := s.authRepo.ClearLoginFailures(ctx, ScopeAccount, loginSubjects(stud.Email, "")[ScopeAccount])
+	if err != nil {
+		return err
+	}
+
+	if unlocked {
+		actor, _ := GetStudentID(ctx)
+		s.logger.InfoContext(ctx, "account unlocked", "student_id", studentID, "actor_id", actor)
+	}
+	return nil
+}
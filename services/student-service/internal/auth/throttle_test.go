@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleLockout(t *testing.T) {
+	cfg := ThrottleConfig{BaseDelay: time.Second, MaxLockout: 10 * time.Second}.withDefaults()
+	assert.Equal(t, 5, cfg.AccountAttempts)
+
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{5, 0},
+		{6, time.Second},
+		{7, 2 * time.Second},
+		{9, 8 * time.Second},
+		{10, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, cfg.lockout(tc.failures, cfg.AccountAttempts), "after %d failures", tc.failures)
+	}
+}
+
+func TestThrottledError(t *testing.T) {
+	var err error = &ThrottledError{RetryAfter: time.Minute}
+	assert.True(t, errors.Is(err, ErrLoginThrottled))
+	var throttled *ThrottledError
+	assert.True(t, errors.As(err, &throttled))
+	assert.Equal(t, time.Minute, throttled.RetryAfter)
+}
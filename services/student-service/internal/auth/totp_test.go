@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		code, err := totpCode(secret, totpStep(time.Unix(tc.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, code, "at %d", tc.unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := newTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code, err := totpCode(secret, totpStep(now))
+	require.NoError(t, err)
+
+	step, ok := verifyTOTP(secret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now), step)
+
+	// One period of clock drift either way is fine, two are not
+	_, ok = verifyTOTP(secret, code, now.Add(totpPeriod), 0)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, code, now.Add(-totpPeriod), 0)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, code, now.Add(2*totpPeriod), 0)
+	assert.False(t, ok)
+
+	// A used step does not match again
+	_, ok = verifyTOTP(secret, code, now, step)
+	assert.False(t, ok)
+
+	_, ok = verifyTOTP(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("TOTP_ENCRYPTION_KEY", "")
+
+	sealed, err := sealTOTPSecret("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+	secret, err := openTOTPSecret(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", secret)
+
+	// Another key cannot open it
+	t.Setenv("TOTP_ENCRYPTION_KEY", "totp-key")
+	_, err = openTOTPSecret(sealed)
+	assert.Error(t, err)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(totpProvisioningURI("GRUD", "jan@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/GRUD:jan@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "GRUD", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := newRecoveryCode()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, code)
+	assert.Equal(t, normalizeRecoveryCode(code), normalizeRecoveryCode(" "+code[:5]+" "+code[6:]))
+	assert.Equal(t, "abcdefghij", normalizeRecoveryCode("ABCDE-FGHIJ"))
+}
+
+func TestMFAChallengeIsNoAccessToken(t *testing.T) {
+	keys := NewHMACKeySet([]byte("test-secret"))
+	challenge, err := keys.generateMFAChallenge(1, "laptop", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	_, err = keys.ValidateAccessToken(challenge)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	claims, err := keys.validateMFAChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.StudentID)
+	assert.Equal(t, "laptop", claims.DeviceLabel)
+
+	access, err := keys.GenerateAccessToken(Claims{StudentID: 1})
+	require.NoError(t, err)
+	_, err = keys.validateMFAChallenge(access)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, err := keys.generateMFAChallenge(1, "", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	_, err = keys.validateMFAChallenge(expired)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
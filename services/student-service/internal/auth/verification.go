@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"student-service/internal/mail"
+	"student-service/internal/student"
+)
+
+// UnverifiedPolicy decides how students who have not verified their email
+// can log in
+type UnverifiedPolicy string
+
+const (
+	// UnverifiedAllow gives unverified students a normal session
+	UnverifiedAllow UnverifiedPolicy = "allow"
+	// UnverifiedRestrict gives them a session without any permissions under
+	// /api; they can still verify, resend and manage their password
+	UnverifiedRestrict UnverifiedPolicy = "restrict"
+	// UnverifiedBlock refuses to log them in until they verify
+	UnverifiedBlock UnverifiedPolicy = "block"
+)
+
+// ParseUnverifiedPolicy validates a policy name; empty means restrict
+func ParseUnverifiedPolicy(name string) (UnverifiedPolicy, error) {
+	switch policy := UnverifiedPolicy(name); policy {
+	case "":
+		return UnverifiedRestrict, nil
+	case UnverifiedAllow, UnverifiedRestrict, UnverifiedBlock:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown unverified login policy %q", name)
+	}
+}
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+const defaultEmailVerificationTTL = 48 * time.Hour
+
+// VerifyEmail confirms the email address of the student the token was sent
+// to. Tokens issued before an email change do not verify the new address.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	accountToken, err := s.authRepo.ConsumeAccountToken(ctx, PurposeEmailVerification, hashAccountToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+
+	stud, err := s.studentRepo.GetByID(ctx, accountToken.StudentID)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(stud.Email, accountToken.Email) {
+		return ErrInvalidVerificationToken
+	}
+	if stud.VerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	stud.VerifiedAt = &now
+	stud.Version = 0
+	if err := s.studentRepo.Update(ctx, stud, "verified_at"); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "email verified", "student_id", stud.ID)
+	return nil
+}
+
+// ResendVerification sends a new verification email to an unverified
+// student. Like ForgotPassword it does not reveal whether the email exists.
+func (s *Service) ResendVerification(ctx context.Context, email string) error {
+	stud, err := s.studentRepo.GetByEmail(ctx, email)
+	if errors.Is(err, student.ErrStudentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stud.VerifiedAt != nil {
+		return nil
+	}
+	return s.sendVerification(ctx, stud)
+}
+
+// sendVerification emails a new verification link; earlier links stop working
+func (s *Service) sendVerification(ctx context.Context, stud *student.Student) error {
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	ttl := s.config.EmailVerificationTTL
+	if ttl <= 0 {
+		ttl = defaultEmailVerificationTTL
+	}
+	if err := s.authRepo.CreateAccountToken(ctx, &AccountToken{
+		StudentID: stud.ID,
+		Purpose:   PurposeEmailVerification,
+		TokenHash: hashAccountToken(token),
+		Email:     stud.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      stud.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"please confirm that this is your email address by opening\n\n"+
+			"%s\n\n"+
+			"The link is valid for %s. If you did not create an account, ignore this email.\n",
+			stud.FirstName, s.appLink("/verify-email", token), ttl),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+	return nil
+}
+
+// mayLogin reports whether the login policy lets the student in
+func (s *Service) mayLogin(stud *student.Student) bool {
+	return stud.VerifiedAt != nil || s.config.UnverifiedLogin != UnverifiedBlock
+}
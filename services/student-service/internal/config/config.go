@@ -13,6 +13,9 @@ type Config struct {
 	Database       DatabaseConfig       `mapstructure:"database"`
 	ProjectService ProjectServiceConfig `mapstructure:"project_service"`
 	NATS           NATSConfig           `mapstructure:"nats"`
+	Students       StudentsConfig       `mapstructure:"students"`
+	Auth           AuthConfig           `mapstructure:"auth"`
+	Mail           MailConfig           `mapstructure:"mail"`
 }
 
 type ServerConfig struct {
@@ -21,6 +24,10 @@ type ServerConfig struct {
 	WriteTimeout int      `mapstructure:"write_timeout_seconds"`
 	IdleTimeout  int      `mapstructure:"idle_timeout_seconds"`
 	CORSOrigins  []string `mapstructure:"cors_origins"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in front
+	// of the service whose X-Forwarded-For is believed. Empty trusts none and
+	// uses the address of the connection as the client IP.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type ProjectServiceConfig struct {
@@ -38,11 +45,129 @@ type DatabaseConfig struct {
 	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
 	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime_seconds"`
 	ConnMaxIdleTime int    `mapstructure:"conn_max_idle_time_seconds"`
+	// AutoMigrate applies pending migrations on startup. Disable it when
+	// migrations run as a separate "migrate up" job before deployment.
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+}
+
+type StudentsConfig struct {
+	// DeletedRetentionDays is how long soft-deleted students are kept before
+	// the purge job removes them for good. Zero disables purging.
+	DeletedRetentionDays int `mapstructure:"deleted_retention_days"`
+	PurgeIntervalSeconds int `mapstructure:"purge_interval_seconds"`
+}
+
+type AuthConfig struct {
+	// AppURL is the base URL of the web app that links in emails point to
+	AppURL                    string `mapstructure:"app_url"`
+	PasswordResetTTLMinutes   int    `mapstructure:"password_reset_ttl_minutes"`
+	EmailVerificationTTLHours int    `mapstructure:"email_verification_ttl_hours"`
+	InvitationTTLHours        int    `mapstructure:"invitation_ttl_hours"`
+	// UnverifiedLogin is "allow", "restrict" or "block"
+	UnverifiedLogin string `mapstructure:"unverified_login"`
+	// TokenSources is the order the access token is looked for in:
+	// "header" (Authorization: Bearer) and/or "cookie"
+	TokenSources  []string            `mapstructure:"token_sources"`
+	JWT           JWTConfig           `mapstructure:"jwt"`
+	LoginThrottle LoginThrottleConfig `mapstructure:"login_throttle"`
+	MFA           MFAConfig           `mapstructure:"mfa"`
+	OIDC          OIDCConfig          `mapstructure:"oidc"`
+	Password      PasswordConfig      `mapstructure:"password"`
+	Revocation    RevocationConfig    `mapstructure:"revocation"`
+}
+
+// RevocationConfig tunes how revoked access tokens reach every instance:
+// right away over NATS, and by reloading them from the database every
+// resync_interval_seconds
+type RevocationConfig struct {
+	// Subject is the NATS subject; empty uses the built-in default
+	Subject               string `mapstructure:"subject"`
+	ResyncIntervalSeconds int    `mapstructure:"resync_interval_seconds"`
+}
+
+// PasswordConfig sets the Argon2id cost of password hashes and the policy
+// for new passwords. Existing hashes are upgraded on the next login when the
+// cost changes.
+type PasswordConfig struct {
+	Argon2 Argon2Config `mapstructure:"argon2"`
+	// MinLength and MaxLength count characters
+	MinLength int `mapstructure:"min_length"`
+	MaxLength int `mapstructure:"max_length"`
+	// MinCharacterClasses is how many of lower case, upper case, digits and
+	// other characters a password has to mix
+	MinCharacterClasses int `mapstructure:"min_character_classes"`
+	// DenyListFile lists common passwords, one per line; empty disables
+	DenyListFile string `mapstructure:"deny_list_file"`
+}
+
+type Argon2Config struct {
+	MemoryKiB   uint32 `mapstructure:"memory_kib"`
+	Iterations  uint32 `mapstructure:"iterations"`
+	Parallelism uint8  `mapstructure:"parallelism"`
+}
+
+// OIDCConfig enables login with an OpenID Connect provider. The client
+// secret comes from OIDC_CLIENT_SECRET.
+type OIDCConfig struct {
+	// Issuer is the provider URL; empty turns OIDC login off
+	Issuer   string `mapstructure:"issuer"`
+	ClientID string `mapstructure:"client_id"`
+	// RedirectURL is the callback URL registered at the provider
+	RedirectURL string   `mapstructure:"redirect_url"`
+	Scopes      []string `mapstructure:"scopes"`
+	// Provision creates accounts for verified emails that have none
+	Provision bool `mapstructure:"provision"`
+}
+
+type MFAConfig struct {
+	// Issuer is the name authenticator apps show for the account
+	Issuer string `mapstructure:"issuer"`
+	// RequiredRoles must enroll in TOTP before their sessions get any
+	// permissions
+	RequiredRoles       []string `mapstructure:"required_roles"`
+	ChallengeTTLMinutes int      `mapstructure:"challenge_ttl_minutes"`
+}
+
+// LoginThrottleConfig limits failed logins. After the free attempts each
+// failure locks the account or IP for base_delay_seconds, doubled per
+// further failure up to max_lockout_minutes.
+type LoginThrottleConfig struct {
+	AccountAttempts   int `mapstructure:"account_attempts"`
+	IPAttempts        int `mapstructure:"ip_attempts"`
+	BaseDelaySeconds  int `mapstructure:"base_delay_seconds"`
+	MaxLockoutMinutes int `mapstructure:"max_lockout_minutes"`
+	// WindowMinutes is how long failures are remembered
+	WindowMinutes int `mapstructure:"window_minutes"`
+}
+
+// JWTConfig selects the access token signing keys. Without KeysDir tokens
+// are signed HS256 with JWT_SECRET.
+type JWTConfig struct {
+	// KeysDir holds <kid>.pem private and public keys
+	KeysDir      string `mapstructure:"keys_dir"`
+	SigningKeyID string `mapstructure:"signing_key_id"`
+	// AcceptHS256 also accepts tokens signed with JWT_SECRET, while switching
+	// from HS256 to keys
+	AcceptHS256 bool `mapstructure:"accept_hs256"`
+}
+
+type MailConfig struct {
+	// Driver is "smtp", "file" (writes .eml files to Dir) or "memory"
+	Driver   string `mapstructure:"driver"`
+	From     string `mapstructure:"from"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Dir      string `mapstructure:"dir"`
 }
 
 type NATSConfig struct {
 	URL     string `mapstructure:"url"`
 	Subject string `mapstructure:"subject"`
+	// PurgeSubject announces purged students to project-service; empty uses
+	// the built-in default
+	PurgeSubject string `mapstructure:"purge_subject"`
 }
 
 func Load() (*Config, error) {
@@ -65,11 +190,38 @@ func Load() (*Config, error) {
 		fmt.Printf("No config file found (will use ENV variables): %v\n", err)
 	}
 
+	viper.SetDefault("database.auto_migrate", true)
+	viper.SetDefault("students.deleted_retention_days", 30)
+	viper.SetDefault("students.purge_interval_seconds", 3600)
+	viper.SetDefault("auth.password_reset_ttl_minutes", 60)
+	viper.SetDefault("auth.email_verification_ttl_hours", 48)
+	viper.SetDefault("auth.invitation_ttl_hours", 168)
+	viper.SetDefault("auth.unverified_login", "restrict")
+	viper.SetDefault("auth.token_sources", []string{"header", "cookie"})
+	viper.SetDefault("auth.login_throttle.account_attempts", 5)
+	viper.SetDefault("auth.login_throttle.ip_attempts", 20)
+	viper.SetDefault("auth.login_throttle.base_delay_seconds", 1)
+	viper.SetDefault("auth.login_throttle.max_lockout_minutes", 15)
+	viper.SetDefault("auth.login_throttle.window_minutes", 60)
+	viper.SetDefault("auth.mfa.issuer", "GRUD")
+	viper.SetDefault("auth.mfa.challenge_ttl_minutes", 5)
+	viper.SetDefault("auth.oidc.scopes", []string{"email", "profile"})
+	viper.SetDefault("auth.password.argon2.memory_kib", 19456)
+	viper.SetDefault("auth.password.argon2.iterations", 2)
+	viper.SetDefault("auth.password.argon2.parallelism", 1)
+	viper.SetDefault("auth.password.min_length", 8)
+	viper.SetDefault("auth.password.max_length", 128)
+	viper.SetDefault("auth.password.min_character_classes", 2)
+	viper.SetDefault("mail.driver", "file")
+	viper.SetDefault("mail.from", "student-service@localhost")
+
 	// Enable environment variable overrides (these take precedence over config file)
 	viper.AutomaticEnv()
 
 	viper.BindEnv("database.user", "DB_USER")
 	viper.BindEnv("database.password", "DB_PASSWORD")
+	viper.BindEnv("mail.username", "SMTP_USERNAME")
+	viper.BindEnv("mail.password", "SMTP_PASSWORD")
 
 	// Unmarshal into struct
 	var config Config
@@ -9,12 +9,18 @@ import (
 	"time"
 
 	"student-service/internal/config"
+	"student-service/internal/db/migrations"
+
+	"grud/common/migrate"
 
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+// migrationLockName scopes the migration advisory lock to this service's schema
+const migrationLockName = "student-service"
+
 func New(cfg config.DatabaseConfig) *bun.DB {
 	sslMode := cfg.SSLMode
 	if sslMode == "" {
@@ -90,16 +96,37 @@ func Close(db *bun.DB) {
 	}
 }
 
-func RunMigrations(ctx context.Context, db *bun.DB, models ...interface{}) error {
-	for _, model := range models {
-		_, err := db.NewCreateTable().
-			Model(model).
-			IfNotExists().
-			Exec(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to create table for model: %w", err)
-		}
+// NewMigrator returns a migrator for the SQL migrations embedded in this service
+func NewMigrator(db *bun.DB, logger *slog.Logger) (*migrate.Migrator, error) {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db.DB, ms, migrationLockName, logger), nil
+}
+
+// RunMigrations applies all pending migrations. It refuses to run against a
+// schema that was migrated by a newer release of the service.
+func RunMigrations(ctx context.Context, db *bun.DB) error {
+	migrator, err := NewMigrator(db, slog.Default())
+	if err != nil {
+		return err
 	}
-	slog.Info("database migrations completed successfully")
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("database migrations completed successfully", "applied", len(applied))
 	return nil
 }
+
+// VerifyMigrations checks that the schema matches this binary without changing it
+func VerifyMigrations(ctx context.Context, db *bun.DB) error {
+	migrator, err := NewMigrator(db, slog.Default())
+	if err != nil {
+		return err
+	}
+	return migrator.Verify(ctx)
+}
@@ -0,0 +1,96 @@
+package db_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+
+	"grud/common/migrate"
+	"grud/testing/testdb"
+	"student-service/internal/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations_Shared(t *testing.T) {
+	pgContainer := testdb.SetupSharedPostgres(t)
+	defer pgContainer.Cleanup(t)
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	migrator, err := db.NewMigrator(pgContainer.DB, logger)
+	require.NoError(t, err)
+
+	t.Run("UpAppliesAllMigrations", func(t *testing.T) {
+		require.NoError(t, db.RunMigrations(ctx, pgContainer.DB))
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.UpToDate())
+		assert.Len(t, status.Applied, len(migrator.Migrations()))
+
+		// Running again is a no-op
+		applied, err := migrator.Up(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, applied)
+	})
+
+	t.Run("ConcurrentUpIsSerialized", func(t *testing.T) {
+		for range migrator.Migrations() {
+			_, err := migrator.Down(ctx)
+			require.NoError(t, err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := migrator.Up(ctx)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.UpToDate())
+	})
+
+	t.Run("DownRollsBackLatest", func(t *testing.T) {
+		rolledBack, err := migrator.Down(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, rolledBack)
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, status.Pending, 1)
+		assert.Equal(t, rolledBack.Version, status.Pending[0].Version)
+
+		_, err = migrator.Up(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("RefusesNewerSchema", func(t *testing.T) {
+		_, err := pgContainer.DB.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name) VALUES (99999999999999, 'from_the_future')")
+		require.NoError(t, err)
+		defer pgContainer.DB.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = 99999999999999")
+
+		err = db.RunMigrations(ctx, pgContainer.DB)
+		assert.ErrorIs(t, err, migrate.ErrSchemaNewer)
+
+		_, err = migrator.Check(ctx)
+		assert.ErrorIs(t, err, migrate.ErrSchemaNewer)
+	})
+}
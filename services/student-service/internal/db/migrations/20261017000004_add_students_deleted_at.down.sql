@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS students_deleted_at_idx;
+
+ALTER TABLE students DROP COLUMN IF EXISTS deleted_at;
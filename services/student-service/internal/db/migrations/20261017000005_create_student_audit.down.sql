@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS student_audit;
+
+DROP FUNCTION IF EXISTS student_audit_append_only();
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS student_audit (
+    id BIGSERIAL PRIMARY KEY,
+    student_id BIGINT NOT NULL,
+    actor_id BIGINT,
+    action VARCHAR NOT NULL,
+    changes JSONB,
+    request_id VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS student_audit_student_id_idx ON student_audit (student_id, id);
+
+-- The audit trail is append-only
+CREATE OR REPLACE FUNCTION student_audit_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'student_audit is append-only';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS student_audit_append_only ON student_audit;
+CREATE TRIGGER student_audit_append_only
+    BEFORE UPDATE OR DELETE ON student_audit
+    FOR EACH ROW
+    EXECUTE FUNCTION student_audit_append_only();
@@ -0,0 +1,2 @@
+ALTER TABLE students ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'student'
+    CONSTRAINT students_role_check CHECK (role IN ('student', 'staff', 'admin'));
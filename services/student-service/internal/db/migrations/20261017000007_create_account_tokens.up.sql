@@ -0,0 +1,13 @@
+-- Single-use tokens sent to students by email, such as password reset links.
+-- Only the SHA-256 hash of a token is stored.
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    student_id BIGINT NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS account_tokens_student_purpose_idx ON account_tokens (student_id, purpose);
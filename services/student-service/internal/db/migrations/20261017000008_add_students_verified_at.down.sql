@@ -0,0 +1,2 @@
+ALTER TABLE account_tokens DROP COLUMN IF EXISTS email;
+ALTER TABLE students DROP COLUMN IF EXISTS verified_at;
@@ -0,0 +1,7 @@
+ALTER TABLE students ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ;
+
+-- Accounts created before email verification existed keep working
+UPDATE students SET verified_at = CURRENT_TIMESTAMP WHERE verified_at IS NULL;
+
+-- Verification tokens remember the address they were sent to
+ALTER TABLE account_tokens ADD COLUMN IF NOT EXISTS email VARCHAR;
@@ -0,0 +1,10 @@
+-- Refresh tokens are single use. Every login starts a family that each
+-- refresh extends by one token; presenting a used token revokes the family.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id VARCHAR(32);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS used_at TIMESTAMPTZ;
+
+-- Every token issued so far was the only one of its session
+UPDATE refresh_tokens SET family_id = 'legacy-' || id WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
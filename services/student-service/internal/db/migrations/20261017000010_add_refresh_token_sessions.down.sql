@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS refresh_tokens_student_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS session_started_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS device_label;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1,16 @@
+-- Session metadata, copied to every token of a family on rotation so that the
+-- unused token of a family describes the whole session
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent VARCHAR;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_label VARCHAR(64);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_started_at TIMESTAMPTZ;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET session_started_at = created_at WHERE session_started_at IS NULL;
+UPDATE refresh_tokens SET last_used_at = created_at WHERE last_used_at IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_student_id_idx ON refresh_tokens (student_id);
@@ -0,0 +1,6 @@
+-- Hashed tokens cannot be turned back into tokens, so their sessions end
+DELETE FROM refresh_tokens WHERE token IS NULL;
+
+DROP INDEX IF EXISTS refresh_tokens_token_hash_key;
+ALTER TABLE refresh_tokens ALTER COLUMN token SET NOT NULL;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token_hash;
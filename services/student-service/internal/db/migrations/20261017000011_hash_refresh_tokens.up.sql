@@ -0,0 +1,9 @@
+-- Refresh tokens are stored as an HMAC-SHA256 keyed with REFRESH_TOKEN_SECRET.
+-- Rows written before keep the raw token until they are first presented,
+-- when the service replaces it with the hash, or until the cleanup job
+-- deletes them once they expire after at most 7 days. The token column can
+-- be dropped after that.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64);
+ALTER TABLE refresh_tokens ALTER COLUMN token DROP NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS refresh_tokens_token_hash_key ON refresh_tokens (token_hash);
@@ -0,0 +1,10 @@
+-- Failed login counters per account (scope 'account', subject the lowercased
+-- email) and per client IP (scope 'ip'). Shared by all replicas.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(320) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,22 @@
+-- TOTP second factor per student. The secret is encrypted with AES-GCM; the
+-- factor is active once confirmed_at is set. last_used_step stops a code
+-- from being used twice.
+CREATE TABLE IF NOT EXISTS totp_credentials (
+    student_id BIGINT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes for when the authenticator is lost. Only the
+-- SHA-256 hash of a code is stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    student_id BIGINT NOT NULL,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_student_id_idx ON recovery_codes (student_id);
@@ -0,0 +1,11 @@
+-- Accounts at OpenID Connect providers linked to students. A provider
+-- account is identified by the issuer and its stable subject claim.
+CREATE TABLE IF NOT EXISTS federated_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    student_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS federated_identities_student_id_idx ON federated_identities (student_id);
@@ -0,0 +1,15 @@
+-- API keys of machine clients. Only the SHA-256 hash of a key is stored;
+-- prefix is the start of the key, for telling keys apart in lists. scopes is
+-- a JSON array of the permissions the key holds.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes JSONB NOT NULL,
+    created_by BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
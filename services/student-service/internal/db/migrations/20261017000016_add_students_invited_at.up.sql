@@ -0,0 +1 @@
+ALTER TABLE students ADD COLUMN IF NOT EXISTS invited_at TIMESTAMPTZ;
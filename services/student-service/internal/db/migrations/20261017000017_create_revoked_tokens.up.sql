@@ -0,0 +1,13 @@
+-- Revoked access tokens. kind is "token" (subject is the jti of one token)
+-- or "session" (subject is a refresh token family). Rows are only needed
+-- until the tokens they cover have expired.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    subject TEXT NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE students DROP COLUMN IF EXISTS suspended_at;
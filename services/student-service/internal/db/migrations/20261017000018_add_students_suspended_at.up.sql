@@ -0,0 +1 @@
+ALTER TABLE students ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE student_audit DROP COLUMN IF EXISTS actor_api_key_id;
@@ -0,0 +1,2 @@
+-- Writes made with an API key record the key instead of a student
+ALTER TABLE student_audit ADD COLUMN IF NOT EXISTS actor_api_key_id BIGINT;
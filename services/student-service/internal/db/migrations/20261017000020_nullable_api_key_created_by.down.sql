@@ -0,0 +1,2 @@
+DELETE FROM api_keys WHERE created_by IS NULL;
+ALTER TABLE api_keys ALTER COLUMN created_by SET NOT NULL;
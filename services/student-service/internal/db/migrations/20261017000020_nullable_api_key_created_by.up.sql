@@ -0,0 +1,3 @@
+-- API keys outlive the student who created them; purging the student clears
+-- created_by
+ALTER TABLE api_keys ALTER COLUMN created_by DROP NOT NULL;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS students_email_lower_key;
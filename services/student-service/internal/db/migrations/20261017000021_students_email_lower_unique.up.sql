@@ -0,0 +1,4 @@
+-- Emails are looked up case-insensitively, so they must also be unique
+-- regardless of case. Fails while two students have emails that differ only
+-- by case; those have to be merged or renamed first.
+CREATE UNIQUE INDEX IF NOT EXISTS students_email_lower_key ON students (lower(email));
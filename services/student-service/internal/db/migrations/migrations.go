@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS holds the versioned SQL migrations of student-service.
+// Files are named <version>_<name>.up.sql / <version>_<name>.down.sql.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"student-service/internal/password"
+	"student-service/internal/rbac"
+	"student-service/internal/student"
+
+	"github.com/uptrace/bun"
+)
+
+// seedPassword is the login password of every seeded student (local development only)
+const seedPassword = "password123"
+
+var seedStudents = []student.Student{
+	{FirstName: "Jan", LastName: "Novák", Email: "jan.novak@university.cz", Major: "Computer Science", Year: 2, Role: rbac.RoleAdmin},
+	{FirstName: "Eva", LastName: "Svobodová", Email: "eva.svobodova@university.cz", Major: "Mathematics", Year: 3, Role: rbac.RoleStaff},
+	{FirstName: "Petr", LastName: "Dvořák", Email: "petr.dvorak@university.cz", Major: "Physics", Year: 1},
+	{FirstName: "Lucie", LastName: "Černá", Email: "lucie.cerna@university.cz", Major: "Computer Science", Year: 4},
+}
+
+// Seed inserts sample students. Existing emails are left untouched, so it is safe to run repeatedly.
+func Seed(ctx context.Context, db *bun.DB) error {
+	hasher, err := password.NewArgon2id(password.DefaultParams)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := hasher.Hash(seedPassword)
+	if err != nil {
+		return err
+	}
+
+	students := make([]student.Student, len(seedStudents))
+	copy(students, seedStudents)
+	for i := range students {
+		students[i].Password = hashedPassword
+	}
+
+	result, err := db.NewInsert().
+		Model(&students).
+		On("CONFLICT (email) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to seed students: %w", err)
+	}
+
+	inserted, _ := result.RowsAffected()
+	slog.Info("database seeded", "students", inserted)
+	return nil
+}
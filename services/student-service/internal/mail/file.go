@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes each message as an .eml file into a directory instead of
+// sending it. It is meant for local development.
+type FileMailer struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "student-service-mail")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("file mailer: %w", err)
+	}
+	if from == "" {
+		from = "student-service@localhost"
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	body, err := render(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405.000000000"), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o640)
+}
@@ -0,0 +1,77 @@
+// Package mail sends transactional email such as password reset links.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures a Mailer
+type Config struct {
+	// Driver is "smtp", "file" or "memory"
+	Driver string
+	From   string
+	// SMTP settings, used by the smtp driver
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Dir receives one .eml file per message with the file driver
+	Dir string
+}
+
+// New returns the Mailer selected by cfg.Driver
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg)
+	case "file":
+		return NewFileMailer(cfg.Dir, cfg.From)
+	case "memory", "":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// render encodes msg as an RFC 5322 message with a quoted-printable UTF-8 body
+func render(from string, msg Message, date time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	if _, err := w.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
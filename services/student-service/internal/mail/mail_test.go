@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	msg := Message{To: "jan.novak@university.cz", Subject: "Obnova hesla", Body: "Odkaz: https://example.com/reset?token=abc=def\n"}
+	raw, err := render("noreply@university.cz", msg, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.NoError(t, err)
+	assert.Equal(t, "jan.novak@university.cz", parsed.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Obnova hesla", subject)
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	require.NoError(t, err)
+	// line breaks are sent as CRLF
+	assert.Equal(t, msg.Body, strings.ReplaceAll(string(body), "\r\n", "\n"))
+
+	_, err = render("noreply@university.cz", Message{To: "not an address"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "one", Body: "1"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "b@example.com", Subject: "two", Body: "2"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	content, err := os.ReadFile(files[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "To: b@example.com")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Body: "first"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Body: "second"}))
+
+	last, ok := m.Last("a@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "second", last.Body)
+	assert.Len(t, m.Messages(), 2)
+
+	_, ok = m.Last("b@example.com")
+	assert.False(t, ok)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Driver: "pigeon"})
+	assert.Error(t, err)
+
+	_, err = New(Config{Driver: "smtp", From: "noreply@university.cz"})
+	assert.Error(t, err, "host is required")
+
+	m, err := New(Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryMailer{}, m)
+}
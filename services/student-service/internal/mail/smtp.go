@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer sends email through an SMTP relay. STARTTLS is used when the
+// server offers it; credentials are only sent over TLS.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(cfg Config) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp mailer: host is required")
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("smtp mailer: invalid from address %q: %w", cfg.From, err)
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		from: cfg.From,
+	}
+	if cfg.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	body, err := render(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	sender, _ := mail.ParseAddress(m.from)
+	recipient, _ := mail.ParseAddress(msg.To)
+
+	// smtp.SendMail does not take a context, so honour cancellation around it
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, sender.Address, []string{recipient.Address}, body)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
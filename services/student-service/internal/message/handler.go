@@ -6,6 +6,7 @@ import (
 
 	"student-service/internal/auth"
 	"student-service/internal/metrics"
+	"student-service/internal/rbac"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -28,12 +29,20 @@ func NewHandler(service *Service, logger *slog.Logger, metrics *metrics.Metrics)
 }
 
 func (h *Handler) RegisterRoutes(router gin.IRouter) {
-	router.POST("/messages", h.SendMessage)
+	router.POST("/messages", rbac.RequirePermission(rbac.MessagesWrite), h.SendMessage)
 }
 
+// apiKeySender prefixes the key name as the sender of messages sent with an
+// API key
+const apiKeySender = "api-key:"
+
 func (h *Handler) SendMessage(c *gin.Context) {
-	// Get email from auth context
+	// Get email from auth context. Machine clients send as "api-key:<name>"
+	// so that consumers can tell their messages apart.
 	email, ok := auth.GetEmail(c.Request.Context())
+	if apiKey, isKey := auth.GetAPIKey(c.Request.Context()); isKey {
+		email, ok = apiKeySender+apiKey.Name, true
+	}
 	if !ok {
 		h.logger.WarnContext(c.Request.Context(), "email not found in context")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -16,6 +16,7 @@ import (
 	"student-service/internal/message"
 	"student-service/internal/messaging"
 	"student-service/internal/metrics"
+	"student-service/internal/rbac"
 
 	"grud/testing/testnats"
 
@@ -44,6 +45,12 @@ func setupTestWithNATSContainer(t *testing.T, natsContainer *testnats.NATSContai
 	return router, nc
 }
 
+// withStudent authenticates a request the way auth.AuthMiddleware does
+func withStudent(ctx context.Context, email string) context.Context {
+	ctx = context.WithValue(ctx, auth.EmailKey, email)
+	return rbac.WithPrincipal(ctx, rbac.Principal{StudentID: 1, Role: rbac.RoleStudent})
+}
+
 func TestMessageHandlerWithNATSContainer(t *testing.T) {
 	natsContainer := testnats.SetupSharedNATS(t)
 	defer natsContainer.Cleanup(t)
@@ -73,7 +80,7 @@ func TestMessageHandlerWithNATSContainer(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
 
-		ctx := context.WithValue(req.Context(), auth.EmailKey, "test@example.com")
+		ctx := withStudent(req.Context(), "test@example.com")
 		req = req.WithContext(ctx)
 
 		w := httptest.NewRecorder()
@@ -125,7 +132,7 @@ func TestMessageHandlerWithNATSContainer(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body))
 			req.Header.Set("Content-Type", "application/json")
 
-			ctx := context.WithValue(req.Context(), auth.EmailKey, email)
+			ctx := withStudent(req.Context(), email)
 			req = req.WithContext(ctx)
 
 			w := httptest.NewRecorder()
@@ -147,6 +154,39 @@ func TestMessageHandlerWithNATSContainer(t *testing.T) {
 		}
 	})
 
+	t.Run("SendMessage_WithAPIKey", func(t *testing.T) {
+		subject := "test.messages." + strings.ReplaceAll(t.Name(), "/", ".")
+		router, nc := setupTestWithNATSContainer(t, natsContainer, subject)
+
+		received := make(chan *nats.Msg, 1)
+		_, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+			received <- msg
+		})
+		require.NoError(t, err)
+		require.NoError(t, nc.Flush())
+		time.Sleep(50 * time.Millisecond)
+
+		body, _ := json.Marshal(message.SendMessageRequest{Message: "Nightly report ready"})
+		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		ctx := context.WithValue(req.Context(), auth.APIKeyKey, &auth.APIKey{ID: 1, Name: "reporting"})
+		ctx = rbac.WithPrincipal(ctx, rbac.Principal{Kind: rbac.PrincipalAPIKey, APIKeyID: 1, Scopes: []rbac.Permission{rbac.MessagesWrite}})
+		req = req.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		select {
+		case msg := <-received:
+			var event message.MessageEvent
+			require.NoError(t, json.Unmarshal(msg.Data, &event))
+			assert.Equal(t, "api-key:reporting", event.Email)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Message not received on NATS within timeout")
+		}
+	})
+
 	t.Run("SendMessage_Unauthorized", func(t *testing.T) {
 		subject := "test.messages." + strings.ReplaceAll(t.Name(), "/", ".")
 		router, _ := setupTestWithNATSContainer(t, natsContainer, subject)
@@ -177,7 +217,7 @@ func TestMessageHandlerWithNATSContainer(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
 
-		ctx := context.WithValue(req.Context(), auth.EmailKey, "test@example.com")
+		ctx := withStudent(req.Context(), "test@example.com")
 		req = req.WithContext(ctx)
 
 		w := httptest.NewRecorder()
@@ -8,3 +8,8 @@ type MessageEvent struct {
 	Email   string `json:"email"`
 	Message string `json:"message"`
 }
+
+// StudentPurgedEvent announces that a student was purged
+type StudentPurgedEvent struct {
+	Email string `json:"email"`
+}
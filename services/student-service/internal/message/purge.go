@@ -0,0 +1,34 @@
+package message
+
+import (
+	"context"
+
+	"student-service/internal/student"
+
+	"github.com/uptrace/bun"
+)
+
+// DefaultPurgeSubject is the subject purged students are announced on
+const DefaultPurgeSubject = "student.messages.purge"
+
+// Publisher sends a value to a subject. messaging.Producer implements it.
+type Publisher interface {
+	PublishTo(ctx context.Context, subject string, value interface{}) error
+}
+
+// PurgeHook returns a student.PurgeHook that announces every purged student
+// on subject, DefaultPurgeSubject if empty. project-service keeps messages by
+// email and deletes the messages of the student when it receives the event.
+func PurgeHook(publisher Publisher, subject string) student.PurgeHook {
+	if subject == "" {
+		subject = DefaultPurgeSubject
+	}
+	return func(ctx context.Context, _ bun.IDB, students []student.Student) error {
+		for _, stud := range students {
+			if err := publisher.PublishTo(ctx, subject, StudentPurgedEvent{Email: stud.Email}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
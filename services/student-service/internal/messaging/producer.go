@@ -32,6 +32,12 @@ func NewProducer(url string, subject string, logger *slog.Logger) (*Producer, er
 }
 
 func (p *Producer) SendMessage(ctx context.Context, value interface{}) error {
+	return p.PublishTo(ctx, p.subject, value)
+}
+
+// PublishTo sends value as JSON to subject instead of the subject of the
+// producer
+func (p *Producer) PublishTo(ctx context.Context, subject string, value interface{}) error {
 	valueBytes, err := json.Marshal(value)
 	if err != nil {
 		p.logger.ErrorContext(ctx, "failed to marshal message", "error", err)
@@ -39,7 +45,7 @@ func (p *Producer) SendMessage(ctx context.Context, value interface{}) error {
 	}
 
 	// Create NATS message with headers for trace propagation
-	msg := nats.NewMsg(p.subject)
+	msg := nats.NewMsg(subject)
 	msg.Data = valueBytes
 
 	// Inject trace context into NATS headers
@@ -50,10 +56,32 @@ func (p *Producer) SendMessage(ctx context.Context, value interface{}) error {
 		return err
 	}
 
-	p.logger.InfoContext(ctx, "message sent to NATS", "subject", p.subject)
+	p.logger.InfoContext(ctx, "message sent to NATS", "subject", subject)
 	return nil
 }
 
+// Subscribe calls handler with the data of every message published to
+// subject, until the returned unsubscribe function is called. The context
+// passed to handler carries the trace of the publisher.
+func (p *Producer) Subscribe(subject string, handler func(ctx context.Context, data []byte)) (func() error, error) {
+	sub, err := p.conn.Subscribe(subject, func(msg *nats.Msg) {
+		// Extract trace context from NATS headers
+		msgCtx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(msg.Header))
+		handler(msgCtx, msg.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Messages published after Subscribe returns are not missed
+	if err := p.conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+
+	p.logger.Info("NATS subscription started", "subject", subject)
+	return sub.Unsubscribe, nil
+}
+
 func (p *Producer) Close() error {
 	p.conn.Close()
 	return nil
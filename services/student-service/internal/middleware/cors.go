@@ -17,8 +17,9 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 
 		if originSet[origin] {
 			c.Header("Access-Control-Allow-Origin", origin)
-			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, X-Request-ID, X-Auth-Mode, X-CSRF-Token")
+			c.Header("Access-Control-Expose-Headers", "ETag, X-Request-ID")
 			c.Header("Access-Control-Allow-Credentials", "true")
 		}
 
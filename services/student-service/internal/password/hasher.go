@@ -0,0 +1,164 @@
+// Package password hashes passwords and checks new ones against a policy.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Hasher hashes passwords and verifies them against stored hashes.
+// Implementations must be safe for concurrent use.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the hash. needsRehash is
+	// set for a match against a hash made with another algorithm or other
+	// parameters; it should be replaced with a fresh Hash of the password.
+	Verify(password, hash string) (ok, needsRehash bool, err error)
+}
+
+// Params are the Argon2id cost parameters
+type Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the OWASP recommendation for Argon2id and are used
+// for the zero Params fields
+var DefaultParams = Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p Params) withDefaults() Params {
+	if p.Memory == 0 {
+		p.Memory = DefaultParams.Memory
+	}
+	if p.Iterations == 0 {
+		p.Iterations = DefaultParams.Iterations
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = DefaultParams.Parallelism
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = DefaultParams.SaltLength
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = DefaultParams.KeyLength
+	}
+	return p
+}
+
+// Argon2id hashes with Argon2id into PHC strings such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>. It still verifies bcrypt
+// hashes, which always need a rehash.
+type Argon2id struct {
+	params Params
+}
+
+func NewArgon2id(params Params) (*Argon2id, error) {
+	params = params.withDefaults()
+	if params.Memory < 8*uint32(params.Parallelism) {
+		return nil, fmt.Errorf("argon2id memory must be at least 8 KiB per lane, got %d KiB for %d lanes", params.Memory, params.Parallelism)
+	}
+	if params.SaltLength < 8 {
+		return nil, fmt.Errorf("argon2id salt must be at least 8 bytes, got %d", params.SaltLength)
+	}
+	if params.KeyLength < 16 {
+		return nil, fmt.Errorf("argon2id key must be at least 16 bytes, got %d", params.KeyLength)
+	}
+	return &Argon2id{params: params}, nil
+}
+
+func (h *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2id) Verify(password, hash string) (bool, bool, error) {
+	switch {
+	case hash == "":
+		// Invited students have no password yet
+		return false, false, nil
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+		}
+		return true, true, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, params != h.params, nil
+	default:
+		return false, false, fmt.Errorf("%w: unknown algorithm", ErrMalformedHash)
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// decodeArgon2id parses an Argon2id PHC string. The salt and key lengths
+// of the returned params are those of the hash.
+func decodeArgon2id(hash string) (Params, []byte, []byte, error) {
+	var params Params
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("%w: expected 5 fields", ErrMalformedHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version %q", ErrMalformedHash, parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("%w: invalid parameters %q", ErrMalformedHash, parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: salt: %v", ErrMalformedHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: invalid key", ErrMalformedHash)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
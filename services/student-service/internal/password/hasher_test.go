@@ -0,0 +1,103 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastParams keep the tests quick
+var fastParams = Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestArgon2idHash(t *testing.T) {
+	h, err := NewArgon2id(fastParams)
+	require.NoError(t, err)
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	// Every hash has its own salt
+	again, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, again)
+
+	ok, rehash, err := h.Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = h.Verify("wrong horse", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestArgon2idRehash(t *testing.T) {
+	old, err := NewArgon2id(fastParams)
+	require.NoError(t, err)
+	hash, err := old.Hash("correct horse")
+	require.NoError(t, err)
+
+	stronger := fastParams
+	stronger.Iterations = 2
+	h, err := NewArgon2id(stronger)
+	require.NoError(t, err)
+
+	// Hashes keep verifying with the parameters they were made with
+	ok, rehash, err := h.Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// bcrypt hashes verify and always need a rehash
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	ok, rehash, err = h.Verify("correct horse", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash, err = h.Verify("wrong horse", string(legacy))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+}
+
+func TestArgon2idVerifyMalformed(t *testing.T) {
+	h, err := NewArgon2id(fastParams)
+	require.NoError(t, err)
+
+	// No password at all never matches
+	ok, _, err := h.Verify("", "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	for _, hash := range []string{
+		"plain-text",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$2a$10$short",
+	} {
+		ok, _, err := h.Verify("password", hash)
+		assert.ErrorIs(t, err, ErrMalformedHash, hash)
+		assert.False(t, ok)
+	}
+}
+
+func TestNewArgon2id(t *testing.T) {
+	h, err := NewArgon2id(Params{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultParams, h.params)
+
+	_, err = NewArgon2id(Params{Memory: 8, Parallelism: 2})
+	assert.Error(t, err)
+	_, err = NewArgon2id(Params{SaltLength: 4})
+	assert.Error(t, err)
+	_, err = NewArgon2id(Params{KeyLength: 8})
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// Policy decides which passwords students may choose
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MinClasses is how many of lower case letters, upper case letters,
+	// digits and other characters a password has to mix
+	MinClasses int
+	// DenyList holds common passwords in lower case, see LoadDenyList
+	DenyList map[string]struct{}
+}
+
+// DefaultPolicy is used for the zero Policy fields. It has no deny-list.
+var DefaultPolicy = Policy{
+	MinLength:  8,
+	MaxLength:  128,
+	MinClasses: 2,
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MinLength <= 0 {
+		p.MinLength = DefaultPolicy.MinLength
+	}
+	if p.MaxLength <= 0 {
+		p.MaxLength = DefaultPolicy.MaxLength
+	}
+	if p.MinClasses <= 0 {
+		p.MinClasses = DefaultPolicy.MinClasses
+	}
+	return p
+}
+
+// Check returns an error wrapping ErrWeakPassword that tells the student
+// what is wrong with the password
+func (p Policy) Check(password string) error {
+	p = p.withDefaults()
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("%w: use at most %d characters", ErrWeakPassword, p.MaxLength)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		return fmt.Errorf("%w: mix at least %d of lower case letters, upper case letters, digits and other characters",
+			ErrWeakPassword, p.MinClasses)
+	}
+	if _, denied := p.DenyList[strings.ToLower(password)]; denied {
+		return fmt.Errorf("%w: the password is too common", ErrWeakPassword)
+	}
+	return nil
+}
+
+// characterClasses counts the classes of characters used in a password
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// LoadDenyList reads common passwords from a file with one password per
+// line. Blank lines and lines starting with # are skipped.
+func LoadDenyList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("password deny-list: %w", err)
+	}
+	defer f.Close()
+
+	denyList := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denyList[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("password deny-list %s: %w", path, err)
+	}
+	return denyList, nil
+}
@@ -0,0 +1,50 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{DenyList: map[string]struct{}{"password123": {}}}
+
+	for _, password := range []string{"reset-password-1", "Hesl0Hesl0", "žluťoučký kůň"} {
+		assert.NoError(t, policy.Check(password), password)
+	}
+	for _, password := range []string{"short1", "onlylowercase", "PASSWORD123", "12345678901"} {
+		assert.ErrorIs(t, policy.Check(password), ErrWeakPassword, password)
+	}
+
+	err := policy.Check("abc")
+	assert.EqualError(t, err, "password does not meet the policy: use at least 8 characters")
+
+	// Characters, not bytes, are counted
+	assert.ErrorIs(t, Policy{MaxLength: 10}.Check("ééééééééé1a"), ErrWeakPassword)
+	assert.NoError(t, Policy{MaxLength: 10}.Check("éééééééé1a"))
+
+	strict := Policy{MinLength: 12, MinClasses: 4}
+	assert.ErrorIs(t, strict.Check("Short-1a"), ErrWeakPassword)
+	assert.ErrorIs(t, strict.Check("longer-password-1"), ErrWeakPassword)
+	assert.NoError(t, strict.Check("Longer-password-1"))
+}
+
+func TestLoadDenyList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# common passwords\nPassword123\n\n  qwerty123  \n"), 0o600))
+
+	denyList, err := LoadDenyList(path)
+	require.NoError(t, err)
+	assert.Len(t, denyList, 2)
+
+	policy := Policy{DenyList: denyList}
+	assert.ErrorIs(t, policy.Check("password123"), ErrWeakPassword)
+	assert.ErrorIs(t, policy.Check("QWERTY123"), ErrWeakPassword)
+	assert.NoError(t, policy.Check("qwerty1234"))
+
+	_, err = LoadDenyList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"student-service/internal/metrics"
+	"student-service/internal/rbac"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,8 +25,8 @@ func NewHandler(grpcClient *GrpcClient, logger *slog.Logger, metrics *metrics.Me
 }
 
 func (h *Handler) RegisterRoutes(router gin.IRouter) {
-	router.GET("/projects", h.GetAllProjects)
-	router.GET("/messages", h.GetMessages)
+	router.GET("/projects", rbac.RequirePermission(rbac.ProjectsRead), h.GetAllProjects)
+	router.GET("/messages", rbac.RequirePermission(rbac.MessagesRead), h.GetMessages)
 }
 
 func (h *Handler) GetAllProjects(c *gin.Context) {
@@ -0,0 +1,76 @@
+package rbac
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole allows the request only if the caller has one of the roles.
+// It must run after the auth middleware.
+func RequireRole(roles ...Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := GetPrincipal(c.Request.Context())
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+		for _, role := range roles {
+			if p.Role == role && !p.Restricted {
+				c.Next()
+				return
+			}
+		}
+		abortForbidden(c)
+	}
+}
+
+// RequirePermission allows the request only if the caller holds all of the
+// permissions. It must run after the auth middleware.
+func RequirePermission(permissions ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := GetPrincipal(c.Request.Context())
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+		for _, permission := range permissions {
+			if !p.Can(permission) {
+				abortForbidden(c)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission allows the request if the student ID in the path
+// parameter param is the caller's own, or otherwise if the caller holds the
+// permission. This is how students get to edit only themselves.
+func RequireSelfOrPermission(param string, permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := GetPrincipal(c.Request.Context())
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+		if id, err := strconv.Atoi(c.Param(param)); err == nil && id == p.StudentID && !p.Restricted && !p.IsAPIKey() {
+			c.Next()
+			return
+		}
+		if !p.Can(permission) {
+			abortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortUnauthorized(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+}
+
+func abortForbidden(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+}
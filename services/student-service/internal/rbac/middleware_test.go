@@ -0,0 +1,88 @@
+package rbac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(principal *Principal, guard gin.HandlerFunc, path string) int {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if principal != nil {
+				c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), *principal))
+			}
+		})
+		router.GET("/students/:id", guard, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	student := &Principal{StudentID: 5, Role: RoleStudent}
+	staff := &Principal{StudentID: 6, Role: RoleStaff}
+	admin := &Principal{StudentID: 7, Role: RoleAdmin}
+	unverified := &Principal{StudentID: 8, Role: RoleAdmin, Restricted: true}
+	apiKey := &Principal{Kind: PrincipalAPIKey, APIKeyID: 1, Scopes: []Permission{StudentsRead}}
+
+	cases := []struct {
+		name      string
+		principal *Principal
+		guard     gin.HandlerFunc
+		path      string
+		want      int
+	}{
+		{"anonymous", nil, RequirePermission(StudentsRead), "/students/1", http.StatusUnauthorized},
+		{"student reads", student, RequirePermission(StudentsRead), "/students/1", http.StatusOK},
+		{"student writes", student, RequirePermission(StudentsWrite), "/students/1", http.StatusForbidden},
+		{"staff writes", staff, RequirePermission(StudentsWrite), "/students/1", http.StatusOK},
+		{"staff deletes", staff, RequirePermission(StudentsDelete), "/students/1", http.StatusForbidden},
+		{"all permissions needed", staff, RequirePermission(StudentsRead, RolesManage), "/students/1", http.StatusForbidden},
+		{"admin manages roles", admin, RequirePermission(RolesManage), "/students/1", http.StatusOK},
+		{"staff unlocks", staff, RequirePermission(AccountsUnlock), "/students/1", http.StatusForbidden},
+		{"admin unlocks", admin, RequirePermission(AccountsUnlock), "/students/1", http.StatusOK},
+		{"student edits self", student, RequireSelfOrPermission("id", StudentsWrite), "/students/5", http.StatusOK},
+		{"student edits other", student, RequireSelfOrPermission("id", StudentsWrite), "/students/6", http.StatusForbidden},
+		{"staff edits other", staff, RequireSelfOrPermission("id", StudentsWrite), "/students/5", http.StatusOK},
+		{"anonymous edits", nil, RequireSelfOrPermission("id", StudentsWrite), "/students/0", http.StatusUnauthorized},
+		{"role allowed", staff, RequireRole(RoleStaff, RoleAdmin), "/students/1", http.StatusOK},
+		{"role denied", student, RequireRole(RoleStaff, RoleAdmin), "/students/1", http.StatusForbidden},
+		{"restricted reads", unverified, RequirePermission(StudentsRead), "/students/1", http.StatusForbidden},
+		{"restricted edits self", unverified, RequireSelfOrPermission("id", StudentsWrite), "/students/8", http.StatusForbidden},
+		{"restricted role", unverified, RequireRole(RoleAdmin), "/students/1", http.StatusForbidden},
+		{"API key in scope", apiKey, RequirePermission(StudentsRead), "/students/1", http.StatusOK},
+		{"API key out of scope", apiKey, RequirePermission(StudentsWrite), "/students/1", http.StatusForbidden},
+		{"API key is no student", apiKey, RequireSelfOrPermission("id", StudentsWrite), "/students/0", http.StatusForbidden},
+		{"API key has no role", apiKey, RequireRole(RoleStudent), "/students/1", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, serve(tc.principal, tc.guard, tc.path))
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	role, err := ParseRole("staff")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleStaff, role)
+
+	_, err = ParseRole("root")
+	assert.Error(t, err)
+}
+
+func TestParsePermission(t *testing.T) {
+	permission, err := ParsePermission("students:read")
+	assert.NoError(t, err)
+	assert.Equal(t, StudentsRead, permission)
+
+	_, err = ParsePermission("students:fly")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+// Package rbac defines account roles, the permissions they grant and the gin
+// middleware that authorizes routes. It has no dependency on auth or student
+// so that every handler package can use it.
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Role is stored on the student account and embedded in access tokens
+type Role string
+
+const (
+	RoleStudent Role = "student"
+	RoleStaff   Role = "staff"
+	RoleAdmin   Role = "admin"
+)
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case RoleStudent, RoleStaff, RoleAdmin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+}
+
+// Permission is an action on a resource, named "resource:action"
+type Permission string
+
+const (
+	StudentsRead    Permission = "students:read"
+	StudentsWrite   Permission = "students:write"
+	StudentsDelete  Permission = "students:delete"
+	StudentsExport  Permission = "students:export"
+	ProjectsRead    Permission = "projects:read"
+	MessagesRead    Permission = "messages:read"
+	MessagesWrite   Permission = "messages:write"
+	RolesManage     Permission = "roles:manage"
+	AccountsUnlock  Permission = "accounts:unlock"
+	AccountsSuspend Permission = "accounts:suspend"
+	APIKeysManage   Permission = "api_keys:manage"
+)
+
+// ParsePermission validates a permission name
+func ParsePermission(name string) (Permission, error) {
+	permission := Permission(name)
+	if !slices.Contains(rolePermissions[RoleAdmin], permission) {
+		return "", fmt.Errorf("unknown permission %q", name)
+	}
+	return permission, nil
+}
+
+// rolePermissions is the permission matrix. Ownership is not expressed
+// here: a student may always read and edit their own record.
+var rolePermissions = map[Role][]Permission{
+	RoleStudent: {StudentsRead, ProjectsRead, MessagesRead, MessagesWrite},
+	RoleStaff: {StudentsRead, StudentsWrite, StudentsExport,
+		ProjectsRead, MessagesRead, MessagesWrite},
+	RoleAdmin: {StudentsRead, StudentsWrite, StudentsDelete, StudentsExport,
+		ProjectsRead, MessagesRead, MessagesWrite, RolesManage, AccountsUnlock, AccountsSuspend, APIKeysManage},
+}
+
+// Can reports whether the role grants the permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalKind tells students apart from machine clients
+type PrincipalKind string
+
+const (
+	PrincipalStudent PrincipalKind = "student"
+	PrincipalAPIKey  PrincipalKind = "api_key"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Kind      PrincipalKind
+	StudentID int
+	Role      Role
+	// Restricted is set for sessions of students who have not verified their
+	// email or set up required 2FA yet. Such sessions hold no permissions,
+	// not even on themselves.
+	Restricted bool
+	// APIKeyID and Scopes are set for API keys. A key has no role and no
+	// student; it holds exactly the permissions in Scopes.
+	APIKeyID int64
+	Scopes   []Permission
+}
+
+// IsAPIKey reports whether the caller is a machine client with an API key
+func (p Principal) IsAPIKey() bool {
+	return p.Kind == PrincipalAPIKey
+}
+
+// Can reports whether the principal holds the permission
+func (p Principal) Can(permission Permission) bool {
+	if p.IsAPIKey() {
+		return slices.Contains(p.Scopes, permission)
+	}
+	return !p.Restricted && p.Role.Can(permission)
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores the authenticated caller in ctx
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// GetPrincipal extracts the authenticated caller from ctx
+func GetPrincipal(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
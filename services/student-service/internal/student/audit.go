@@ -0,0 +1,204 @@
+package student
+
+import (
+	"context"
+	"time"
+
+	"student-service/internal/middleware"
+	"student-service/internal/rbac"
+)
+
+// ActorFunc reports who performs the current request, a student or an API
+// key. It is injected so that this package does not depend on auth.
+type ActorFunc func(ctx context.Context) (rbac.Principal, bool)
+
+// auditField is a student field whose changes are recorded in the history.
+// The password is not one of them, see AuditPasswordChange.
+type auditField struct {
+	key   string
+	value func(*Student) interface{}
+}
+
+var auditFields = []auditField{
+	{key: "firstName", value: func(s *Student) interface{} { return s.FirstName }},
+	{key: "lastName", value: func(s *Student) interface{} { return s.LastName }},
+	{key: "email", value: func(s *Student) interface{} { return s.Email }},
+	{key: "major", value: func(s *Student) interface{} { return s.Major }},
+	{key: "year", value: func(s *Student) interface{} { return s.Year }},
+	{key: "role", value: func(s *Student) interface{} { return string(s.Role) }},
+	{key: "verifiedAt", value: func(s *Student) interface{} { return utcTime(s.VerifiedAt) }},
+	{key: "invitedAt", value: func(s *Student) interface{} { return utcTime(s.InvitedAt) }},
+	{key: "suspendedAt", value: func(s *Student) interface{} { return utcTime(s.SuspendedAt) }},
+	{key: "deletedAt", value: func(s *Student) interface{} { return utcTime(s.DeletedAt) }},
+}
+
+func utcTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// diffStudents returns the audited fields that differ between before and
+// after. A nil before is treated as a student with no values, as on create.
+func diffStudents(before, after *Student) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for _, field := range auditFields {
+		var old interface{}
+		if before != nil {
+			old = field.value(before)
+		}
+		current := field.value(after)
+		if !sameValue(old, current) {
+			changes[field.key] = FieldChange{Before: old, After: current}
+		}
+	}
+	return changes
+}
+
+func sameValue(a, b interface{}) bool {
+	at, aok := a.(time.Time)
+	bt, bok := b.(time.Time)
+	if aok && bok {
+		return at.Equal(bt)
+	}
+	return a == b
+}
+
+// auditedRepository records every write to a student in student_audit. Each
+// write runs in a transaction together with its audit entries and locks the
+// row first, so the recorded before state is the one that was overwritten.
+type auditedRepository struct {
+	Repository
+	actor ActorFunc
+}
+
+// NewAuditedRepository wraps repo so that creates, updates, deletes and
+// restores are appended to the student history. actor may be nil.
+func NewAuditedRepository(repo Repository, actor ActorFunc) Repository {
+	return &auditedRepository{Repository: repo, actor: actor}
+}
+
+// RunInTx hands fn an audited repository bound to the transaction
+func (r *auditedRepository) RunInTx(ctx context.Context, fn func(ctx context.Context, repo Repository) error) error {
+	return r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		return fn(ctx, &auditedRepository{Repository: tx, actor: r.actor})
+	})
+}
+
+func (r *auditedRepository) Create(ctx context.Context, student *Student) (*Student, error) {
+	var created *Student
+	err := r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		created, err = tx.Create(ctx, student)
+		if err != nil {
+			return err
+		}
+		return tx.AppendAudit(ctx, r.entry(ctx, created.ID, AuditCreate, diffStudents(nil, created)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *auditedRepository) CreateBatch(ctx context.Context, students []*Student) error {
+	return r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		if err := tx.CreateBatch(ctx, students); err != nil {
+			return err
+		}
+		entries := make([]*AuditEntry, len(students))
+		for i, s := range students {
+			entries[i] = r.entry(ctx, s.ID, AuditCreate, diffStudents(nil, s))
+		}
+		return tx.AppendAudit(ctx, entries...)
+	})
+}
+
+func (r *auditedRepository) Update(ctx context.Context, student *Student, columns ...string) error {
+	return r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		before, err := tx.GetForUpdate(ctx, student.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Update(ctx, student, columns...); err != nil {
+			return err
+		}
+		after, err := tx.GetForUpdate(ctx, student.ID)
+		if err != nil {
+			return err
+		}
+		return tx.AppendAudit(ctx, r.changeEntries(ctx, AuditUpdate, before, after)...)
+	})
+}
+
+func (r *auditedRepository) Delete(ctx context.Context, id int) error {
+	return r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		before, err := tx.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, id); err != nil {
+			return err
+		}
+		after, err := tx.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		return tx.AppendAudit(ctx, r.entry(ctx, id, AuditDelete, diffStudents(before, after)))
+	})
+}
+
+func (r *auditedRepository) Restore(ctx context.Context, id int) (*Student, error) {
+	var restored *Student
+	err := r.Repository.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+		before, err := tx.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		restored, err = tx.Restore(ctx, id)
+		if err != nil || before.DeletedAt == nil {
+			// nothing was restored, so there is nothing to record
+			return err
+		}
+		return tx.AppendAudit(ctx, r.entry(ctx, id, AuditRestore, diffStudents(before, restored)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// changeEntries returns the entries for an update: one with the changed
+// fields, if any, and a redacted one when the password hash changed
+func (r *auditedRepository) changeEntries(ctx context.Context, action string, before, after *Student) []*AuditEntry {
+	var entries []*AuditEntry
+	if changes := diffStudents(before, after); len(changes) > 0 {
+		entries = append(entries, r.entry(ctx, after.ID, action, changes))
+	}
+	if before.Password != after.Password {
+		entries = append(entries, r.entry(ctx, after.ID, AuditPasswordChange, nil))
+	}
+	return entries
+}
+
+func (r *auditedRepository) entry(ctx context.Context, studentID int, action string, changes map[string]FieldChange) *AuditEntry {
+	entry := &AuditEntry{
+		StudentID: studentID,
+		Action:    action,
+		Changes:   changes,
+		RequestID: middleware.GetRequestID(ctx),
+	}
+	if r.actor == nil {
+		return entry
+	}
+	p, ok := r.actor(ctx)
+	switch {
+	case !ok:
+	case p.IsAPIKey():
+		entry.ActorAPIKeyID = &p.APIKeyID
+	case p.StudentID != 0:
+		entry.ActorID = &p.StudentID
+	}
+	return entry
+}
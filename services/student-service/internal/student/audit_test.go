@@ -0,0 +1,75 @@
+package student
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"student-service/internal/rbac"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffStudents(t *testing.T) {
+	before := &Student{ID: 1, FirstName: "Jan", LastName: "Novák", Email: "jan@example.com", Password: "old", Year: 1, Version: 1}
+
+	after := *before
+	after.Year = 2
+	after.Password = "new"
+	after.Version = 2
+	assert.Equal(t, map[string]FieldChange{"year": {Before: 1, After: 2}}, diffStudents(before, &after))
+
+	created := diffStudents(nil, before)
+	assert.Equal(t, FieldChange{Before: nil, After: "jan@example.com"}, created["email"])
+	assert.NotContains(t, created, "password")
+	assert.NotContains(t, created, "deletedAt")
+
+	// the same instant in another location is not a change
+	deletedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	local := deletedAt.In(time.FixedZone("CET", 3600))
+	a, b := *before, *before
+	a.DeletedAt, b.DeletedAt = &deletedAt, &local
+	assert.Empty(t, diffStudents(&a, &b))
+}
+
+func TestAuditedRepository_ChangeEntries(t *testing.T) {
+	r := &auditedRepository{actor: func(ctx context.Context) (rbac.Principal, bool) {
+		return rbac.Principal{Kind: rbac.PrincipalStudent, StudentID: 7}, true
+	}}
+	before := &Student{ID: 3, FirstName: "Jan", Password: "old"}
+	after := &Student{ID: 3, FirstName: "Jan", Password: "new"}
+
+	entries := r.changeEntries(context.Background(), AuditUpdate, before, after)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, AuditPasswordChange, entries[0].Action)
+		assert.Nil(t, entries[0].Changes)
+		assert.Equal(t, 7, *entries[0].ActorID)
+	}
+
+	assert.Empty(t, r.changeEntries(context.Background(), AuditUpdate, before, before))
+}
+
+func TestAuditedRepository_Actor(t *testing.T) {
+	principal := func(p rbac.Principal, ok bool) ActorFunc {
+		return func(ctx context.Context) (rbac.Principal, bool) { return p, ok }
+	}
+
+	apiKey := (&auditedRepository{actor: principal(rbac.Principal{Kind: rbac.PrincipalAPIKey, APIKeyID: 12}, true)}).
+		entry(context.Background(), 3, AuditCreate, nil)
+	assert.Nil(t, apiKey.ActorID)
+	if assert.NotNil(t, apiKey.ActorAPIKeyID) {
+		assert.Equal(t, int64(12), *apiKey.ActorAPIKeyID)
+	}
+
+	stud := (&auditedRepository{actor: principal(rbac.Principal{Kind: rbac.PrincipalStudent, StudentID: 7}, true)}).
+		entry(context.Background(), 3, AuditCreate, nil)
+	assert.Equal(t, 7, *stud.ActorID)
+	assert.Nil(t, stud.ActorAPIKeyID)
+
+	// Without an authenticated caller, as in CLI commands, there is no actor
+	for _, r := range []*auditedRepository{{}, {actor: principal(rbac.Principal{}, false)}} {
+		system := r.entry(context.Background(), 3, AuditCreate, nil)
+		assert.Nil(t, system.ActorID)
+		assert.Nil(t, system.ActorAPIKeyID)
+	}
+}
@@ -0,0 +1,31 @@
+package student
+
+import (
+	"strconv"
+	"strings"
+)
+
+// etag formats a student version as a strong entity tag
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch returns the version required by an If-Match header. An absent
+// header or "*" requires no particular version and yields 0. ok is false when
+// the header can never match a student, which callers answer with 412.
+func parseIfMatch(header string) (version int, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	// If-Match uses strong comparison, so weak tags never match
+	if !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || len(header) < 2 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,34 @@
+package student
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int
+		ok      bool
+	}{
+		{"", 0, true},
+		{"*", 0, true},
+		{`"3"`, 3, true},
+		{` "12" `, 12, true},
+		{`W/"3"`, 0, false},
+		{`"0"`, 0, false},
+		{`"abc"`, 0, false},
+		{`"1", "2"`, 0, false},
+		{`3`, 0, false},
+		{`"`, 0, false},
+	}
+
+	for _, tt := range tests {
+		version, ok := parseIfMatch(tt.header)
+		assert.Equal(t, tt.ok, ok, tt.header)
+		assert.Equal(t, tt.version, version, tt.header)
+	}
+
+	assert.Equal(t, `"7"`, etag(7))
+}
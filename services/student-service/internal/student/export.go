@@ -0,0 +1,348 @@
+package student
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExportColumn is a student field that can be exported. The password is
+// deliberately not one of them.
+type ExportColumn struct {
+	// Key is the JSON field name used in the columns parameter and headers
+	Key    string
+	column string
+	value  func(*Student) interface{}
+}
+
+// exportColumns lists the exportable columns in their default order
+var exportColumns = []ExportColumn{
+	{Key: "id", column: "id", value: func(s *Student) interface{} { return s.ID }},
+	{Key: "firstName", column: "first_name", value: func(s *Student) interface{} { return s.FirstName }},
+	{Key: "lastName", column: "last_name", value: func(s *Student) interface{} { return s.LastName }},
+	{Key: "email", column: "email", value: func(s *Student) interface{} { return s.Email }},
+	{Key: "major", column: "major", value: func(s *Student) interface{} { return s.Major }},
+	{Key: "year", column: "year", value: func(s *Student) interface{} { return s.Year }},
+}
+
+// ParseExportColumns resolves a comma-separated list of column keys. An empty
+// list selects all exportable columns.
+func ParseExportColumns(list string) ([]ExportColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return exportColumns, nil
+	}
+
+	var columns []ExportColumn
+	seen := map[string]bool{}
+	for _, key := range strings.Split(list, ",") {
+		key = strings.TrimSpace(key)
+		if seen[key] {
+			continue
+		}
+		column, ok := findExportColumn(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown export column %q", ErrInvalidInput, key)
+		}
+		seen[key] = true
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func findExportColumn(key string) (ExportColumn, bool) {
+	for _, column := range exportColumns {
+		if column.Key == key {
+			return column, true
+		}
+	}
+	return ExportColumn{}, false
+}
+
+// ExportFormat is the file format of an export
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+	ExportXLSX   ExportFormat = "xlsx"
+)
+
+// ParseExportFormat validates an export format name; empty means CSV
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch format := ExportFormat(name); format {
+	case "":
+		return ExportCSV, nil
+	case ExportCSV, ExportNDJSON, ExportXLSX:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: unknown export format %q", ErrInvalidInput, name)
+	}
+}
+
+// ContentType returns the media type of the export format
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// ExportWriter encodes exported students one at a time
+type ExportWriter interface {
+	Write(s *Student) error
+	// Close writes any trailing data; it does not close the underlying writer
+	Close() error
+}
+
+// NewExportWriter returns a writer for the format. The header, if the format
+// has one, is written before the first row.
+func NewExportWriter(format ExportFormat, w io.Writer, columns []ExportColumn) (ExportWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVExportWriter(w, columns), nil
+	case ExportNDJSON:
+		return &ndjsonExportWriter{enc: json.NewEncoder(w), columns: columns}, nil
+	case ExportXLSX:
+		return newXLSXExportWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("%w: unknown export format %q", ErrInvalidInput, format)
+	}
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []ExportColumn
+	record  []string
+	header  bool
+}
+
+func newCSVExportWriter(w io.Writer, columns []ExportColumn) *csvExportWriter {
+	return &csvExportWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+}
+
+func (c *csvExportWriter) Write(s *Student) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	for i, column := range c.columns {
+		c.record[i] = csvCell(column.value(s))
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvExportWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	for i, column := range c.columns {
+		c.record[i] = column.Key
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvExportWriter) Close() error {
+	// an empty export still gets its header
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvCell formats a value for CSV. Text that a spreadsheet would evaluate
+// as a formula is prefixed with a quote.
+func csvCell(value interface{}) string {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+type ndjsonExportWriter struct {
+	enc     *json.Encoder
+	columns []ExportColumn
+}
+
+func (n *ndjsonExportWriter) Write(s *Student) error {
+	row := make(orderedRow, len(n.columns))
+	for i, column := range n.columns {
+		row[i] = rowField{key: column.Key, value: column.value(s)}
+	}
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+type rowField struct {
+	key   string
+	value interface{}
+}
+
+// orderedRow marshals to a JSON object that keeps the requested column order
+type orderedRow []rowField
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, field := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+// xlsxExportWriter writes a single-sheet workbook. Rows go straight into the
+// zip stream, so nothing but the current row is held in memory. Like the other
+// writers it writes nothing until the first row or Close.
+type xlsxExportWriter struct {
+	w       io.Writer
+	zw      *zip.Writer
+	sheet   *bufio.Writer
+	columns []ExportColumn
+	row     int
+}
+
+const xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const xlsxSheetFooter = `</sheetData></worksheet>`
+
+// xlsxParts are the fixed parts of the workbook package
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Students" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXExportWriter(w io.Writer, columns []ExportColumn) (*xlsxExportWriter, error) {
+	return &xlsxExportWriter{w: w, columns: columns}, nil
+}
+
+// start writes the fixed workbook parts and the sheet header row
+func (x *xlsxExportWriter) start() error {
+	if x.zw != nil {
+		return nil
+	}
+
+	x.zw = zip.NewWriter(x.w)
+	for _, part := range xlsxParts {
+		f, err := x.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	// The worksheet is the last entry so rows can be streamed into it
+	f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(xlsxSheetHeader)
+
+	header := make([]interface{}, len(x.columns))
+	for i, column := range x.columns {
+		header[i] = column.Key
+	}
+	return x.writeRow(header)
+}
+
+func (x *xlsxExportWriter) Write(s *Student) error {
+	if err := x.start(); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(x.columns))
+	for i, column := range x.columns {
+		values[i] = column.value(s)
+	}
+	return x.writeRow(values)
+}
+
+func (x *xlsxExportWriter) writeRow(values []interface{}) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for i, value := range values {
+		ref := xlsxColumnName(i) + strconv.Itoa(x.row)
+		switch v := value.(type) {
+		case int:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			if err := xml.EscapeText(x.sheet, []byte(fmt.Sprint(v))); err != nil {
+				return err
+			}
+			x.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	if err := x.start(); err != nil {
+		return err
+	}
+	x.sheet.WriteString(xlsxSheetFooter)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumnName converts a zero-based column index to a spreadsheet column name (A, B, ..., AA)
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
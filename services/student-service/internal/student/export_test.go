@@ -0,0 +1,114 @@
+package student
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var exportStudents = []*Student{
+	{ID: 1, FirstName: "Jan", LastName: "Novák", Email: "jan@example.com", Password: "secret-hash", Major: "Physics", Year: 2},
+	{ID: 2, FirstName: "=cmd", LastName: "O'Neil & <Sons>", Email: "eva@example.com", Password: "secret-hash", Year: 1},
+}
+
+func export(t *testing.T, format ExportFormat, columns string, students []*Student) []byte {
+	t.Helper()
+	cols, err := ParseExportColumns(columns)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := NewExportWriter(format, &buf, cols)
+	require.NoError(t, err)
+	for _, s := range students {
+		require.NoError(t, w.Write(s))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestParseExportColumns(t *testing.T) {
+	columns, err := ParseExportColumns("")
+	require.NoError(t, err)
+	assert.Len(t, columns, 6)
+
+	columns, err = ParseExportColumns("email, id,email")
+	require.NoError(t, err)
+	require.Len(t, columns, 2)
+	assert.Equal(t, "email", columns[0].Key)
+	assert.Equal(t, "id", columns[1].Key)
+
+	_, err = ParseExportColumns("id,password")
+	assert.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = ParseExportFormat("pdf")
+	assert.ErrorIs(t, err, ErrInvalidInput)
+}
+
+func TestExportCSV(t *testing.T) {
+	out := string(export(t, ExportCSV, "", exportStudents))
+	assert.Equal(t, "id,firstName,lastName,email,major,year\n"+
+		"1,Jan,Novák,jan@example.com,Physics,2\n"+
+		"2,'=cmd,O'Neil & <Sons>,eva@example.com,,1\n", out)
+	assert.NotContains(t, out, "secret-hash")
+
+	assert.Equal(t, "email\n", string(export(t, ExportCSV, "email", nil)))
+}
+
+func TestExportNDJSON(t *testing.T) {
+	out := string(export(t, ExportNDJSON, "year,email", exportStudents))
+	assert.Equal(t, `{"year":2,"email":"jan@example.com"}`+"\n"+
+		`{"year":1,"email":"eva@example.com"}`+"\n", out)
+}
+
+func TestExportXLSX(t *testing.T) {
+	out := export(t, ExportXLSX, "id,lastName", exportStudents)
+
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+
+	var names []string
+	var sheet []byte
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+
+		// every part must be well-formed XML
+		dec := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, f.Name)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			sheet = content
+		}
+	}
+	assert.Contains(t, names, "[Content_Types].xml")
+	assert.Contains(t, names, "xl/workbook.xml")
+
+	s := string(sheet)
+	assert.Contains(t, s, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	assert.Contains(t, s, `<c r="A3"><v>2</v></c>`)
+	assert.Contains(t, s, "O&#39;Neil &amp; &lt;Sons&gt;")
+	assert.False(t, strings.Contains(s, "secret-hash"))
+}
+
+func TestXLSXColumnName(t *testing.T) {
+	assert.Equal(t, "A", xlsxColumnName(0))
+	assert.Equal(t, "Z", xlsxColumnName(25))
+	assert.Equal(t, "AA", xlsxColumnName(26))
+	assert.Equal(t, "AZ", xlsxColumnName(51))
+	assert.Equal(t, "BA", xlsxColumnName(52))
+}
@@ -4,20 +4,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	commonmetrics "grud/common/metrics"
 	"grud/testing/testdb"
+	"student-service/internal/auth"
 	"student-service/internal/metrics"
+	"student-service/internal/middleware"
+	"student-service/internal/rbac"
 	"student-service/internal/student"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
 )
 
 func TestStudentService_Shared(t *testing.T) {
@@ -26,17 +33,56 @@ func TestStudentService_Shared(t *testing.T) {
 	pgContainer := testdb.SetupSharedPostgres(t)
 	defer pgContainer.Cleanup(t)
 
-	pgContainer.RunMigrations(t, (*student.Student)(nil))
+	pgContainer.RunMigrations(t, (*student.Student)(nil), (*auth.RefreshToken)(nil), (*auth.AccountToken)(nil), (*auth.TOTPCredential)(nil), (*auth.RecoveryCode)(nil), (*auth.FederatedIdentity)(nil), (*auth.APIKey)(nil), (*student.AuditEntry)(nil))
 
 	// Create handler ONCE and reuse across all subtests
 	mockServiceMetrics := metrics.NewMock()
 	mockRepoMetrics := commonmetrics.NewMock()
-	repo := student.NewRepository(pgContainer.DB, mockRepoMetrics)
+	const actorID = 42
+	// Requests made with an API key are attributed to it, anything else to
+	// the admin
+	actor := func(ctx context.Context) (rbac.Principal, bool) {
+		if p, ok := rbac.GetPrincipal(ctx); ok && p.IsAPIKey() {
+			return p, true
+		}
+		return rbac.Principal{Kind: rbac.PrincipalStudent, StudentID: actorID, Role: rbac.RoleAdmin}, true
+	}
+	// Purges go through the hook of auth and are recorded
+	var purgedEmails []string
+	recordPurge := func(ctx context.Context, _ bun.IDB, students []student.Student) error {
+		for _, s := range students {
+			purgedEmails = append(purgedEmails, s.Email)
+		}
+		return nil
+	}
+	authRepo := auth.NewRepository(pgContainer.DB, mockRepoMetrics)
+	repo := student.NewAuditedRepository(student.NewRepository(pgContainer.DB, mockRepoMetrics, authRepo.PurgeStudents, recordPurge), actor)
 	service := student.NewService(repo)
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
-	handler := student.NewHandler(service, logger, mockServiceMetrics)
-	router := gin.New()
-	handler.RegisterRoutes(router)
+	// The inviter records whom it invited, the emails are tested in auth
+	var invited []int
+	inviter := inviterFunc(func(ctx context.Context, id int) error {
+		stud, err := repo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !stud.Invited() {
+			return student.ErrNotInvited
+		}
+		invited = append(invited, id)
+		return nil
+	})
+	handler := student.NewHandler(service, inviter, logger, mockServiceMetrics)
+	newRouter := func(principal rbac.Principal) *gin.Engine {
+		router := gin.New()
+		router.Use(middleware.RequestID())
+		router.Use(func(c *gin.Context) {
+			c.Request = c.Request.WithContext(rbac.WithPrincipal(c.Request.Context(), principal))
+		})
+		handler.RegisterRoutes(router)
+		return router
+	}
+	router := newRouter(rbac.Principal{StudentID: actorID, Role: rbac.RoleAdmin})
 
 	t.Run("CreateStudent", func(t *testing.T) {
 		// Only cleanup tables, reuse handler
@@ -65,6 +111,55 @@ func TestStudentService_Shared(t *testing.T) {
 		assert.NotZero(t, response.ID)
 	})
 
+	t.Run("CreateStudentInvites", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+		invited = nil
+
+		body := `{"firstName":"Invited","lastName":"Student","email":"invited@example.com","verifiedAt":"2026-01-01T00:00:00Z"}`
+		req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var created student.Student
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.Equal(t, []int{created.ID}, invited)
+
+		// No usable password until the invitation is accepted
+		stored, err := repo.GetByID(context.Background(), created.ID)
+		require.NoError(t, err)
+		assert.True(t, stored.Invited())
+		assert.Empty(t, stored.Password)
+		assert.Nil(t, stored.VerifiedAt)
+
+		resend := func(id string) int {
+			req := httptest.NewRequest(http.MethodPost, "/students/"+id+"/invitation", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusNoContent, resend(fmt.Sprint(created.ID)))
+		assert.Equal(t, []int{created.ID, created.ID}, invited)
+		assert.Equal(t, http.StatusNotFound, resend("999999"))
+		assert.Equal(t, http.StatusBadRequest, resend("abc"))
+
+		// Once accepted there is nothing to resend
+		now := time.Now()
+		stored.InvitedAt = nil
+		stored.Password = "hash"
+		stored.VerifiedAt = &now
+		require.NoError(t, repo.Update(context.Background(), stored, "password", "invited_at", "verified_at"))
+		assert.Equal(t, http.StatusConflict, resend(fmt.Sprint(created.ID)))
+
+		// Students may not resend invitations
+		studentRouter := newRouter(rbac.Principal{StudentID: actorID, Role: rbac.RoleStudent})
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/students/%d/invitation", created.ID), nil)
+		w = httptest.NewRecorder()
+		studentRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
 	t.Run("GetStudent", func(t *testing.T) {
 		// Only cleanup tables, reuse handler
 		testdb.CleanupTables(t, pgContainer.DB, "students")
@@ -127,11 +222,14 @@ func TestStudentService_Shared(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var response []student.Student
-		err := json.NewDecoder(w.Body).Decode(&response)
+		var page student.Page
+		err := json.NewDecoder(w.Body).Decode(&page)
 		require.NoError(t, err)
 
-		assert.Len(t, response, 2)
+		assert.Equal(t, 2, page.TotalCount)
+		assert.Empty(t, page.NextCursor)
+		response := page.Items
+		require.Len(t, response, 2)
 
 		// Verify first student
 		assert.Equal(t, "Student", response[0].FirstName)
@@ -150,6 +248,92 @@ func TestStudentService_Shared(t *testing.T) {
 		assert.NotZero(t, response[1].ID)
 	})
 
+	t.Run("ListStudents_CursorPagination", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		for _, s := range []*student.Student{
+			{FirstName: "Ann", LastName: "Adams", Email: "ann@example.com", Major: "Physics", Year: 1},
+			{FirstName: "Bob", LastName: "Brown", Email: "bob@example.com", Major: "Physics", Year: 2},
+			{FirstName: "Cid", LastName: "Clark", Email: "cid@example.com", Major: "Physics", Year: 3},
+			{FirstName: "Dan", LastName: "Davis", Email: "dan@example.com", Major: "Physics", Year: 4},
+			{FirstName: "Eve", LastName: "Evans", Email: "eve@example.com", Major: "History", Year: 2},
+		} {
+			_, err := pgContainer.DB.NewInsert().Model(s).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		var names []string
+		cursor := ""
+		for i := 0; i < 3; i++ {
+			url := "/students?major=Physics&sort=-lastName&limit=3"
+			if cursor != "" {
+				url += "&cursor=" + cursor
+			}
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var page student.Page
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+			assert.Equal(t, 4, page.TotalCount)
+			for _, s := range page.Items {
+				names = append(names, s.LastName)
+			}
+			cursor = page.NextCursor
+			if cursor == "" {
+				break
+			}
+		}
+
+		assert.Equal(t, []string{"Davis", "Clark", "Brown", "Adams"}, names)
+
+		// The repository applies the default page size itself
+		page, err := repo.List(ctx, student.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, page.Items, 5)
+		assert.Empty(t, page.NextCursor)
+		_, err = repo.List(ctx, student.ListOptions{Limit: -1})
+		assert.ErrorIs(t, err, student.ErrInvalidInput)
+	})
+
+	t.Run("ListStudents_Filters", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		for _, s := range []*student.Student{
+			{FirstName: "Ann", LastName: "Adams", Email: "ann@uni.example.com", Major: "Physics", Year: 1},
+			{FirstName: "Bob", LastName: "Annan", Email: "bob@uni.example.com", Major: "Physics", Year: 3},
+			{FirstName: "Cid", LastName: "Clark", Email: "cid@other.example.com", Major: "Physics", Year: 3},
+		} {
+			_, err := pgContainer.DB.NewInsert().Model(s).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/students?name=ann&year_min=2&year_max=4&email_prefix=bob", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var page student.Page
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Equal(t, 1, page.TotalCount)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "bob@uni.example.com", page.Items[0].Email)
+	})
+
+	t.Run("ListStudents_InvalidParams", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		for _, query := range []string{"sort=password", "cursor=garbage", "limit=abc", "year_min=5&year_max=1"} {
+			req := httptest.NewRequest(http.MethodGet, "/students?"+query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
 	t.Run("GetStudentNotFound", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students")
 
@@ -224,6 +408,172 @@ func TestStudentService_Shared(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 
+	t.Run("UpdateStudentKeepsPassword", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		testStudent := &student.Student{
+			FirstName: "Keep",
+			LastName:  "Password",
+			Email:     "keep@example.com",
+			Password:  "hashed-password",
+			Major:     "Art",
+			Year:      1,
+		}
+		_, err := pgContainer.DB.NewInsert().Model(testStudent).Exec(ctx)
+		require.NoError(t, err)
+
+		payload := map[string]interface{}{
+			"firstName": "Kept",
+			"lastName":  "Password",
+			"email":     "keep@example.com",
+			"major":     "Art",
+			"year":      2,
+		}
+		body, _ := json.Marshal(payload)
+
+		req := httptest.NewRequest(http.MethodPut, "/students/1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stored := new(student.Student)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(stored).Where("id = ?", testStudent.ID).Scan(ctx))
+		assert.Equal(t, "Kept", stored.FirstName)
+		assert.Equal(t, "hashed-password", stored.Password)
+	})
+
+	t.Run("PatchStudent", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		testStudent := &student.Student{
+			FirstName: "Patch",
+			LastName:  "Me",
+			Email:     "patch@example.com",
+			Password:  "hashed-password",
+			Major:     "Biology",
+			Year:      2,
+		}
+		_, err := pgContainer.DB.NewInsert().Model(testStudent).Exec(ctx)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPatch, "/students/1", bytes.NewReader([]byte(`{"year":3,"major":null}`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response student.Student
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, 3, response.Year)
+		assert.Empty(t, response.Major)
+		assert.Equal(t, "Patch", response.FirstName)
+
+		stored := new(student.Student)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(stored).Where("id = ?", testStudent.ID).Scan(ctx))
+		assert.Equal(t, 3, stored.Year)
+		assert.Empty(t, stored.Major)
+		assert.Equal(t, "patch@example.com", stored.Email)
+		assert.Equal(t, "hashed-password", stored.Password)
+	})
+
+	t.Run("PatchStudentInvalid", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Patch", LastName: "Me", Email: "patch@example.com", Password: "x", Year: 2,
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		for _, patch := range []string{`{"email":"not-an-email"}`, `{"firstName":null}`, `{"password":"secret"}`, `[]`} {
+			req := httptest.NewRequest(http.MethodPatch, "/students/1", bytes.NewReader([]byte(patch)))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, patch)
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, "/students/1", bytes.NewReader([]byte(`{"year":1}`)))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+		req = httptest.NewRequest(http.MethodPatch, "/students/99999", bytes.NewReader([]byte(`{"year":1}`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("OptimisticConcurrency", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Etag", LastName: "Student", Email: "etag@example.com", Password: "x", Year: 1,
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/students/1", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+		assert.Equal(t, `"1"`, etag)
+
+		// First writer wins and gets a new ETag
+		req = httptest.NewRequest(http.MethodPatch, "/students/1", bytes.NewReader([]byte(`{"year":2}`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", etag)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+		// Second writer holds a stale ETag
+		req = httptest.NewRequest(http.MethodPatch, "/students/1", bytes.NewReader([]byte(`{"year":3}`)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", etag)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"firstName": "Etag", "lastName": "Student", "email": "etag@example.com", "year": 4,
+		})
+		req = httptest.NewRequest(http.MethodPut, "/students/1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		req = httptest.NewRequest(http.MethodPut, "/students/1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", `"2"`)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+		stored := new(student.Student)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(stored).Where("id = 1").Scan(ctx))
+		assert.Equal(t, 4, stored.Year)
+		assert.Equal(t, 3, stored.Version)
+
+		// A stale ETag on a missing student is still a 404
+		req = httptest.NewRequest(http.MethodPut, "/students/99999", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", `"1"`)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
 	t.Run("DeleteStudent", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students")
 
@@ -254,6 +604,469 @@ func TestStudentService_Shared(t *testing.T) {
 		assert.Equal(t, 0, count)
 	})
 
+	t.Run("SoftDeleteAndRestore", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		for _, email := range []string{"kept@example.com", "removed@example.com"} {
+			_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+				FirstName: "Soft", LastName: "Delete", Email: email, Password: "x", Year: 1,
+			}).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, "/students/2", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		// The row is kept with deleted_at set
+		stored := new(student.Student)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(stored).WhereAllWithDeleted().Where("id = 2").Scan(ctx))
+		require.NotNil(t, stored.DeletedAt)
+
+		req = httptest.NewRequest(http.MethodGet, "/students/2", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		req = httptest.NewRequest(http.MethodDelete, "/students/2", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		var page student.Page
+		req = httptest.NewRequest(http.MethodGet, "/students", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Equal(t, 1, page.TotalCount)
+
+		req = httptest.NewRequest(http.MethodGet, "/students?include_deleted=true", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		require.Equal(t, 2, page.TotalCount)
+		assert.Nil(t, page.Items[0].DeletedAt)
+		assert.NotNil(t, page.Items[1].DeletedAt)
+
+		// Only those who may delete students see the deleted ones
+		for _, principal := range []rbac.Principal{
+			{StudentID: actorID, Role: rbac.RoleStudent},
+			{StudentID: actorID, Role: rbac.RoleStaff},
+			{Kind: rbac.PrincipalAPIKey, APIKeyID: 1, Scopes: []rbac.Permission{rbac.StudentsRead, rbac.StudentsExport}},
+		} {
+			for _, path := range []string{"/students?include_deleted=true", "/students/export?include_deleted=true"} {
+				req = httptest.NewRequest(http.MethodGet, path, nil)
+				w = httptest.NewRecorder()
+				newRouter(principal).ServeHTTP(w, req)
+				assert.Equal(t, http.StatusForbidden, w.Code, "%s %+v", path, principal)
+				assert.NotContains(t, w.Body.String(), "removed@example.com")
+			}
+		}
+
+		// The email stays reserved while the student is only soft-deleted
+		body, _ := json.Marshal(map[string]interface{}{
+			"firstName": "Soft", "lastName": "Delete", "email": "removed@example.com", "year": 1,
+		})
+		req = httptest.NewRequest(http.MethodPost, "/students", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/students/2/restore", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var restored student.Student
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&restored))
+		assert.Equal(t, "removed@example.com", restored.Email)
+		assert.Nil(t, restored.DeletedAt)
+
+		req = httptest.NewRequest(http.MethodGet, "/students/2", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Restoring an active student is a no-op, an unknown one is a 404
+		req = httptest.NewRequest(http.MethodPost, "/students/2/restore", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/students/99999/restore", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("PurgeDeletedStudents", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "refresh_tokens", "account_tokens", "api_keys")
+		purgedEmails = nil
+
+		ctx := context.Background()
+		old := time.Now().Add(-48 * time.Hour)
+		recent := time.Now().Add(-time.Hour)
+		students := []*student.Student{
+			{FirstName: "Active", LastName: "Student", Email: "active@example.com", Password: "x"},
+			{FirstName: "Old", LastName: "Delete", Email: "old@example.com", Password: "x", DeletedAt: &old},
+			{FirstName: "Recent", LastName: "Delete", Email: "recent@example.com", Password: "x", DeletedAt: &recent},
+		}
+		for _, s := range students {
+			_, err := pgContainer.DB.NewInsert().Model(s).Exec(ctx)
+			require.NoError(t, err)
+			_, err = pgContainer.DB.NewInsert().Model(&auth.RefreshToken{
+				StudentID: s.ID, Token: "token-" + s.Email, ExpiresAt: time.Now().Add(time.Hour),
+			}).Exec(ctx)
+			require.NoError(t, err)
+			_, err = pgContainer.DB.NewInsert().Model(&auth.AccountToken{
+				StudentID: s.ID, Purpose: auth.PurposePasswordReset, TokenHash: "hash-" + s.Email, Email: s.Email, ExpiresAt: time.Now().Add(time.Hour),
+			}).Exec(ctx)
+			require.NoError(t, err)
+			createdBy := s.ID
+			_, err = pgContainer.DB.NewInsert().Model(&auth.APIKey{
+				Name: "key of " + s.Email, Prefix: "grud_", KeyHash: "hash-" + s.Email, Scopes: []rbac.Permission{rbac.StudentsRead}, CreatedBy: &createdBy, ExpiresAt: time.Now().Add(time.Hour),
+			}).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		purged, err := service.PurgeDeletedStudents(ctx, 24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.Equal(t, []string{"old@example.com"}, purgedEmails)
+
+		var emails []string
+		err = pgContainer.DB.NewSelect().Model((*student.Student)(nil)).WhereAllWithDeleted().
+			Column("email").Order("id").Scan(ctx, &emails)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"active@example.com", "recent@example.com"}, emails)
+
+		tokens, err := pgContainer.DB.NewSelect().Model((*auth.RefreshToken)(nil)).Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, tokens)
+
+		// Emailed tokens go too, with the address they were sent to
+		var mailedTo []string
+		err = pgContainer.DB.NewSelect().Model((*auth.AccountToken)(nil)).Column("email").Order("id").Scan(ctx, &mailedTo)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"active@example.com", "recent@example.com"}, mailedTo)
+
+		// API keys stay, without their creator
+		var keys []auth.APIKey
+		require.NoError(t, pgContainer.DB.NewSelect().Model(&keys).Order("id").Scan(ctx))
+		require.Len(t, keys, 3)
+		assert.NotNil(t, keys[0].CreatedBy)
+		assert.Nil(t, keys[1].CreatedBy)
+		assert.NotNil(t, keys[2].CreatedBy)
+
+		// Nothing to purge runs no hooks
+		purgedEmails = nil
+		purged, err = service.PurgeDeletedStudents(ctx, 24*time.Hour)
+		require.NoError(t, err)
+		assert.Zero(t, purged)
+		assert.Empty(t, purgedEmails)
+	})
+
+	t.Run("ImportStudents", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+			FirstName: "Existing", LastName: "Student", Email: "existing@example.com", Password: "x",
+		}).Exec(ctx)
+		require.NoError(t, err)
+
+		csvBody := "firstName,lastName,email,major,year\n" +
+			"Jan,Novák,jan@example.com,Physics,2\n" +
+			"Eva,Svobodová,not-an-email,,1\n" +
+			"Petr,Dvořák,JAN@example.com,,1\n" +
+			"Karel,Čapek,existing@example.com,,1\n" +
+			"Marie,Curie,marie@example.com,Chemistry,3\n"
+
+		importCSV := func(query string) (int, student.ImportReport) {
+			req := httptest.NewRequest(http.MethodPost, "/students/import"+query, strings.NewReader(csvBody))
+			req.Header.Set("Content-Type", "text/csv")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var report student.ImportReport
+			if w.Code < http.StatusBadRequest || w.Code == http.StatusUnprocessableEntity {
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+			}
+			return w.Code, report
+		}
+		countStudents := func() int {
+			count, err := pgContainer.DB.NewSelect().Model((*student.Student)(nil)).Count(ctx)
+			require.NoError(t, err)
+			return count
+		}
+
+		// Dry run reports every row without writing anything
+		code, report := importCSV("?dry_run=true")
+		require.Equal(t, http.StatusOK, code)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 5, report.Total)
+		assert.Equal(t, 2, report.Valid)
+		assert.Equal(t, 3, report.Invalid)
+		assert.Equal(t, 0, report.Imported)
+		require.Len(t, report.Rows, 5)
+		assert.Equal(t, student.RowValid, report.Rows[0].Status)
+		assert.Equal(t, 2, report.Rows[0].Line)
+		assert.Equal(t, student.RowInvalid, report.Rows[1].Status)
+		assert.Contains(t, report.Rows[1].Errors[0], "email")
+		assert.Equal(t, []string{"email duplicates line 2"}, report.Rows[2].Errors)
+		assert.Equal(t, []string{"email already exists"}, report.Rows[3].Errors)
+		assert.Equal(t, 1, countStudents())
+
+		// All-or-nothing refuses the file because of the invalid rows
+		code, report = importCSV("")
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+		assert.Equal(t, 0, report.Imported)
+		assert.Equal(t, 1, countStudents())
+
+		// Skip-invalid imports the valid rows only
+		invited = nil
+		code, report = importCSV("?mode=skip_invalid")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, student.RowImported, report.Rows[0].Status)
+		assert.NotZero(t, report.Rows[0].ID)
+		assert.Equal(t, student.RowImported, report.Rows[4].Status)
+		assert.Equal(t, []int{report.Rows[0].ID, report.Rows[4].ID}, invited)
+		assert.Equal(t, 3, countStudents())
+
+		imported := new(student.Student)
+		require.NoError(t, pgContainer.DB.NewSelect().Model(imported).Where("email = ?", "marie@example.com").Scan(ctx))
+		assert.Equal(t, "Chemistry", imported.Major)
+		assert.True(t, imported.Invited())
+		assert.Empty(t, imported.Password)
+	})
+
+	t.Run("ImportStudentsNDJSON", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		body := `{"firstName":"Jan","lastName":"Novák","email":"jan@example.com","year":1}` + "\n" +
+			`{"firstName":"Eva","lastName":"Svobodová","email":"eva@example.com","major":"Math"}` + "\n"
+
+		req := httptest.NewRequest(http.MethodPost, "/students/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var report student.ImportReport
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		assert.Equal(t, 2, report.Imported)
+
+		count, err := pgContainer.DB.NewSelect().Model((*student.Student)(nil)).Count(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		for _, query := range []string{"?mode=everything", "?dry_run=maybe"} {
+			req = httptest.NewRequest(http.MethodPost, "/students/import"+query, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-ndjson")
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/students/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("ExportStudents", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students")
+
+		ctx := context.Background()
+		for i, major := range []string{"Physics", "Math", "Physics"} {
+			_, err := pgContainer.DB.NewInsert().Model(&student.Student{
+				FirstName: "Export",
+				LastName:  string(rune('A' + i)),
+				Email:     fmt.Sprintf("export%d@example.com", i),
+				Password:  "secret-hash",
+				Major:     major,
+				Year:      i + 1,
+			}).Exec(ctx)
+			require.NoError(t, err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/students/export?format=csv&major=Physics&sort=-year&columns=email,year", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "students.csv")
+		assert.Equal(t, "email,year\nexport2@example.com,3\nexport0@example.com,1\n", w.Body.String())
+
+		req = httptest.NewRequest(http.MethodGet, "/students/export?format=ndjson", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Len(t, lines, 3)
+		assert.NotContains(t, w.Body.String(), "secret-hash")
+		assert.NotContains(t, w.Body.String(), "password")
+
+		req = httptest.NewRequest(http.MethodGet, "/students/export?format=xlsx", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "PK", w.Body.String()[:2])
+
+		for _, query := range []string{"?format=pdf", "?columns=password", "?sort=password", "?year_min=3&year_max=1"} {
+			req = httptest.NewRequest(http.MethodGet, "/students/export"+query, nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), query)
+		}
+	})
+
+	t.Run("StudentHistory", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "student_audit")
+
+		ctx := context.Background()
+		created, err := repo.Create(ctx, &student.Student{
+			FirstName: "Audit",
+			LastName:  "Trail",
+			Email:     "audit.trail@example.com",
+			Password:  "hash-1",
+			Major:     "History",
+			Year:      1,
+		})
+		require.NoError(t, err)
+
+		// a patch, a password change and a delete
+		patch := strings.NewReader(`{"year": 2}`)
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/students/%d", created.ID), patch)
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set(middleware.RequestIDHeader, "req-patch-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "req-patch-1", w.Header().Get(middleware.RequestIDHeader))
+
+		created.Password = "hash-2"
+		created.Version = 0
+		require.NoError(t, repo.Update(ctx, created, "password"))
+		require.NoError(t, repo.Delete(ctx, created.ID))
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/students/%d/history", created.ID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "hash-")
+
+		var history []student.AuditEntry
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+		require.Len(t, history, 4)
+
+		assert.Equal(t, student.AuditCreate, history[0].Action)
+		assert.Equal(t, "audit.trail@example.com", history[0].Changes["email"].After)
+		require.NotNil(t, history[0].ActorID)
+		assert.Equal(t, actorID, *history[0].ActorID)
+
+		assert.Equal(t, student.AuditUpdate, history[1].Action)
+		assert.Equal(t, "req-patch-1", history[1].RequestID)
+		assert.Equal(t, map[string]student.FieldChange{"year": {Before: float64(1), After: float64(2)}}, history[1].Changes)
+
+		assert.Equal(t, student.AuditPasswordChange, history[2].Action)
+		assert.Empty(t, history[2].Changes)
+
+		assert.Equal(t, student.AuditDelete, history[3].Action)
+		assert.Contains(t, history[3].Changes, "deletedAt")
+
+		// writes made with an API key name the key
+		asAPIKey := newRouter(rbac.Principal{Kind: rbac.PrincipalAPIKey, APIKeyID: 9, Scopes: []rbac.Permission{rbac.StudentsWrite}})
+		req = httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"firstName":"Machine","lastName":"Made","email":"machine.made@example.com","year":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		asAPIKey.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var machineMade student.Student
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&machineMade))
+		machineHistory, err := repo.History(ctx, machineMade.ID)
+		require.NoError(t, err)
+		require.Len(t, machineHistory, 1)
+		assert.Nil(t, machineHistory[0].ActorID)
+		require.NotNil(t, machineHistory[0].ActorAPIKeyID)
+		assert.Equal(t, int64(9), *machineHistory[0].ActorAPIKeyID)
+
+		// the history outlives the soft delete, unknown students are 404
+		req = httptest.NewRequest(http.MethodGet, "/students/999/history", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("RoleBasedAccess", func(t *testing.T) {
+		testdb.CleanupTables(t, pgContainer.DB, "students", "student_audit")
+
+		ctx := context.Background()
+		self, err := repo.Create(ctx, &student.Student{FirstName: "Self", LastName: "Student", Email: "self@example.com", Password: "x"})
+		require.NoError(t, err)
+		other, err := repo.Create(ctx, &student.Student{FirstName: "Other", LastName: "Student", Email: "other@example.com", Password: "x"})
+		require.NoError(t, err)
+		assert.Equal(t, rbac.RoleStudent, self.Role)
+
+		do := func(router *gin.Engine, method, path, body string) int {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+		patch := `{"year": 3}`
+
+		asStudent := newRouter(rbac.Principal{StudentID: self.ID, Role: rbac.RoleStudent})
+		assert.Equal(t, http.StatusOK, do(asStudent, http.MethodGet, fmt.Sprintf("/students/%d", other.ID), ""))
+		assert.Equal(t, http.StatusOK, do(asStudent, http.MethodPatch, fmt.Sprintf("/students/%d", self.ID), patch))
+		assert.Equal(t, http.StatusOK, do(asStudent, http.MethodGet, fmt.Sprintf("/students/%d/history", self.ID), ""))
+		assert.Equal(t, http.StatusForbidden, do(asStudent, http.MethodPatch, fmt.Sprintf("/students/%d", other.ID), patch))
+		assert.Equal(t, http.StatusForbidden, do(asStudent, http.MethodGet, fmt.Sprintf("/students/%d/history", other.ID), ""))
+		assert.Equal(t, http.StatusForbidden, do(asStudent, http.MethodDelete, fmt.Sprintf("/students/%d", other.ID), ""))
+		assert.Equal(t, http.StatusForbidden, do(asStudent, http.MethodPost, "/students", `{"firstName":"A","lastName":"B","email":"a@example.com"}`))
+		assert.Equal(t, http.StatusForbidden, do(asStudent, http.MethodGet, "/students/export", ""))
+
+		asStaff := newRouter(rbac.Principal{StudentID: other.ID, Role: rbac.RoleStaff})
+		assert.Equal(t, http.StatusOK, do(asStaff, http.MethodPatch, fmt.Sprintf("/students/%d", self.ID), `{"year": 4}`))
+		assert.Equal(t, http.StatusForbidden, do(asStaff, http.MethodDelete, fmt.Sprintf("/students/%d", self.ID), ""))
+		assert.Equal(t, http.StatusForbidden, do(asStaff, http.MethodPut, fmt.Sprintf("/students/%d/role", self.ID), `{"role":"admin"}`))
+
+		// a role in the create body is ignored
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`{"firstName":"A","lastName":"B","email":"a@example.com","role":"admin"}`))
+		req.Header.Set("Content-Type", "application/json")
+		asStaff.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var created student.Student
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.Equal(t, rbac.RoleStudent, created.Role)
+
+		// only admins change roles, and never their own
+		assert.Equal(t, http.StatusOK, do(router, http.MethodPut, fmt.Sprintf("/students/%d/role", self.ID), `{"role":"staff"}`))
+		assert.Equal(t, http.StatusBadRequest, do(router, http.MethodPut, fmt.Sprintf("/students/%d/role", self.ID), `{"role":"root"}`))
+		asAdmin := newRouter(rbac.Principal{StudentID: self.ID, Role: rbac.RoleAdmin})
+		assert.Equal(t, http.StatusForbidden, do(asAdmin, http.MethodPut, fmt.Sprintf("/students/%d/role", self.ID), `{"role":"student"}`))
+
+		updated, err := repo.GetByID(ctx, self.ID)
+		require.NoError(t, err)
+		assert.Equal(t, rbac.RoleStaff, updated.Role)
+
+		// unauthenticated requests never reach the handlers
+		anonymous := gin.New()
+		handler.RegisterRoutes(anonymous)
+		assert.Equal(t, http.StatusUnauthorized, do(anonymous, http.MethodGet, "/students", ""))
+	})
+
 	t.Run("DeleteStudentNotFound", func(t *testing.T) {
 		testdb.CleanupTables(t, pgContainer.DB, "students")
 
@@ -288,3 +1101,10 @@ func TestStudentService_Shared(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+// inviterFunc adapts a function to student.Inviter
+type inviterFunc func(ctx context.Context, studentID int) error
+
+func (f inviterFunc) InviteStudent(ctx context.Context, studentID int) error {
+	return f(ctx, studentID)
+}
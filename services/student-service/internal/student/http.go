@@ -1,40 +1,63 @@
 package student
 
 import (
+	"context"
 	"errors"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 
 	"student-service/internal/metrics"
+	"student-service/internal/rbac"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Inviter emails an invited student a link to choose their password. Earlier
+// links stop working. It is injected so that this package does not depend on
+// auth.
+type Inviter interface {
+	InviteStudent(ctx context.Context, studentID int) error
+}
+
 type Handler struct {
 	service  Service
+	inviter  Inviter
 	validate *validator.Validate
 	logger   *slog.Logger
 	metrics  *metrics.Metrics
 }
 
-func NewHandler(service Service, logger *slog.Logger, metrics *metrics.Metrics) *Handler {
+func NewHandler(service Service, inviter Inviter, logger *slog.Logger, metrics *metrics.Metrics) *Handler {
 	return &Handler{
 		service:  service,
+		inviter:  inviter,
 		validate: validator.New(),
 		logger:   logger,
 		metrics:  metrics,
 	}
 }
 
+// RegisterRoutes registers the student routes. Students may read everyone and
+// edit themselves; creating, importing, exporting and editing others needs
+// staff, deleting and changing roles needs admin.
 func (h *Handler) RegisterRoutes(router gin.IRouter) {
-	router.POST("/students", h.CreateStudent)
-	router.GET("/students", h.GetAllStudents)
-	router.GET("/students/:id", h.GetStudent)
-	router.PUT("/students/:id", h.UpdateStudent)
-	router.DELETE("/students/:id", h.DeleteStudent)
+	router.POST("/students", rbac.RequirePermission(rbac.StudentsWrite), h.CreateStudent)
+	router.POST("/students/import", rbac.RequirePermission(rbac.StudentsWrite), h.ImportStudents)
+	router.GET("/students", rbac.RequirePermission(rbac.StudentsRead), h.GetAllStudents)
+	router.GET("/students/export", rbac.RequirePermission(rbac.StudentsExport), h.ExportStudents)
+	router.GET("/students/:id", rbac.RequirePermission(rbac.StudentsRead), h.GetStudent)
+	router.PUT("/students/:id", rbac.RequireSelfOrPermission("id", rbac.StudentsWrite), h.UpdateStudent)
+	router.PATCH("/students/:id", rbac.RequireSelfOrPermission("id", rbac.StudentsWrite), h.PatchStudent)
+	router.DELETE("/students/:id", rbac.RequirePermission(rbac.StudentsDelete), h.DeleteStudent)
+	router.POST("/students/:id/restore", rbac.RequirePermission(rbac.StudentsDelete), h.RestoreStudent)
+	router.POST("/students/:id/invitation", rbac.RequirePermission(rbac.StudentsWrite), h.ResendInvitation)
+	router.GET("/students/:id/history", rbac.RequireSelfOrPermission("id", rbac.StudentsExport), h.GetStudentHistory)
+	router.PUT("/students/:id/role", rbac.RequirePermission(rbac.RolesManage), h.SetStudentRole)
 }
 
 func (h *Handler) CreateStudent(c *gin.Context) {
@@ -44,17 +67,16 @@ func (h *Handler) CreateStudent(c *gin.Context) {
 		return
 	}
 
-	// Set default password for students created via API
-	// In production, students should be created via /auth/register
-	if student.Password == "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("DefaultPassword123!"), bcrypt.DefaultCost)
-		if err != nil {
-			h.logger.ErrorContext(c.Request.Context(), "failed to hash password", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
-		}
-		student.Password = string(hashedPassword)
-	}
+	// Roles are only assigned through PUT /students/:id/role
+	student.Role = ""
+
+	// Students created by staff have no password until they accept the
+	// invitation; students choosing their own sign up via /auth/register
+	now := time.Now()
+	student.InvitedAt = &now
+	student.VerifiedAt = nil
+	// Accounts are only suspended through POST /auth/accounts/:id/suspend
+	student.SuspendedAt = nil
 
 	h.logger.InfoContext(c.Request.Context(), "creating student", "email", student.Email)
 	createdStudent, err := h.service.CreateStudent(c.Request.Context(), &student)
@@ -66,13 +88,107 @@ func (h *Handler) CreateStudent(c *gin.Context) {
 	// Record metric
 	h.metrics.RecordStudentRegistration(c.Request.Context())
 
+	// The student exists either way, a failed invitation can be resent
+	h.invite(c.Request.Context(), createdStudent.ID)
+
 	c.JSON(http.StatusCreated, createdStudent)
 }
 
+// invite sends an invitation, logging failures
+func (h *Handler) invite(ctx context.Context, id int) {
+	if err := h.inviter.InviteStudent(ctx, id); err != nil {
+		h.logger.ErrorContext(ctx, "failed to send invitation", "id", id, "error", err)
+	}
+}
+
+// ResendInvitation sends a student who has not accepted their invitation a
+// new link
+func (h *Handler) ResendInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "resending invitation", "id", id)
+	if err := h.inviter.InviteStudent(c.Request.Context(), id); err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// maxImportSize limits the size of an uploaded import file
+const maxImportSize = 10 << 20
+
+// importFormats maps accepted Content-Types to import formats
+var importFormats = map[string]ImportFormat{
+	"text/csv":             ImportCSV,
+	"application/x-ndjson": ImportNDJSON,
+	"application/ndjson":   ImportNDJSON,
+	"application/jsonl":    ImportNDJSON,
+}
+
+// ImportStudents creates students from a CSV or NDJSON upload and returns a per-row report
+func (h *Handler) ImportStudents(c *gin.Context) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	format, ok := importFormats[mediaType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be text/csv or application/x-ndjson"})
+		return
+	}
+
+	opts := ImportOptions{Format: format, Mode: ImportMode(c.DefaultQuery("mode", string(ImportAllOrNothing)))}
+	if v := c.Query("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dry_run"})
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "importing students", "format", format, "mode", opts.Mode, "dry_run", opts.DryRun)
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	report, err := h.service.ImportStudents(c.Request.Context(), body, opts)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+			return
+		}
+		h.handleServiceError(c, err)
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "students imported",
+		"total", report.Total, "invalid", report.Invalid, "imported", report.Imported)
+
+	for _, row := range report.Rows {
+		if row.Status == RowImported {
+			h.invite(c.Request.Context(), row.ID)
+		}
+	}
+
+	status := http.StatusOK
+	if !opts.DryRun && opts.Mode == ImportAllOrNothing && report.Invalid > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, report)
+}
+
 func (h *Handler) GetAllStudents(c *gin.Context) {
-	h.logger.InfoContext(c.Request.Context(), "fetching all students")
+	opts, err := parseListOptions(c)
+	if err != nil {
+		listOptionsError(c, err)
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "listing students", "limit", opts.Limit, "sort", opts.Sort)
 
-	students, err := h.service.GetAllStudents(c.Request.Context())
+	page, err := h.service.ListStudents(c.Request.Context(), opts)
 	if err != nil {
 		h.handleServiceError(c, err)
 		return
@@ -81,7 +197,116 @@ func (h *Handler) GetAllStudents(c *gin.Context) {
 	// Record metric
 	h.metrics.RecordStudentsListViewed(c.Request.Context())
 
-	c.JSON(http.StatusOK, students)
+	c.JSON(http.StatusOK, page)
+}
+
+// ExportStudents streams every student matching the list filters as CSV,
+// NDJSON or XLSX with the columns chosen by the caller
+func (h *Handler) ExportStudents(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		listOptionsError(c, err)
+		return
+	}
+	format, err := ParseExportFormat(c.Query("format"))
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+	columns, err := ParseExportColumns(c.Query("columns"))
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	writer, err := NewExportWriter(format, c.Writer, columns)
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "exporting students", "format", format, "sort", opts.Sort)
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", `attachment; filename="students.`+string(format)+`"`)
+
+	err = h.service.ExportStudents(c.Request.Context(), opts, columns, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			h.handleServiceError(c, err)
+			return
+		}
+		// The response is already streaming. Ending it normally would pass
+		// the rows so far off as the whole export, so net/http is made to
+		// drop the connection instead.
+		h.logger.ErrorContext(c.Request.Context(), "student export failed", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+var errIncludeDeletedForbidden = errors.New("include_deleted requires the students:delete permission")
+
+// listOptionsError writes the response for an error of parseListOptions
+func listOptionsError(c *gin.Context, err error) {
+	if errors.Is(err, errIncludeDeletedForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// parseListOptions reads pagination, filter and sort query parameters
+func parseListOptions(c *gin.Context) (ListOptions, error) {
+	opts := ListOptions{
+		Cursor:       c.Query("cursor"),
+		Major:        c.Query("major"),
+		EmailPrefix:  c.Query("email_prefix"),
+		NameContains: c.Query("name"),
+		Sort:         c.Query("sort"),
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return opts, errors.New("invalid limit")
+		}
+		opts.Limit = limit
+	}
+
+	if v := c.Query("include_deleted"); v != "" {
+		includeDeleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, errors.New("invalid include_deleted")
+		}
+		// Deleted records are only for those who may delete and restore them
+		if p, _ := rbac.GetPrincipal(c.Request.Context()); includeDeleted && !p.Can(rbac.StudentsDelete) {
+			return opts, errIncludeDeletedForbidden
+		}
+		opts.IncludeDeleted = includeDeleted
+	}
+
+	if v := c.Query("year_min"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("invalid year_min")
+		}
+		opts.YearMin = &year
+	}
+
+	if v := c.Query("year_max"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("invalid year_max")
+		}
+		opts.YearMax = &year
+	}
+
+	return opts, nil
 }
 
 func (h *Handler) GetStudent(c *gin.Context) {
@@ -101,18 +326,26 @@ func (h *Handler) GetStudent(c *gin.Context) {
 	// Record metric
 	h.metrics.RecordStudentViewed(c.Request.Context())
 
+	c.Header("ETag", etag(student.Version))
 	c.JSON(http.StatusOK, student)
 }
 
 func (h *Handler) UpdateStudent(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 
+	version, ok := parseIfMatch(c.GetHeader("If-Match"))
+	if !ok {
+		h.handleServiceError(c, ErrVersionMismatch)
+		return
+	}
+
 	var student Student
 	if err := c.ShouldBindJSON(&student); err != nil || h.validate.Struct(&student) != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 	student.ID = id
+	student.Version = version
 
 	h.logger.InfoContext(c.Request.Context(), "updating student", "email", student.Email)
 	if err := h.service.UpdateStudent(c.Request.Context(), &student); err != nil {
@@ -120,6 +353,47 @@ func (h *Handler) UpdateStudent(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(student.Version))
+	c.JSON(http.StatusOK, student)
+}
+
+// maxPatchSize limits the merge patch body; a student document is far smaller
+const maxPatchSize = 64 << 10
+
+// PatchStudent applies an RFC 7396 JSON merge patch (application/merge-patch+json)
+func (h *Handler) PatchStudent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/merge-patch+json"})
+		return
+	}
+
+	version, ok := parseIfMatch(c.GetHeader("If-Match"))
+	if !ok {
+		h.handleServiceError(c, ErrVersionMismatch)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPatchSize+1))
+	if err != nil || len(patch) > maxPatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "patching student", "id", id)
+	student, err := h.service.PatchStudent(c.Request.Context(), id, version, patch)
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(student.Version))
 	c.JSON(http.StatusOK, student)
 }
 
@@ -139,12 +413,100 @@ func (h *Handler) DeleteStudent(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// RestoreStudent undoes a soft delete
+func (h *Handler) RestoreStudent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "restoring student", "id", id)
+	student, err := h.service.RestoreStudent(c.Request.Context(), id)
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(student.Version))
+	c.JSON(http.StatusOK, student)
+}
+
+// GetStudentHistory returns the audit trail of a student
+func (h *Handler) GetStudentHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "fetching student history", "id", id)
+	entries, err := h.service.GetStudentHistory(c.Request.Context(), id)
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// SetStudentRoleRequest is the request body for changing a role
+type SetStudentRoleRequest struct {
+	Role rbac.Role `json:"role" binding:"required"`
+}
+
+// SetStudentRole changes the role of another student
+func (h *Handler) SetStudentRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	var req SetStudentRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Admins cannot demote themselves, so there is always one left
+	if p, ok := rbac.GetPrincipal(c.Request.Context()); ok && p.StudentID == id {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "changing student role", "id", id, "role", req.Role)
+	student, err := h.service.SetStudentRole(c.Request.Context(), id, req.Role)
+	if err != nil {
+		h.handleServiceError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(student.Version))
+	c.JSON(http.StatusOK, student)
+}
+
 func (h *Handler) handleServiceError(c *gin.Context, err error) {
 	if errors.Is(err, ErrStudentNotFound) {
 		h.logger.Info("student not found")
 		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
 		return
 	}
+	if errors.Is(err, ErrEmailTaken) {
+		h.logger.Info("email already in use")
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+	if errors.Is(err, ErrNotInvited) {
+		h.logger.Info("student has no pending invitation")
+		c.JSON(http.StatusConflict, gin.H{"error": "Student has already accepted the invitation"})
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		h.logger.Info("student version mismatch")
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Student was modified by someone else, reload and try again"})
+		return
+	}
 	if errors.Is(err, ErrInvalidInput) {
 		h.logger.Info("invalid input")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -0,0 +1,498 @@
+package student
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ImportFormat is the encoding of an import file
+type ImportFormat string
+
+const (
+	ImportCSV    ImportFormat = "csv"
+	ImportNDJSON ImportFormat = "ndjson"
+)
+
+// ImportMode decides what happens to valid rows when some rows are invalid
+type ImportMode string
+
+const (
+	// ImportAllOrNothing imports nothing unless every row is valid
+	ImportAllOrNothing ImportMode = "all_or_nothing"
+	// ImportSkipInvalid imports the valid rows and reports the invalid ones
+	ImportSkipInvalid ImportMode = "skip_invalid"
+)
+
+// Row statuses in an import report
+const (
+	RowValid    = "valid"
+	RowInvalid  = "invalid"
+	RowImported = "imported"
+)
+
+const (
+	// MaxImportRows caps the number of rows in one import
+	MaxImportRows = 10000
+	// importBatchSize is the number of rows checked and inserted together
+	importBatchSize = 500
+)
+
+var ErrImportTooLarge = fmt.Errorf("%w: import is limited to %d rows", ErrInvalidInput, MaxImportRows)
+
+// errImportRejected rolls back an all-or-nothing import that has invalid rows
+var errImportRejected = errors.New("import rejected")
+
+type ImportOptions struct {
+	Format ImportFormat
+	Mode   ImportMode
+	DryRun bool
+}
+
+// ImportReport describes the outcome of an import row by row
+type ImportReport struct {
+	DryRun   bool              `json:"dryRun"`
+	Mode     ImportMode        `json:"mode"`
+	Total    int               `json:"total"`
+	Valid    int               `json:"valid"`
+	Invalid  int               `json:"invalid"`
+	Imported int               `json:"imported"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+type ImportRowResult struct {
+	// Line is the line of the row in the uploaded file
+	Line   int      `json:"line"`
+	Email  string   `json:"email,omitempty"`
+	Status string   `json:"status"`
+	ID     int      `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportStudents stream-parses a CSV or NDJSON file, validates every row and,
+// unless this is a dry run, inserts the valid rows in batches
+func (s *service) ImportStudents(ctx context.Context, src io.Reader, opts ImportOptions) (*ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportAllOrNothing
+	}
+	if opts.Mode != ImportAllOrNothing && opts.Mode != ImportSkipInvalid {
+		return nil, fmt.Errorf("%w: unknown import mode %q", ErrInvalidInput, opts.Mode)
+	}
+
+	records, err := newRecordReader(src, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		service: s,
+		opts:    opts,
+		records: records,
+		seen:    map[string]int{},
+		report:  &ImportReport{DryRun: opts.DryRun, Mode: opts.Mode, Rows: []ImportRowResult{}},
+	}
+
+	switch {
+	case opts.DryRun:
+		err = imp.run(ctx, s.repo)
+	case opts.Mode == ImportSkipInvalid:
+		// every batch commits on its own
+		err = imp.run(ctx, s.repo)
+	default:
+		err = s.repo.RunInTx(ctx, func(ctx context.Context, tx Repository) error {
+			if err := imp.run(ctx, tx); err != nil {
+				return err
+			}
+			if imp.hasInvalid() {
+				return errImportRejected
+			}
+			return nil
+		})
+		if errors.Is(err, errImportRejected) {
+			imp.discardImported()
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	imp.summarize()
+	return imp.report, nil
+}
+
+// importer carries the state of a single import
+type importer struct {
+	service *service
+	opts    ImportOptions
+	records recordReader
+	report  *ImportReport
+	// seen maps lower-cased emails to the line they first appeared on
+	seen map[string]int
+	// pending holds report indexes of valid rows not yet checked against the database
+	pending []int
+	// students holds the parsed student of every valid row by report index
+	students map[int]*Student
+}
+
+func (imp *importer) run(ctx context.Context, repo Repository) error {
+	imp.students = map[int]*Student{}
+
+	for {
+		rec, err := imp.records.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(imp.report.Rows) == MaxImportRows {
+			return ErrImportTooLarge
+		}
+
+		imp.add(rec)
+
+		if len(imp.pending) >= importBatchSize {
+			if err := imp.flush(ctx, repo); err != nil {
+				return err
+			}
+		}
+	}
+	return imp.flush(ctx, repo)
+}
+
+// add validates a parsed record and appends it to the report
+func (imp *importer) add(rec *importRecord) {
+	student := &rec.student
+	student.Email = strings.TrimSpace(student.Email)
+
+	result := ImportRowResult{Line: rec.line, Email: student.Email, Status: RowValid}
+	if rec.err != nil {
+		result.Errors = append(result.Errors, rec.err.Error())
+	} else {
+		result.Errors = append(result.Errors, validationMessages(imp.service.validate.Struct(student))...)
+	}
+
+	if key := strings.ToLower(student.Email); key != "" {
+		if first, ok := imp.seen[key]; ok {
+			result.Errors = append(result.Errors, fmt.Sprintf("email duplicates line %d", first))
+		} else {
+			imp.seen[key] = rec.line
+		}
+	}
+
+	index := len(imp.report.Rows)
+	if len(result.Errors) > 0 {
+		result.Status = RowInvalid
+	} else {
+		imp.pending = append(imp.pending, index)
+		imp.students[index] = student
+	}
+	imp.report.Rows = append(imp.report.Rows, result)
+}
+
+// flush checks pending rows against existing students and inserts the ones
+// that are still valid
+func (imp *importer) flush(ctx context.Context, repo Repository) error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+	pending := imp.pending
+	imp.pending = nil
+
+	emails := make([]string, len(pending))
+	for i, index := range pending {
+		emails[i] = strings.ToLower(imp.students[index].Email)
+	}
+	existing, err := repo.ExistingEmails(ctx, emails)
+	if err != nil {
+		return err
+	}
+	taken := make(map[string]bool, len(existing))
+	for _, email := range existing {
+		taken[strings.ToLower(email)] = true
+	}
+
+	// Imported students are invited to choose a password
+	invitedAt := time.Now()
+	var indexes []int
+	var students []*Student
+	for _, index := range pending {
+		student := imp.students[index]
+		delete(imp.students, index)
+		if taken[strings.ToLower(student.Email)] {
+			row := &imp.report.Rows[index]
+			row.Status = RowInvalid
+			row.Errors = append(row.Errors, "email already exists")
+			continue
+		}
+		student.InvitedAt = &invitedAt
+		indexes = append(indexes, index)
+		students = append(students, student)
+	}
+
+	// an all-or-nothing import is rolled back at the end anyway
+	if imp.opts.DryRun || (imp.opts.Mode == ImportAllOrNothing && imp.hasInvalid()) {
+		return nil
+	}
+
+	insert := func(ctx context.Context, repo Repository) error {
+		return repo.CreateBatch(ctx, students)
+	}
+	if imp.opts.Mode == ImportSkipInvalid {
+		err = repo.RunInTx(ctx, insert)
+	} else {
+		err = insert(ctx, repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, index := range indexes {
+		imp.report.Rows[index].Status = RowImported
+		imp.report.Rows[index].ID = students[i].ID
+	}
+	return nil
+}
+
+func (imp *importer) hasInvalid() bool {
+	for _, row := range imp.report.Rows {
+		if row.Status == RowInvalid {
+			return true
+		}
+	}
+	return false
+}
+
+// discardImported reports rows of a rolled back import as merely valid
+func (imp *importer) discardImported() {
+	for i := range imp.report.Rows {
+		if imp.report.Rows[i].Status == RowImported {
+			imp.report.Rows[i].Status = RowValid
+			imp.report.Rows[i].ID = 0
+		}
+	}
+}
+
+func (imp *importer) summarize() {
+	r := imp.report
+	r.Total = len(r.Rows)
+	for _, row := range r.Rows {
+		switch row.Status {
+		case RowInvalid:
+			r.Invalid++
+		case RowImported:
+			r.Imported++
+			r.Valid++
+		default:
+			r.Valid++
+		}
+	}
+}
+
+// validationMessages turns validator errors into report messages
+func validationMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []string{err.Error()}
+	}
+	messages := make([]string, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		if fe.Param() != "" {
+			messages[i] = fmt.Sprintf("%s: must satisfy %s=%s", fe.Field(), fe.Tag(), fe.Param())
+		} else {
+			messages[i] = fmt.Sprintf("%s: must satisfy %s", fe.Field(), fe.Tag())
+		}
+	}
+	return messages
+}
+
+// importRecord is one parsed row. err is set when the row could not be
+// parsed; the import reports it and moves on to the next row.
+type importRecord struct {
+	line    int
+	student Student
+	err     error
+}
+
+// recordReader yields parsed rows and io.EOF after the last one. Other errors
+// abort the import.
+type recordReader interface {
+	next() (*importRecord, error)
+}
+
+func newRecordReader(src io.Reader, format ImportFormat) (recordReader, error) {
+	switch format {
+	case ImportCSV:
+		return newCSVReader(src)
+	case ImportNDJSON:
+		return &ndjsonReader{r: bufio.NewReader(src)}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown import format %q", ErrInvalidInput, format)
+	}
+}
+
+// csvColumns maps normalized CSV header names to student fields
+var csvColumns = map[string]string{
+	"firstname": "firstName",
+	"lastname":  "lastName",
+	"email":     "email",
+	"major":     "major",
+	"year":      "year",
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+}
+
+// newCSVReader reads the header row. Header names are the JSON field names,
+// matched case-insensitively and with or without underscores (first_name).
+func newCSVReader(src io.Reader) (*csvReader, error) {
+	r := csv.NewReader(src)
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: CSV header row is missing", ErrInvalidInput)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CSV header: %v", ErrInvalidInput, err)
+	}
+
+	columns := make([]string, len(header))
+	found := map[string]bool{}
+	for i, name := range header {
+		key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))
+		if i == 0 {
+			key = strings.TrimPrefix(key, "\ufeff") // byte order mark
+		}
+		field, ok := csvColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", ErrInvalidInput, name)
+		}
+		if found[field] {
+			return nil, fmt.Errorf("%w: duplicate CSV column %q", ErrInvalidInput, name)
+		}
+		found[field] = true
+		columns[i] = field
+	}
+	for _, required := range []string{"firstName", "lastName", "email"} {
+		if !found[required] {
+			return nil, fmt.Errorf("%w: CSV column %q is required", ErrInvalidInput, required)
+		}
+	}
+
+	return &csvReader{r: r, columns: columns}, nil
+}
+
+func (c *csvReader) next() (*importRecord, error) {
+	record, err := c.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &importRecord{line: parseErr.StartLine, err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line, _ := c.r.FieldPos(0)
+	rec := &importRecord{line: line}
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+		switch c.columns[i] {
+		case "firstName":
+			rec.student.FirstName = value
+		case "lastName":
+			rec.student.LastName = value
+		case "email":
+			rec.student.Email = value
+		case "major":
+			rec.student.Major = value
+		case "year":
+			if value == "" {
+				continue
+			}
+			year, err := strconv.Atoi(value)
+			if err != nil {
+				rec.err = errors.New("year: must be a number")
+			}
+			rec.student.Year = year
+		}
+	}
+	return rec, nil
+}
+
+// importLine is the shape of one NDJSON row
+type importLine struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Major     string `json:"major"`
+	Year      int    `json:"year"`
+}
+
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (n *ndjsonReader) next() (*importRecord, error) {
+	for {
+		raw, err := n.r.ReadBytes('\n')
+		if len(raw) == 0 && err != nil {
+			return nil, err
+		}
+		n.line++
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		rec := &importRecord{line: n.line}
+		var row importLine
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row); err != nil {
+			rec.err = fmt.Errorf("invalid JSON: %v", err)
+			return rec, nil
+		}
+		if dec.More() {
+			rec.err = errors.New("invalid JSON: unexpected data after object")
+			return rec, nil
+		}
+		rec.student = Student{
+			FirstName: strings.TrimSpace(row.FirstName),
+			LastName:  strings.TrimSpace(row.LastName),
+			Email:     row.Email,
+			Major:     strings.TrimSpace(row.Major),
+			Year:      row.Year,
+		}
+		return rec, nil
+	}
+}
@@ -0,0 +1,91 @@
+package student
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, r recordReader) []*importRecord {
+	t.Helper()
+	var records []*importRecord
+	for {
+		rec, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	input := "\ufeffFirst_Name,lastName,EMAIL,major,year\n" +
+		"Jan,Novák,jan@example.com,Physics,2\n" +
+		"\n" +
+		"Eva, Svobodová , eva@example.com,,\n" +
+		"Petr,Dvořák,petr@example.com,Math,second\n" +
+		"too,few\n"
+
+	r, err := newRecordReader(strings.NewReader(input), ImportCSV)
+	require.NoError(t, err)
+	records := readAll(t, r)
+	require.Len(t, records, 4)
+
+	assert.Equal(t, 2, records[0].line)
+	assert.NoError(t, records[0].err)
+	assert.Equal(t, Student{FirstName: "Jan", LastName: "Novák", Email: "jan@example.com", Major: "Physics", Year: 2}, records[0].student)
+
+	assert.Equal(t, 4, records[1].line)
+	assert.NoError(t, records[1].err)
+	assert.Equal(t, "Svobodová", records[1].student.LastName)
+	assert.Equal(t, 0, records[1].student.Year)
+
+	assert.Equal(t, 5, records[2].line)
+	assert.EqualError(t, records[2].err, "year: must be a number")
+
+	assert.Equal(t, 6, records[3].line)
+	assert.Error(t, records[3].err)
+}
+
+func TestCSVReaderHeader(t *testing.T) {
+	for _, header := range []string{"", "firstName,lastName,email,nickname\n", "firstName,lastName\n", "email,email,firstName,lastName\n"} {
+		_, err := newRecordReader(strings.NewReader(header), ImportCSV)
+		assert.ErrorIs(t, err, ErrInvalidInput, header)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	input := `{"firstName":"Jan","lastName":"Novák","email":"jan@example.com","year":2}` + "\n" +
+		"\n" +
+		`{"firstName":"Eva","lastName":"Svobodová","email":"eva@example.com","password":"x"}` + "\n" +
+		`{"firstName":"Petr"} {"firstName":"Karel"}` + "\n" +
+		`not json` + "\n" +
+		`{"firstName":"Last","lastName":"Line","email":"last@example.com"}`
+
+	r, err := newRecordReader(strings.NewReader(input), ImportNDJSON)
+	require.NoError(t, err)
+	records := readAll(t, r)
+	require.Len(t, records, 5)
+
+	assert.Equal(t, 1, records[0].line)
+	assert.NoError(t, records[0].err)
+	assert.Equal(t, Student{FirstName: "Jan", LastName: "Novák", Email: "jan@example.com", Year: 2}, records[0].student)
+
+	assert.Equal(t, 3, records[1].line)
+	assert.ErrorContains(t, records[1].err, "password")
+
+	assert.Equal(t, 4, records[2].line)
+	assert.Error(t, records[2].err)
+
+	assert.Equal(t, 5, records[3].line)
+	assert.Error(t, records[3].err)
+
+	assert.Equal(t, 6, records[4].line)
+	assert.NoError(t, records[4].err)
+	assert.Equal(t, "last@example.com", records[4].student.Email)
+}
@@ -0,0 +1,115 @@
+package student
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sortColumns maps API sort keys to database columns
+var sortColumns = map[string]string{
+	"id":        "id",
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"email":     "email",
+	"year":      "year",
+}
+
+type sortSpec struct {
+	key    string
+	column string
+	desc   bool
+}
+
+func parseSort(sort string) (sortSpec, error) {
+	if sort == "" {
+		sort = "id"
+	}
+
+	spec := sortSpec{key: sort}
+	if strings.HasPrefix(sort, "-") {
+		spec.desc = true
+		spec.key = strings.TrimPrefix(sort, "-")
+	}
+
+	column, ok := sortColumns[spec.key]
+	if !ok {
+		return sortSpec{}, fmt.Errorf("%w: unknown sort key %q", ErrInvalidInput, spec.key)
+	}
+	spec.column = column
+	return spec, nil
+}
+
+// pageLimit applies the default and the maximum page size to a requested
+// limit, zero meaning the default
+func pageLimit(limit int) (int, error) {
+	if limit < 0 {
+		return 0, fmt.Errorf("%w: negative limit", ErrInvalidInput)
+	}
+	if limit == 0 {
+		return DefaultPageSize, nil
+	}
+	return min(limit, MaxPageSize), nil
+}
+
+// value returns the sort column value of a student as it is stored in the cursor
+func (s sortSpec) value(st *Student) string {
+	switch s.key {
+	case "firstName":
+		return st.FirstName
+	case "lastName":
+		return st.LastName
+	case "email":
+		return st.Email
+	case "year":
+		return strconv.Itoa(st.Year)
+	default:
+		return strconv.Itoa(st.ID)
+	}
+}
+
+// arg converts a cursor value back to the type of the sort column
+func (s sortSpec) arg(value string) (interface{}, error) {
+	switch s.key {
+	case "id", "year":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	default:
+		return value, nil
+	}
+}
+
+// cursor is the decoded form of the opaque pagination cursor. It records the
+// sort it was issued for so it cannot be replayed against a different order.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
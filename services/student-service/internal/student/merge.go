@@ -0,0 +1,78 @@
+package student
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// patchableColumns maps JSON fields that a merge patch may change to database columns.
+// Everything else (id, password) is managed by the server.
+var patchableColumns = map[string]string{
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"email":     "email",
+	"major":     "major",
+	"year":      "year",
+}
+
+// editableColumns are written by a full update (PUT)
+var editableColumns = []string{"first_name", "last_name", "email", "major", "year"}
+
+// mergePatch applies an RFC 7396 JSON merge patch to a JSON document
+func mergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// patchColumns returns the columns touched by a merge patch on a student.
+// The patch must be a JSON object that only contains patchable fields.
+func patchColumns(patch []byte, id int) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("%w: merge patch must be a JSON object", ErrInvalidInput)
+	}
+
+	columns := make([]string, 0, len(fields))
+	for field, raw := range fields {
+		if field == "id" {
+			var patchID int
+			if err := json.Unmarshal(raw, &patchID); err != nil || patchID != id {
+				return nil, fmt.Errorf("%w: id cannot be changed", ErrInvalidInput)
+			}
+			continue
+		}
+		column, ok := patchableColumns[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q cannot be patched", ErrInvalidInput, field)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
@@ -0,0 +1,48 @@
+package student
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test cases from RFC 7396 Appendix A
+func TestMergePatch_RFC7396(t *testing.T) {
+	cases := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tc := range cases {
+		got, err := mergePatch([]byte(tc.doc), []byte(tc.patch))
+		require.NoError(t, err)
+		assert.JSONEq(t, tc.want, string(got), "doc=%s patch=%s", tc.doc, tc.patch)
+	}
+}
+
+func TestPatchColumns(t *testing.T) {
+	columns, err := patchColumns([]byte(`{"firstName":"A","major":null,"id":7}`), 7)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"first_name", "major"}, columns)
+
+	for _, patch := range []string{`{"password":"x"}`, `{"id":8}`, `["firstName"]`, `null`, `{"unknown":1}`} {
+		_, err := patchColumns([]byte(patch), 7)
+		assert.ErrorIs(t, err, ErrInvalidInput, patch)
+	}
+}
@@ -1,6 +1,12 @@
 package student
 
-import "github.com/uptrace/bun"
+import (
+	"time"
+
+	"student-service/internal/rbac"
+
+	"github.com/uptrace/bun"
+)
 
 type Student struct {
 	bun.BaseModel `bun:"table:students,alias:s"`
@@ -12,4 +18,91 @@ type Student struct {
 	Password  string `bun:"password,notnull" json:"-"` // Never expose password in JSON
 	Major     string `bun:"major" json:"major"`
 	Year      int    `bun:"year" json:"year" validate:"min=0,max=10"`
+	// Role decides what the student may do besides editing their own record
+	Role rbac.Role `bun:"role,notnull,default:'student'" json:"role"`
+	// VerifiedAt is set once the student confirmed their email address.
+	// Changing the email clears it.
+	VerifiedAt *time.Time `bun:"verified_at,nullzero" json:"verifiedAt,omitempty"`
+	// InvitedAt is set for students created by staff until they accept the
+	// invitation and choose a password. Until then they have no usable
+	// password.
+	InvitedAt *time.Time `bun:"invited_at,nullzero" json:"invitedAt,omitempty"`
+	// SuspendedAt is set while an admin has suspended the account. A
+	// suspended student cannot log in and their tokens are revoked.
+	SuspendedAt *time.Time `bun:"suspended_at,nullzero" json:"suspendedAt,omitempty"`
+	// Version is incremented on every update and backs the ETag header
+	Version int `bun:"version,notnull,default:1" json:"version"`
+	// DeletedAt is set when the student is soft-deleted. Queries skip such
+	// rows unless they ask for deleted rows explicitly.
+	DeletedAt *time.Time `bun:"deleted_at,soft_delete,nullzero" json:"deletedAt,omitempty"`
+}
+
+// Invited reports whether the student has not accepted their invitation yet
+func (s *Student) Invited() bool {
+	return s.InvitedAt != nil
+}
+
+// Suspended reports whether an admin has suspended the account
+func (s *Student) Suspended() bool {
+	return s.SuspendedAt != nil
+}
+
+// Audit actions
+const (
+	AuditCreate         = "create"
+	AuditUpdate         = "update"
+	AuditDelete         = "delete"
+	AuditRestore        = "restore"
+	AuditPasswordChange = "password_change"
+)
+
+// AuditEntry is one append-only record in the change history of a student
+type AuditEntry struct {
+	bun.BaseModel `bun:"table:student_audit,alias:sa"`
+
+	ID        int64 `bun:"id,pk,autoincrement" json:"id"`
+	StudentID int   `bun:"student_id,notnull" json:"studentId"`
+	ActorID   *int  `bun:"actor_id" json:"actorId"`
+	// ActorAPIKeyID is set instead of ActorID for writes made with an API key
+	ActorAPIKeyID *int64 `bun:"actor_api_key_id" json:"actorApiKeyId,omitempty"`
+	Action        string `bun:"action,notnull" json:"action"`
+	// Changes maps changed JSON fields to their old and new values. Password
+	// changes are never recorded here, only as an AuditPasswordChange entry.
+	Changes   map[string]FieldChange `bun:"changes,type:jsonb,nullzero" json:"changes,omitempty"`
+	RequestID string                 `bun:"request_id,nullzero" json:"requestId,omitempty"`
+	CreatedAt time.Time              `bun:"created_at,notnull,default:current_timestamp" json:"createdAt"`
+}
+
+// FieldChange holds the value of a field before and after a change
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ListOptions controls filtering, sorting and cursor pagination of students
+type ListOptions struct {
+	Limit        int
+	Cursor       string
+	Major        string
+	YearMin      *int
+	YearMax      *int
+	EmailPrefix  string
+	NameContains string
+	// IncludeDeleted also lists soft-deleted students
+	IncludeDeleted bool
+	// Sort is a JSON field name (id, firstName, lastName, email, year),
+	// prefixed with "-" for descending order. Defaults to "id".
+	Sort string
+}
+
+// Page is a single page of students returned by List
+type Page struct {
+	Items      []Student `json:"items"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+	TotalCount int       `json:"totalCount"`
 }
@@ -3,31 +3,63 @@ package student
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"slices"
 	"time"
 
 	"grud/common/metrics"
 
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+// uniqueViolation is the PostgreSQL SQLSTATE for unique constraint violations
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation
+}
+
 type Repository interface {
 	Create(ctx context.Context, student *Student) (*Student, error)
 	GetAll(ctx context.Context) ([]Student, error)
+	List(ctx context.Context, opts ListOptions) (*Page, error)
+	Export(ctx context.Context, opts ListOptions, columns []ExportColumn, fn func(*Student) error) error
 	GetByID(ctx context.Context, id int) (*Student, error)
 	GetByEmail(ctx context.Context, email string) (*Student, error)
-	Update(ctx context.Context, student *Student) error
+	Update(ctx context.Context, student *Student, columns ...string) error
 	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) (*Student, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+	CreateBatch(ctx context.Context, students []*Student) error
+	ExistingEmails(ctx context.Context, emails []string) ([]string, error)
+	// GetForUpdate returns a student, soft-deleted or not, and locks the row
+	// until the end of the transaction
+	GetForUpdate(ctx context.Context, id int) (*Student, error)
+	AppendAudit(ctx context.Context, entries ...*AuditEntry) error
+	History(ctx context.Context, studentID int) ([]AuditEntry, error)
+	// RunInTx calls fn with a repository bound to a single transaction
+	RunInTx(ctx context.Context, fn func(ctx context.Context, repo Repository) error) error
 }
 
+// PurgeHook removes what another package keeps about students that are being
+// purged. It runs in the purge transaction before the students are deleted,
+// and an error aborts the purge.
+type PurgeHook func(ctx context.Context, db bun.IDB, students []Student) error
+
 type repository struct {
-	db      *bun.DB
-	metrics *metrics.Metrics
+	db         bun.IDB
+	metrics    *metrics.Metrics
+	purgeHooks []PurgeHook
 }
 
-func NewRepository(db *bun.DB, m *metrics.Metrics) Repository {
+// NewRepository creates the repository. The hooks are run by Purge.
+func NewRepository(db *bun.DB, m *metrics.Metrics, purgeHooks ...PurgeHook) Repository {
 	return &repository{
-		db:      db,
-		metrics: m,
+		db:         db,
+		metrics:    m,
+		purgeHooks: purgeHooks,
 	}
 }
 
@@ -38,11 +70,60 @@ func (r *repository) Create(ctx context.Context, student *Student) (*Student, er
 	r.metrics.Database.RecordQuery(ctx, "insert", "students", time.Since(start), err)
 
 	if err != nil {
+		// Emails stay reserved by soft-deleted students until they are purged
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
 		return nil, err
 	}
 	return student, nil
 }
 
+// CreateBatch inserts several students with a single statement and fills in
+// their generated columns
+func (r *repository) CreateBatch(ctx context.Context, students []*Student) error {
+	if len(students) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	_, err := r.db.NewInsert().Model(&students).Returning("*").Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "students", time.Since(start), err)
+
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// ExistingEmails returns which of the given emails are already taken,
+// compared case-insensitively and including soft-deleted students
+func (r *repository) ExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	var existing []string
+	err := r.db.NewSelect().
+		Model((*Student)(nil)).
+		Column("email").
+		WhereAllWithDeleted().
+		Where("lower(s.email) IN (?)", bun.In(emails)).
+		Scan(ctx, &existing)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	return existing, err
+}
+
+func (r *repository) RunInTx(ctx context.Context, fn func(ctx context.Context, repo Repository) error) error {
+	return r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return fn(ctx, &repository{db: tx, metrics: r.metrics, purgeHooks: r.purgeHooks})
+	})
+}
+
 func (r *repository) GetAll(ctx context.Context) ([]Student, error) {
 	start := time.Now()
 	var students []Student
@@ -53,6 +134,170 @@ func (r *repository) GetAll(ctx context.Context) ([]Student, error) {
 	return students, err
 }
 
+// List returns one page of students using keyset pagination on the sort
+// column with the primary key as tie-breaker. A zero limit means
+// DefaultPageSize and larger limits are cut to MaxPageSize.
+func (r *repository) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	spec, err := parseSort(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit, err = pageLimit(opts.Limit); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	total, err := r.db.NewSelect().
+		Model((*Student)(nil)).
+		Apply(applyFilters(opts)).
+		Count(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var students []Student
+	q := r.db.NewSelect().
+		Model(&students).
+		Apply(applyFilters(opts)).
+		Limit(opts.Limit + 1)
+
+	comparator := ">"
+	if spec.desc {
+		comparator = "<"
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != spec.key {
+			return nil, ErrInvalidCursor
+		}
+		value, err := spec.arg(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("(?, s.id) "+comparator+" (?, ?)", bun.Ident("s."+spec.column), value, c.ID)
+	}
+
+	q = q.Apply(applySort(spec))
+
+	start = time.Now()
+	err = q.Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: students, TotalCount: total}
+	if len(students) > opts.Limit {
+		page.Items = students[:opts.Limit]
+		last := &page.Items[opts.Limit-1]
+		page.NextCursor = encodeCursor(cursor{Sort: spec.key, Value: spec.value(last), ID: last.ID})
+	}
+	if page.Items == nil {
+		page.Items = []Student{}
+	}
+	return page, nil
+}
+
+// applySort orders by the sort column with the primary key as tie-breaker
+func applySort(spec sortSpec) func(*bun.SelectQuery) *bun.SelectQuery {
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		direction := "ASC"
+		if spec.desc {
+			direction = "DESC"
+		}
+		if spec.column != "id" {
+			q = q.OrderExpr("? "+direction, bun.Ident("s."+spec.column))
+		}
+		return q.OrderExpr("s.id " + direction)
+	}
+}
+
+// exportFetchSize is the number of rows fetched from the export cursor at a time
+const exportFetchSize = 500
+
+// Export streams the students matching the list filters through a server-side
+// cursor so that memory use does not grow with the number of rows. Only the
+// requested columns are selected.
+func (r *repository) Export(ctx context.Context, opts ListOptions, columns []ExportColumn, fn func(*Student) error) error {
+	spec, err := parseSort(opts.Sort)
+	if err != nil {
+		return err
+	}
+
+	dbColumns := make([]string, len(columns))
+	for i, column := range columns {
+		dbColumns[i] = column.column
+	}
+	query := r.db.NewSelect().
+		Model((*Student)(nil)).
+		Column(dbColumns...).
+		Apply(applyFilters(opts)).
+		Apply(applySort(spec)).
+		String()
+
+	start := time.Now()
+	err = r.db.RunInTx(ctx, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DECLARE student_export NO SCROLL CURSOR FOR "+query); err != nil {
+			return err
+		}
+		for {
+			var batch []Student
+			if err := tx.NewRaw("FETCH ? FROM student_export", exportFetchSize).Scan(ctx, &batch); err != nil {
+				return err
+			}
+			for i := range batch {
+				if err := fn(&batch[i]); err != nil {
+					return err
+				}
+			}
+			if len(batch) < exportFetchSize {
+				return nil
+			}
+		}
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	return err
+}
+
+func applyFilters(opts ListOptions) func(*bun.SelectQuery) *bun.SelectQuery {
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		if opts.IncludeDeleted {
+			q = q.WhereAllWithDeleted()
+		}
+		if opts.Major != "" {
+			q = q.Where("s.major = ?", opts.Major)
+		}
+		if opts.YearMin != nil {
+			q = q.Where("s.year >= ?", *opts.YearMin)
+		}
+		if opts.YearMax != nil {
+			q = q.Where("s.year <= ?", *opts.YearMax)
+		}
+		if opts.EmailPrefix != "" {
+			q = q.Where("s.email ILIKE ?", escapeLike(opts.EmailPrefix)+"%")
+		}
+		if opts.NameContains != "" {
+			pattern := "%" + escapeLike(opts.NameContains) + "%"
+			q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("s.first_name ILIKE ?", pattern).
+					WhereOr("s.last_name ILIKE ?", pattern)
+			})
+		}
+		return q
+	}
+}
+
 func (r *repository) GetByID(ctx context.Context, id int) (*Student, error) {
 	start := time.Now()
 	student := new(Student)
@@ -69,9 +314,36 @@ func (r *repository) GetByID(ctx context.Context, id int) (*Student, error) {
 	return student, nil
 }
 
-func (r *repository) Update(ctx context.Context, student *Student) error {
+// Update writes the given columns of a student, or all client-editable
+// columns when none are given. Server-managed columns such as the password
+// are never overwritten here.
+//
+// When student.Version is set the row is only updated if it still has that
+// version, otherwise ErrVersionMismatch is returned. On success
+// student.Version holds the new version.
+//
+// Writing a different email clears verified_at, the new address has to be
+// verified again.
+func (r *repository) Update(ctx context.Context, student *Student, columns ...string) error {
+	if len(columns) == 0 {
+		columns = editableColumns
+	}
+
+	q := r.db.NewUpdate().
+		Model(student).
+		Column(columns...).
+		Set("version = s.version + 1").
+		WherePK().
+		Returning("version, verified_at")
+	if slices.Contains(columns, "email") && !slices.Contains(columns, "verified_at") {
+		q = q.Set("verified_at = CASE WHEN lower(s.email) = lower(?) THEN s.verified_at END", student.Email)
+	}
+	if student.Version > 0 {
+		q = q.Where("s.version = ?", student.Version)
+	}
+
 	start := time.Now()
-	result, err := r.db.NewUpdate().Model(student).WherePK().Exec(ctx)
+	result, err := q.Exec(ctx)
 
 	r.metrics.Database.RecordQuery(ctx, "update", "students", time.Since(start), err)
 
@@ -83,11 +355,33 @@ func (r *repository) Update(ctx context.Context, student *Student) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrStudentNotFound
+		return r.missingRowError(ctx, student.ID, student.Version)
 	}
 	return nil
 }
 
+// missingRowError tells apart a student that does not exist from one whose
+// version moved on after a conditional write matched no rows
+func (r *repository) missingRowError(ctx context.Context, id, version int) error {
+	if version == 0 {
+		return ErrStudentNotFound
+	}
+
+	start := time.Now()
+	exists, err := r.db.NewSelect().Model((*Student)(nil)).Where("id = ?", id).Exists(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrStudentNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// Delete soft-deletes a student by setting deleted_at; the row is removed by Purge
 func (r *repository) Delete(ctx context.Context, id int) error {
 	start := time.Now()
 	student := &Student{ID: id}
@@ -108,12 +402,145 @@ func (r *repository) Delete(ctx context.Context, id int) error {
 	return nil
 }
 
+// Restore clears deleted_at of a soft-deleted student. Restoring a student
+// that is not deleted is a no-op.
+func (r *repository) Restore(ctx context.Context, id int) (*Student, error) {
+	start := time.Now()
+	student := &Student{ID: id}
+	result, err := r.db.NewUpdate().
+		Model(student).
+		WhereDeleted().
+		Set("deleted_at = NULL").
+		Set("version = s.version + 1").
+		WherePK().
+		Returning("*").
+		Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "update", "students", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return r.GetByID(ctx, id)
+	}
+	return student, nil
+}
+
+// Purge permanently removes students soft-deleted before deletedBefore and
+// returns their number. The purge hooks remove what other packages keep about
+// them in the same transaction.
+func (r *repository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var purged int
+	start := time.Now()
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var expired []Student
+		if err := tx.NewSelect().
+			Model(&expired).
+			Column("id", "email").
+			WhereDeleted().
+			Where("s.deleted_at < ?", deletedBefore).
+			For("UPDATE").
+			Scan(ctx); err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		for _, hook := range r.purgeHooks {
+			if err := hook(ctx, tx, expired); err != nil {
+				return err
+			}
+		}
+
+		ids := make([]int, len(expired))
+		for i := range expired {
+			ids[i] = expired[i].ID
+		}
+		result, err := tx.NewDelete().
+			Model((*Student)(nil)).
+			WhereDeleted().
+			Where("s.id IN (?)", bun.In(ids)).
+			ForceDelete().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		purged = int(n)
+		return err
+	})
+
+	r.metrics.Database.RecordQuery(ctx, "delete", "students", time.Since(start), err)
+
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (r *repository) GetForUpdate(ctx context.Context, id int) (*Student, error) {
+	start := time.Now()
+	student := new(Student)
+	err := r.db.NewSelect().
+		Model(student).
+		WhereAllWithDeleted().
+		Where("id = ?", id).
+		For("UPDATE").
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrStudentNotFound
+		}
+		return nil, err
+	}
+	return student, nil
+}
+
+func (r *repository) AppendAudit(ctx context.Context, entries ...*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	_, err := r.db.NewInsert().Model(&entries).Exec(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "insert", "student_audit", time.Since(start), err)
+
+	return err
+}
+
+// History returns the audit entries of a student, oldest first
+func (r *repository) History(ctx context.Context, studentID int) ([]AuditEntry, error) {
+	start := time.Now()
+	entries := []AuditEntry{}
+	err := r.db.NewSelect().
+		Model(&entries).
+		Where("student_id = ?", studentID).
+		Order("id").
+		Scan(ctx)
+
+	r.metrics.Database.RecordQuery(ctx, "select", "student_audit", time.Since(start), err)
+
+	return entries, err
+}
+
+// GetByEmail returns the student with the given email, compared
+// case-insensitively
 func (r *repository) GetByEmail(ctx context.Context, email string) (*Student, error) {
 	start := time.Now()
 	student := new(Student)
 	err := r.db.NewSelect().
 		Model(student).
-		Where("email = ?", email).
+		Where("lower(s.email) = lower(?)", email).
 		Scan(ctx)
 
 	r.metrics.Database.RecordQuery(ctx, "select", "students", time.Since(start), err)
@@ -2,29 +2,63 @@ package student
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"student-service/internal/rbac"
+
+	"github.com/go-playground/validator/v10"
 )
 
 var (
 	ErrStudentNotFound = errors.New("student not found")
 	ErrInvalidInput    = errors.New("invalid input")
+	ErrInvalidCursor   = fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+	ErrVersionMismatch = errors.New("student was modified concurrently")
+	ErrEmailTaken      = errors.New("email already in use")
+	ErrNotInvited      = errors.New("student has no pending invitation")
 )
 
 type Service interface {
 	CreateStudent(ctx context.Context, student *Student) (*Student, error)
 	GetAllStudents(ctx context.Context) ([]Student, error)
+	ListStudents(ctx context.Context, opts ListOptions) (*Page, error)
+	ExportStudents(ctx context.Context, opts ListOptions, columns []ExportColumn, fn func(*Student) error) error
 	GetStudentByID(ctx context.Context, id int) (*Student, error)
 	UpdateStudent(ctx context.Context, student *Student) error
+	PatchStudent(ctx context.Context, id, version int, patch []byte) (*Student, error)
 	DeleteStudent(ctx context.Context, id int) error
+	RestoreStudent(ctx context.Context, id int) (*Student, error)
+	PurgeDeletedStudents(ctx context.Context, retention time.Duration) (int, error)
+	ImportStudents(ctx context.Context, src io.Reader, opts ImportOptions) (*ImportReport, error)
+	GetStudentHistory(ctx context.Context, id int) ([]AuditEntry, error)
+	SetStudentRole(ctx context.Context, id int, role rbac.Role) (*Student, error)
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	validate *validator.Validate
 }
 
 func NewService(repo Repository) Service {
+	validate := validator.New()
+	// Report JSON field names in validation errors
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
 	return &service{
-		repo: repo,
+		repo:     repo,
+		validate: validate,
 	}
 }
 
@@ -36,6 +70,33 @@ func (s *service) GetAllStudents(ctx context.Context) ([]Student, error) {
 	return s.repo.GetAll(ctx)
 }
 
+func (s *service) ListStudents(ctx context.Context, opts ListOptions) (*Page, error) {
+	if err := validateFilters(opts); err != nil {
+		return nil, err
+	}
+	return s.repo.List(ctx, opts)
+}
+
+// ExportStudents calls fn for every student matching the list filters in sort
+// order. Limit and Cursor are ignored. Only the given columns are read.
+func (s *service) ExportStudents(ctx context.Context, opts ListOptions, columns []ExportColumn, fn func(*Student) error) error {
+	if err := validateFilters(opts); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.Export(ctx, opts, columns, fn)
+}
+
+func validateFilters(opts ListOptions) error {
+	if opts.YearMin != nil && opts.YearMax != nil && *opts.YearMin > *opts.YearMax {
+		return ErrInvalidInput
+	}
+	_, err := parseSort(opts.Sort)
+	return err
+}
+
 func (s *service) GetStudentByID(ctx context.Context, id int) (*Student, error) {
 	if id <= 0 {
 		return nil, ErrInvalidInput
@@ -50,9 +111,109 @@ func (s *service) UpdateStudent(ctx context.Context, student *Student) error {
 	return s.repo.Update(ctx, student)
 }
 
+// PatchStudent applies an RFC 7396 JSON merge patch and writes only the patched
+// columns. A non-zero version makes the patch conditional on the current version;
+// without it the patch is still applied atomically against the row it was merged with.
+func (s *service) PatchStudent(ctx context.Context, id, version int, patch []byte) (*Student, error) {
+	if id <= 0 {
+		return nil, ErrInvalidInput
+	}
+
+	columns, err := patchColumns(patch, id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version > 0 && current.Version != version {
+		return nil, ErrVersionMismatch
+	}
+	if len(columns) == 0 {
+		return current, nil
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergePatch(doc, patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	var updated Student
+	if err := json.Unmarshal(merged, &updated); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	updated.ID = current.ID
+	updated.Password = current.Password
+	updated.Version = current.Version
+
+	if err := s.validate.Struct(&updated); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	if err := s.repo.Update(ctx, &updated, columns...); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
 func (s *service) DeleteStudent(ctx context.Context, id int) error {
 	if id <= 0 {
 		return ErrInvalidInput
 	}
 	return s.repo.Delete(ctx, id)
 }
+
+func (s *service) RestoreStudent(ctx context.Context, id int) (*Student, error) {
+	if id <= 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.repo.Restore(ctx, id)
+}
+
+// PurgeDeletedStudents permanently removes students that were soft-deleted
+// longer than retention ago
+func (s *service) PurgeDeletedStudents(ctx context.Context, retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, ErrInvalidInput
+	}
+	return s.repo.Purge(ctx, time.Now().Add(-retention))
+}
+
+// GetStudentHistory returns the audit trail of a student, oldest entry first.
+// The history outlives soft deletes and purges.
+func (s *service) GetStudentHistory(ctx context.Context, id int) ([]AuditEntry, error) {
+	if id <= 0 {
+		return nil, ErrInvalidInput
+	}
+	entries, err := s.repo.History(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		if _, err := s.repo.GetByID(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// SetStudentRole changes the role of a student. It takes effect in access
+// tokens issued after the change.
+func (s *service) SetStudentRole(ctx context.Context, id int, role rbac.Role) (*Student, error) {
+	if id <= 0 {
+		return nil, ErrInvalidInput
+	}
+	if _, err := rbac.ParseRole(string(role)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if err := s.repo.Update(ctx, &Student{ID: id, Role: role}, "role"); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(ctx, id)
+}